@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// defaultAdminClients 策略未配置 adminClients 时允许访问管理接口的地址
+var defaultAdminClients = []string{"127.0.0.0/8", "::1/128"}
+
+// adminOnly 限制管理接口只能由策略中 adminClients 列出的地址访问
+func adminOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		allowed := currentPolicy().AdminClients
+		if len(allowed) == 0 {
+			allowed = defaultAdminClients
+		}
+		if !clientInNetworks(r.RemoteAddr, allowed) {
+			http.Error(w, "Admin API access denied", http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// clientIP 从 RemoteAddr 中取出客户端 IP
+func clientIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// clientInNetworks 判断客户端地址是否属于给定的网段或 IP 列表
+func clientInNetworks(remoteAddr string, networks []string) bool {
+	ip := net.ParseIP(clientIP(remoteAddr))
+	if ip == nil {
+		return false
+	}
+	for _, n := range networks {
+		n = strings.TrimSpace(n)
+		if _, ipNet, err := net.ParseCIDR(n); err == nil {
+			if ipNet.Contains(ip) {
+				return true
+			}
+		} else if other := net.ParseIP(n); other != nil && other.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeJSON 以缩进格式返回 JSON
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const defaultProxyRealm = "proxy1" // 策略未配置 realm 时 Proxy-Authenticate 中的域
+
+// proxyAuthConfig 代理认证。只有密码与 users 一致的 Basic 凭据才算已认证，
+// 已认证的用户名用于 "user:" 规则成员、头部规则中的 $user 和按用户计数的配额；
+// 其他请求一律按客户端 IP 处理
+type proxyAuthConfig struct {
+	Users    map[string]string `json:"users"`    // 用户名 → 密码，"sha256:" 开头时为密码 SHA-256 摘要的十六进制
+	Required bool              `json:"required"` // 没有有效凭据的请求返回 407，否则按匿名客户端处理
+	Realm    string            `json:"realm"`
+}
+
+type proxyUserKey struct{}
+
+// verify 检查用户名和密码，比较时间与密码内容无关
+func (a proxyAuthConfig) verify(user, password string) bool {
+	stored, ok := a.Users[user]
+	if !ok {
+		return false
+	}
+	if digest, ok := strings.CutPrefix(stored, "sha256:"); ok {
+		sum := sha256.Sum256([]byte(password))
+		return subtle.ConstantTimeCompare([]byte(strings.ToLower(digest)), []byte(hex.EncodeToString(sum[:]))) == 1
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+}
+
+// proxyBasicAuth 取出 Proxy-Authorization 中的 Basic 用户名和密码
+func proxyBasicAuth(r *http.Request) (user, password string, ok bool) {
+	encoded, ok := strings.CutPrefix(r.Header.Get("Proxy-Authorization"), "Basic ")
+	if !ok {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
+
+// authenticateProxyUser 校验客户端的凭据，通过时把用户名记录在请求上下文中。
+// 策略要求认证而凭据缺失或错误时回应 407 并返回 false
+func authenticateProxyUser(p *proxyPolicy, w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	if user, password, ok := proxyBasicAuth(r); ok && p.ProxyAuth.verify(user, password) {
+		return r.WithContext(context.WithValue(r.Context(), proxyUserKey{}, user)), true
+	}
+	if !p.ProxyAuth.Required {
+		return r, true
+	}
+	realm := p.ProxyAuth.Realm
+	if realm == "" {
+		realm = defaultProxyRealm
+	}
+	w.Header().Set("Proxy-Authenticate", fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", realm))
+	http.Error(w, "Proxy authentication required", http.StatusProxyAuthRequired)
+	return r, false
+}
+
+// clientUser 返回经代理认证的用户名，未认证时为空
+func clientUser(r *http.Request) string {
+	user, _ := r.Context().Value(proxyUserKey{}).(string)
+	return user
+}
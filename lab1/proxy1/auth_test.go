@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// proxyLogin 为请求带上 Basic 凭据并经过代理认证
+func proxyLogin(p *proxyPolicy, r *http.Request, user, password string) *http.Request {
+	r.SetBasicAuth(user, password)
+	r.Header.Set("Proxy-Authorization", r.Header.Get("Authorization"))
+	r.Header.Del("Authorization")
+	r, _ = authenticateProxyUser(p, httptest.NewRecorder(), r)
+	return r
+}
+
+func TestProxyAuthVerify(t *testing.T) {
+	a := proxyAuthConfig{Users: map[string]string{
+		"alice": "secret",
+		// sha256("hunter2")
+		"bob": "sha256:f52fbd32b2b3b86ff88ef6c490628285f482af15ddcb29541f94bcf526a3f6c7",
+	}}
+	tests := []struct {
+		user, password string
+		want           bool
+	}{
+		{"alice", "secret", true},
+		{"alice", "Secret", false},
+		{"bob", "hunter2", true},
+		{"bob", "f52fbd32b2b3b86ff88ef6c490628285f482af15ddcb29541f94bcf526a3f6c7", false},
+		{"carol", "", false},
+	}
+	for _, tt := range tests {
+		if got := a.verify(tt.user, tt.password); got != tt.want {
+			t.Errorf("verify(%q, %q) = %v, want %v", tt.user, tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestProxyAuthRequired(t *testing.T) {
+	p := defaultPolicy()
+	p.ProxyAuth = proxyAuthConfig{Users: map[string]string{"alice": "secret"}, Required: true, Realm: "lab"}
+	withPolicy(t, p)
+
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+	w := httptest.NewRecorder()
+	handleRequest(w, r)
+	if w.Code != http.StatusProxyAuthRequired || w.Header().Get("Proxy-Authenticate") != `Basic realm="lab", charset="UTF-8"` {
+		t.Errorf("no credentials: status %d, Proxy-Authenticate %q", w.Code, w.Header().Get("Proxy-Authenticate"))
+	}
+
+	r = httptest.NewRequest("GET", "http://example.com/", nil)
+	r.Header.Set("Proxy-Authorization", "Basic YWxpY2U6d3Jvbmc=") // alice:wrong
+	w = httptest.NewRecorder()
+	if _, ok := authenticateProxyUser(p, w, r); ok || w.Code != http.StatusProxyAuthRequired {
+		t.Errorf("wrong password accepted: ok=%v status %d", ok, w.Code)
+	}
+}
+
+// TestProxyAuthSpoofedUser 没有通过认证的用户名不能冒用别人的配额，按客户端 IP 计数
+func TestProxyAuthSpoofedUser(t *testing.T) {
+	withQuotaFile(t)
+	p := defaultPolicy()
+	p.ProxyAuth.Users = map[string]string{"alice": "secret"}
+	p.Quotas = []quotaRule{{ID: "daily", PerUser: true, DailyRequests: 10}}
+
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+	r.RemoteAddr = "192.0.2.5:1000"
+	r = proxyLogin(p, r, "alice", "guess")
+	if user := clientUser(r); user != "" {
+		t.Fatalf("unauthenticated user %q trusted", user)
+	}
+	keys, _, _ := quotas.admit(p, r, time.Now())
+	if len(keys) != 1 || keys[0].key != "daily|ip:192.0.2.5" {
+		t.Errorf("quota keys %v, want the client address", keys)
+	}
+
+	r = proxyLogin(p, httptest.NewRequest("GET", "http://example.com/", nil), "alice", "secret")
+	if keys, _, _ := quotas.admit(p, r, time.Now()); len(keys) != 1 || keys[0].key != "daily|user:alice" {
+		t.Errorf("quota keys %v, want the authenticated user", keys)
+	}
+}
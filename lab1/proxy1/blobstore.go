@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// blob 按 SHA-256 寻址的响应体，refs 为引用它的内存缓存条目数
+type blob struct {
+	data []byte
+	refs int
+}
+
+// blobStore 缓存响应体的内容寻址存储。缓存条目只记录摘要，内容相同的响应体
+// （例如 CDN 镜像和只有查询参数不同的 URL）在内存和 cacheDir 中都只保存一份
+type blobStore struct {
+	mu   sync.Mutex
+	mem  map[string]*blob
+	disk map[string]map[string]int // cacheDir → 摘要 → 引用该 blob 的元数据文件数
+
+	integrityFailures atomic.Int64 // 读取时摘要不符的次数
+}
+
+var blobs = &blobStore{
+	mem:  make(map[string]*blob),
+	disk: make(map[string]map[string]int),
+}
+
+// blobDigest 返回内容的 SHA-256 十六进制摘要
+func blobDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// blobPath 返回 blob 在 cacheDir 中的路径，按摘要前两位分目录
+func blobPath(dir, digest string) string {
+	return filepath.Join(dir, "blobs", digest[:2], digest)
+}
+
+// acquire 增加条目响应体的引用。已有相同内容的 blob 时，条目改为共享已有的数据
+func (s *blobStore) acquire(entry *cachedResponse) {
+	if entry.digest == "" {
+		entry.digest = blobDigest(entry.body)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.mem[entry.digest]
+	if !ok {
+		b = &blob{data: entry.body}
+		s.mem[entry.digest] = b
+	}
+	b.refs++
+	entry.body = b.data
+}
+
+// release 减少条目响应体的引用，没有条目引用时释放 blob
+func (s *blobStore) release(entry *cachedResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.mem[entry.digest]
+	if !ok {
+		return
+	}
+	if b.refs--; b.refs <= 0 {
+		delete(s.mem, entry.digest)
+	}
+}
+
+// verify 检查数据与摘要是否一致，不一致时计入 integrityFailures
+func (s *blobStore) verify(digest string, data []byte) bool {
+	if blobDigest(data) == digest {
+		return true
+	}
+	s.integrityFailures.Add(1)
+	return false
+}
+
+// diskRefsLocked 返回 dir 中每个 blob 的引用数，第一次使用该目录时扫描元数据文件建立
+func (s *blobStore) diskRefsLocked(dir string) map[string]int {
+	if refs, ok := s.disk[dir]; ok {
+		return refs
+	}
+	refs := make(map[string]int)
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.cache"))
+	for _, path := range paths {
+		if meta, err := readCacheMeta(path); err == nil && meta.Digest != "" {
+			refs[meta.Digest]++
+		}
+	}
+	s.disk[dir] = refs
+	return refs
+}
+
+// link 保存 blob 文件（已存在时不重复写入）并增加引用，writeMeta 写入引用它的元数据文件。
+// 元数据文件原先引用的 blob 在写入成功后减少引用
+func (s *blobStore) link(dir, digest string, data []byte, metaPath string, writeMeta func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	refs := s.diskRefsLocked(dir)
+
+	path := blobPath(dir, digest)
+	if _, err := os.Stat(path); err != nil {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(path, data); err != nil {
+			return err
+		}
+	}
+	old, _ := readCacheMeta(metaPath)
+	if err := writeMeta(); err != nil {
+		if refs[digest] == 0 {
+			_ = os.Remove(path)
+		}
+		return err
+	}
+	refs[digest]++
+	if old != nil && old.Digest != "" {
+		s.unlinkLocked(dir, refs, old.Digest)
+	}
+	return nil
+}
+
+// unlink 删除元数据文件并减少其 blob 的引用
+func (s *blobStore) unlink(dir, metaPath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	meta, err := readCacheMeta(metaPath)
+	if err := os.Remove(metaPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil && meta.Digest != "" {
+		s.unlinkLocked(dir, s.diskRefsLocked(dir), meta.Digest)
+	}
+	return nil
+}
+
+// unlinkLocked 减少 blob 的引用，没有元数据文件引用时删除 blob 文件
+func (s *blobStore) unlinkLocked(dir string, refs map[string]int, digest string) {
+	if refs[digest]--; refs[digest] > 0 {
+		return
+	}
+	delete(refs, digest)
+	if err := os.Remove(blobPath(dir, digest)); err != nil && !os.IsNotExist(err) {
+		fmt.Println("Error removing cache blob:", err)
+	}
+}
+
+// discard 删除已损坏的 blob 文件，仍引用它的元数据文件在下次读取时被丢弃
+func (s *blobStore) discard(dir, digest string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.diskRefsLocked(dir), digest)
+	if err := os.Remove(blobPath(dir, digest)); err != nil && !os.IsNotExist(err) {
+		fmt.Println("Error removing cache blob:", err)
+	}
+}
+
+// stats 返回去重统计：blob 数、引用数、实际保存的字节数和因去重节省的字节数
+func (s *blobStore) stats() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var references, uniqueBytes, savedBytes int64
+	for _, b := range s.mem {
+		references += int64(b.refs)
+		uniqueBytes += int64(len(b.data))
+		savedBytes += int64(b.refs-1) * int64(len(b.data))
+	}
+	var diskBlobs, diskReferences int
+	for _, refs := range s.disk {
+		diskBlobs += len(refs)
+		for _, n := range refs {
+			diskReferences += n
+		}
+	}
+	return map[string]any{
+		"blobs":             len(s.mem),
+		"references":        references,
+		"uniqueBytes":       uniqueBytes,
+		"dedupSavedBytes":   savedBytes,
+		"diskBlobs":         diskBlobs,
+		"diskReferences":    diskReferences,
+		"integrityFailures": s.integrityFailures.Load(),
+	}
+}
+
+// writeFileAtomic 先写同目录下的临时文件再重命名，中途失败不会留下不完整的文件
+func writeFileAtomic(path string, chunks ...[]byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	for _, chunk := range chunks {
+		if _, err := tmp.Write(chunk); err != nil {
+			_ = tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// withBlobStore 为测试使用独立的 blob 存储
+func withBlobStore(t *testing.T) *blobStore {
+	t.Helper()
+	old := blobs
+	blobs = &blobStore{mem: make(map[string]*blob), disk: make(map[string]map[string]int)}
+	t.Cleanup(func() { blobs = old })
+	return blobs
+}
+
+// startMirrorOrigin 对所有路径和查询参数返回相同内容的源站，/other 返回不同内容
+func startMirrorOrigin(t *testing.T) *httptest.Server {
+	t.Helper()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/javascript")
+		if r.URL.Path == "/other" {
+			_, _ = io.WriteString(w, strings.Repeat("other();", 500))
+			return
+		}
+		_, _ = io.WriteString(w, strings.Repeat("library();", 500))
+	}))
+	t.Cleanup(origin.Close)
+	return origin
+}
+
+func TestBlobDedupInMemory(t *testing.T) {
+	withPolicy(t, defaultPolicy())
+	store := withBlobStore(t)
+	origin := startMirrorOrigin(t)
+
+	urls := []string{"/lib.js", "/lib.js?v=1", "/lib.js?v=2", "/mirror/lib.js", "/other"}
+	for _, u := range urls {
+		if w := proxyGet(origin.URL + u); w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", u, w.Code)
+		}
+	}
+	s := store.stats()
+	first, _ := lookupCache(origin.URL + urls[0])
+	if s["blobs"] != 2 || s["references"] != int64(5) || s["dedupSavedBytes"] != int64(3*len(first.body)) {
+		t.Errorf("stats after filling: %v", s)
+	}
+	for _, u := range urls[1:4] {
+		entry, _ := lookupCache(origin.URL + u)
+		if entry.digest != first.digest || &entry.body[0] != &first.body[0] {
+			t.Errorf("%s does not share the blob", u)
+		}
+	}
+	if snapshot := statsSnapshot()["dedup"].(map[string]any); snapshot["blobs"] != 2 {
+		t.Errorf("/stats dedup: %v", snapshot)
+	}
+
+	// 删除条目时减少引用，最后一个引用删除后释放 blob
+	removeCacheEntries(func(key string, _ http.Header) bool { return strings.Contains(key, "lib.js") })
+	if s := store.stats(); s["blobs"] != 1 || s["references"] != int64(1) || s["dedupSavedBytes"] != int64(0) {
+		t.Errorf("stats after purge: %v", s)
+	}
+}
+
+func TestBlobDedupOnDisk(t *testing.T) {
+	dir := t.TempDir()
+	store := withBlobStore(t)
+	shared := []byte(strings.Repeat("same bytes ", 400))
+	save := func(key string, body []byte) {
+		t.Helper()
+		entry, err := newCachedResponse(originResponse("", ""), body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := saveCacheEntry(dir, key, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	blobFiles := func() []string {
+		files, _ := filepath.Glob(filepath.Join(dir, "blobs", "*", "*"))
+		return files
+	}
+
+	save("http://a.example/lib.js", shared)
+	save("http://b.example/lib.js", shared)
+	save("http://a.example/lib.js", shared) // 重复保存不增加引用
+	if files := blobFiles(); len(files) != 1 {
+		t.Fatalf("blob files: %v", files)
+	}
+	if s := store.stats(); s["diskBlobs"] != 1 || s["diskReferences"] != 2 {
+		t.Errorf("disk stats: %v", s)
+	}
+
+	// 条目改为其他内容后，旧 blob 仍被另一个条目引用
+	save("http://a.example/lib.js", []byte(strings.Repeat("new version ", 400)))
+	if files := blobFiles(); len(files) != 2 {
+		t.Fatalf("blob files after update: %v", files)
+	}
+
+	// 重启后重新扫描引用数，删除最后一个引用时删除 blob 文件
+	store = withBlobStore(t)
+	if err := store.unlink(dir, cachePath(dir, "http://b.example/lib.js")); err != nil {
+		t.Fatal(err)
+	}
+	if files := blobFiles(); len(files) != 1 {
+		t.Errorf("blob files after unlink: %v", files)
+	}
+	entry, err := loadCacheEntry(dir, "http://a.example/lib.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := entry.identityBody(); string(body) != strings.Repeat("new version ", 400) {
+		t.Error("wrong body after update")
+	}
+}
+
+func TestCacheIntegrityOnRead(t *testing.T) {
+	p := defaultPolicy()
+	p.CacheDir = t.TempDir()
+	withPolicy(t, p)
+	store := withBlobStore(t)
+	origin := startMirrorOrigin(t)
+	target := origin.URL + "/lib.js"
+
+	proxyGet(target)
+	entry, ok := lookupCache(target)
+	if !ok {
+		t.Fatal("entry not cached")
+	}
+
+	// 内存中的数据损坏：条目被丢弃，改从磁盘重新加载
+	cacheMu.Lock()
+	corrupt := append([]byte(nil), entry.body...)
+	corrupt[len(corrupt)/2] ^= 0xff
+	entry.body = corrupt
+	cacheMu.Unlock()
+	reloaded, ok := lookupCache(target)
+	if !ok || reloaded == entry || !store.verify(reloaded.digest, reloaded.body) {
+		t.Fatal("corrupt memory entry was not replaced from disk")
+	}
+	if n := store.integrityFailures.Load(); n != 1 {
+		t.Errorf("integrity failures = %d, want 1", n)
+	}
+
+	// 磁盘上的 blob 也损坏：缓存未命中，下一次请求重新从源站获取
+	cacheMu.Lock()
+	reloaded.body = corrupt
+	cacheMu.Unlock()
+	path := blobPath(p.CacheDir, reloaded.digest)
+	data, _ := os.ReadFile(path)
+	data[0] ^= 0xff
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := lookupCache(target); ok {
+		t.Fatal("corrupt blob served from disk")
+	}
+	if n := store.integrityFailures.Load(); n != 3 {
+		t.Errorf("integrity failures = %d, want 3", n)
+	}
+	if w := proxyGet(target); w.Code != http.StatusOK || w.Body.String() != strings.Repeat("library();", 500) {
+		t.Errorf("refetch: status %d", w.Code)
+	}
+}
+
+func TestLegacyCacheFile(t *testing.T) {
+	dir := t.TempDir()
+	withBlobStore(t)
+	const key = "http://example.com/legacy"
+	// 旧格式：响应体紧跟在元数据之后
+	legacy := `{"url":"` + key + `","statusCode":200,"header":{"Content-Type":["text/plain"]},"size":5}` + "\nhello"
+	if err := os.WriteFile(cachePath(dir, key), []byte(legacy), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := loadCacheEntry(dir, key)
+	if err != nil || string(entry.body) != "hello" {
+		t.Fatalf("legacy entry: %v", err)
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultBlocklistRefresh 策略未配置 blocklistRefresh 时重新读取黑名单文件的间隔
+const defaultBlocklistRefresh = time.Hour
+
+// blocklistConfig 策略中的一个黑名单文件
+type blocklistConfig struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Format   string `json:"format"`   // "hosts"、"adblock"，为空时按行自动识别
+	Category string `json:"category"` // 显示在拦截页面上的分类
+	Status   int    `json:"status"`   // 拦截时的状态码，403 或 451
+
+	Schedule []scheduleWindow `json:"schedule"` // 黑名单生效的时间段，为空时始终生效
+}
+
+// blocklist 已加载的黑名单及其命中统计
+type blocklist struct {
+	name       string
+	path       string
+	category   string
+	status     int
+	schedule   []scheduleWindow
+	rules      int
+	exceptions int
+	loadedAt   time.Time
+	err        error
+	hits       atomic.Int64
+}
+
+// hostMatcher 由所有黑名单编译成的主机匹配表。
+// exact 只匹配主机名本身（hosts 格式），suffix 匹配域名及其所有子域名（Adblock 的 ||domain^）
+type hostMatcher struct {
+	exact      map[string]*blocklist
+	suffix     map[string]*blocklist
+	exceptions map[string]*blocklist // @@||domain^ 例外，优先于所有拦截规则
+	lists      []*blocklist
+}
+
+var (
+	blocklistMu      sync.Mutex // 串行化黑名单的加载
+	activeBlocklists atomic.Pointer[hostMatcher]
+	blocklistReload  = make(chan struct{}, 1)
+)
+
+func init() {
+	activeBlocklists.Store(newHostMatcher())
+	localMux.HandleFunc("/admin/blocklists", adminOnly(serveBlocklists))
+	onPolicyReload(func(*proxyPolicy) {
+		select {
+		case blocklistReload <- struct{}{}:
+		default:
+		}
+	})
+}
+
+func newHostMatcher() *hostMatcher {
+	return &hostMatcher{
+		exact:      make(map[string]*blocklist),
+		suffix:     make(map[string]*blocklist),
+		exceptions: make(map[string]*blocklist),
+	}
+}
+
+// watchBlocklists 在策略重新加载时以及按 blocklistRefresh 间隔重新编译黑名单
+func watchBlocklists() {
+	for {
+		loadBlocklists(currentPolicy().Blocklists)
+
+		refresh := defaultBlocklistRefresh
+		if d, err := time.ParseDuration(currentPolicy().BlocklistRefresh); err == nil && d > 0 {
+			refresh = d
+		}
+		timer := time.NewTimer(refresh)
+		select {
+		case <-blocklistReload:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// loadBlocklists 读取所有黑名单文件并替换当前的匹配表，读取失败的文件保留错误信息
+func loadBlocklists(configs []blocklistConfig) {
+	blocklistMu.Lock()
+	defer blocklistMu.Unlock()
+
+	// 保留同名黑名单的命中计数
+	previous := make(map[string]*blocklist)
+	for _, l := range activeBlocklists.Load().lists {
+		previous[l.name] = l
+	}
+
+	m := newHostMatcher()
+	for _, cfg := range configs {
+		name := cfg.Name
+		if name == "" {
+			name = cfg.Path
+		}
+		list := &blocklist{name: name, path: cfg.Path, category: cfg.Category, status: cfg.Status, schedule: cfg.Schedule, loadedAt: time.Now()}
+		if old, ok := previous[name]; ok {
+			list.hits.Store(old.hits.Load())
+		}
+		m.lists = append(m.lists, list)
+
+		f, err := os.Open(cfg.Path)
+		if err != nil {
+			list.err = err
+			fmt.Println("Error loading blocklist:", err)
+			continue
+		}
+		list.err = m.compile(list, f, cfg.Format)
+		_ = f.Close()
+		if list.err != nil {
+			fmt.Println("Error loading blocklist:", list.err)
+			continue
+		}
+		fmt.Printf("Blocklist %s: %d rules, %d exceptions\n", name, list.rules, list.exceptions)
+	}
+	activeBlocklists.Store(m)
+}
+
+// compile 解析黑名单内容并加入匹配表
+func (m *hostMatcher) compile(list *blocklist, r io.Reader, format string) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == '!' || line[0] == '[' {
+			continue
+		}
+		isAdblock := strings.HasPrefix(line, "||") || strings.HasPrefix(line, "@@")
+		switch {
+		case format == "adblock" || format == "" && isAdblock:
+			m.addAdblockRule(list, line)
+		case format == "hosts" || format == "":
+			m.addHostsLine(list, line)
+		default:
+			return fmt.Errorf("blocklist %s: unknown format %q", list.name, format)
+		}
+	}
+	return scanner.Err()
+}
+
+// addHostsLine 解析 hosts 格式的一行，例如 "0.0.0.0 ads.example.com tracker.example.com"
+func (m *hostMatcher) addHostsLine(list *blocklist, line string) {
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 2 || net.ParseIP(fields[0]) == nil {
+		return
+	}
+	for _, host := range fields[1:] {
+		host = normalizeHost(host)
+		switch host {
+		case "", "localhost", "localhost.localdomain", "local", "broadcasthost", "0.0.0.0":
+			continue
+		}
+		m.exact[host] = list
+		list.rules++
+	}
+}
+
+// addAdblockRule 解析 Adblock Plus 语法中只针对域名的规则：||domain^ 和 @@||domain^。
+// 带有 $ 选项、路径或通配符的规则无法在主机层面判断，直接忽略
+func (m *hostMatcher) addAdblockRule(list *blocklist, line string) {
+	exception := strings.HasPrefix(line, "@@")
+	line = strings.TrimPrefix(line, "@@")
+	domain, ok := strings.CutPrefix(line, "||")
+	if !ok {
+		return
+	}
+	domain, ok = strings.CutSuffix(domain, "^")
+	if !ok || strings.ContainsAny(domain, "/*$^|") {
+		return
+	}
+	domain = normalizeHost(domain)
+	if domain == "" {
+		return
+	}
+	if exception {
+		m.exceptions[domain] = list
+		list.exceptions++
+	} else {
+		m.suffix[domain] = list
+		list.rules++
+	}
+}
+
+// normalizeHost 去除端口和结尾的点并转为小写
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// match 返回拦截该主机的黑名单，例外规则命中或无匹配时返回 nil
+func (m *hostMatcher) match(host string) *blocklist {
+	host = normalizeHost(host)
+	if host == "" {
+		return nil
+	}
+	for d := host; ; {
+		if _, ok := m.exceptions[d]; ok {
+			return nil
+		}
+		i := strings.IndexByte(d, '.')
+		if i < 0 {
+			break
+		}
+		d = d[i+1:]
+	}
+	if list, ok := m.exact[host]; ok {
+		return list
+	}
+	for d := host; ; {
+		if list, ok := m.suffix[d]; ok {
+			return list
+		}
+		i := strings.IndexByte(d, '.')
+		if i < 0 {
+			return nil
+		}
+		d = d[i+1:]
+	}
+}
+
+// matchBlocklists 检查主机是否在已导入的黑名单中，命中时计数并返回拦截原因
+func matchBlocklists(host string) (blockDecision, bool) {
+	list := activeBlocklists.Load().match(host)
+	if list == nil || !scheduleActive(list.schedule, scheduleNow()) {
+		return blockDecision{}, false
+	}
+	list.hits.Add(1)
+	return blockDecision{
+		RuleID:   "blocklist:" + list.name,
+		Category: list.category,
+		Reason:   "This site is listed in the " + list.name + " blocklist.",
+		Status:   list.status,
+	}, true
+}
+
+// blocklistStats 返回每个黑名单的规则数与命中次数
+func blocklistStats() []map[string]any {
+	var result []map[string]any
+	for _, l := range activeBlocklists.Load().lists {
+		item := map[string]any{
+			"name":       l.name,
+			"path":       l.path,
+			"rules":      l.rules,
+			"exceptions": l.exceptions,
+			"hits":       l.hits.Load(),
+			"loadedAt":   l.loadedAt,
+		}
+		if l.err != nil {
+			item["error"] = l.err.Error()
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+// serveBlocklists GET 返回黑名单统计，POST 立即重新加载
+func serveBlocklists(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		loadBlocklists(currentPolicy().Blocklists)
+	}
+	writeJSON(w, http.StatusOK, blocklistStats())
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testHostsList = `# hosts-file blocklist
+127.0.0.1 localhost
+0.0.0.0 ads.example.com tracker.example.com # inline comment
+0.0.0.0 0.0.0.0
+::1 ip6-ads.example.net
+not-an-ip bogus.example.com
+`
+
+const testAdblockList = `[Adblock Plus 2.0]
+! comment
+||doubleclick.net^
+||analytics.example.org^
+@@||good.analytics.example.org^
+||example.com/path^
+||cdn.example.com^$third-party
+example.com##.banner
+`
+
+func TestHostMatcher(t *testing.T) {
+	m := newHostMatcher()
+	hosts := &blocklist{name: "hosts"}
+	adblock := &blocklist{name: "adblock"}
+	if err := m.compile(hosts, strings.NewReader(testHostsList), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.compile(adblock, strings.NewReader(testAdblockList), "adblock"); err != nil {
+		t.Fatal(err)
+	}
+	if hosts.rules != 3 {
+		t.Errorf("hosts rules = %d, want 3", hosts.rules)
+	}
+	if adblock.rules != 2 || adblock.exceptions != 1 {
+		t.Errorf("adblock rules = %d, exceptions = %d; want 2, 1", adblock.rules, adblock.exceptions)
+	}
+
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"ads.example.com", "hosts"},
+		{"ADS.example.com.", "hosts"},
+		{"ads.example.com:8080", "hosts"},
+		{"sub.ads.example.com", ""}, // hosts 格式只匹配主机名本身
+		{"ip6-ads.example.net", "hosts"},
+		{"bogus.example.com", ""},
+		{"localhost", ""},
+		{"doubleclick.net", "adblock"},
+		{"ad.g.doubleclick.net", "adblock"},
+		{"notdoubleclick.net", ""},
+		{"analytics.example.org", "adblock"},
+		{"good.analytics.example.org", ""},
+		{"x.good.analytics.example.org", ""},
+		{"example.com", ""},
+		{"cdn.example.com", ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if list := m.match(tt.host); list != nil {
+			got = list.name
+		}
+		if got != tt.want {
+			t.Errorf("match(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestLoadBlocklistsHits(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ads.txt")
+	if err := os.WriteFile(path, []byte("0.0.0.0 ads.example.com\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	configs := []blocklistConfig{
+		{Name: "ads", Path: path},
+		{Name: "missing", Path: filepath.Join(dir, "missing.txt")},
+	}
+	loadBlocklists(configs)
+	defer loadBlocklists(nil)
+
+	for i := 0; i < 3; i++ {
+		if d, ok := matchBlocklists("ads.example.com"); !ok || d.RuleID != "blocklist:ads" {
+			t.Fatalf("matchBlocklists = %+v, %v", d, ok)
+		}
+	}
+	if _, ok := matchBlocklists("www.example.com"); ok {
+		t.Error("unexpected match")
+	}
+
+	// 重新加载后保留命中计数
+	loadBlocklists(configs)
+	stats := blocklistStats()
+	if len(stats) != 2 {
+		t.Fatalf("got %d lists", len(stats))
+	}
+	if stats[0]["hits"] != int64(3) {
+		t.Errorf("hits = %v, want 3", stats[0]["hits"])
+	}
+	if _, ok := stats[1]["error"]; !ok {
+		t.Error("missing list has no error")
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+)
+
+// blockDecision 一次拦截的原因，用于渲染拦截页面
+type blockDecision struct {
+	RuleID   string `json:"rule"`
+	Category string `json:"category,omitempty"`
+	Reason   string `json:"reason"`
+	Status   int    `json:"status"`
+}
+
+// siteRule 策略中带编号的网站过滤规则，URL 包含 Match 时拦截
+type siteRule struct {
+	ID       string `json:"id"`
+	Match    string `json:"match"`
+	Category string `json:"category"`
+	Reason   string `json:"reason"`
+	Status   int    `json:"status"` // 403 或 451，为空时使用 403
+
+	Schedule []scheduleWindow `json:"schedule"` // 规则生效的时间段，为空时始终生效
+}
+
+// blockPageConfig 拦截页面的模板与联系方式
+type blockPageConfig struct {
+	HTMLTemplate string `json:"htmlTemplate"` // HTML 模板文件路径，为空时使用内置模板
+	TextTemplate string `json:"textTemplate"` // 纯文本模板文件路径，为空时使用内置模板
+	Contact      string `json:"contact"`      // 联系链接，例如 mailto:netadmin@hit.edu.cn
+}
+
+// blockPageData 模板中可用的变量
+type blockPageData struct {
+	blockDecision
+	StatusText string
+	Client     string
+	URL        string
+	Host       string
+	Contact    string
+	Time       string
+}
+
+const defaultBlockHTML = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Status}} {{.StatusText}}</title></head>
+<body>
+<h1>{{.StatusText}}</h1>
+<p>{{.Reason}}</p>
+<table>
+<tr><th align="left">URL</th><td>{{.URL}}</td></tr>
+<tr><th align="left">Rule</th><td>{{.RuleID}}</td></tr>
+{{if .Category}}<tr><th align="left">Category</th><td>{{.Category}}</td></tr>
+{{end}}<tr><th align="left">Client</th><td>{{.Client}}</td></tr>
+<tr><th align="left">Time</th><td>{{.Time}}</td></tr>
+</table>
+{{if .Contact}}<p>If you believe this is a mistake, <a href="{{.Contact}}">contact the administrator</a>.</p>
+{{end}}</body>
+</html>
+`
+
+const defaultBlockText = `{{.StatusText}}: {{.Reason}}
+URL: {{.URL}}
+Rule: {{.RuleID}}{{if .Category}}
+Category: {{.Category}}{{end}}
+Client: {{.Client}}
+{{if .Contact}}Contact: {{.Contact}}
+{{end}}`
+
+var (
+	blockPageMu   sync.RWMutex
+	blockHTMLTmpl = htmltemplate.Must(htmltemplate.New("block").Parse(defaultBlockHTML))
+	blockTextTmpl = texttemplate.Must(texttemplate.New("block").Parse(defaultBlockText))
+)
+
+func init() {
+	onPolicyReload(loadBlockPageTemplates)
+}
+
+// loadBlockPageTemplates 根据策略加载自定义模板，加载失败时保留内置模板
+func loadBlockPageTemplates(p *proxyPolicy) {
+	htmlTmpl := htmltemplate.Must(htmltemplate.New("block").Parse(defaultBlockHTML))
+	textTmpl := texttemplate.Must(texttemplate.New("block").Parse(defaultBlockText))
+
+	if path := p.BlockPage.HTMLTemplate; path != "" {
+		if data, err := os.ReadFile(path); err != nil {
+			fmt.Println("Error loading block page template:", err)
+		} else if t, err := htmltemplate.New("block").Parse(string(data)); err != nil {
+			fmt.Println("Error parsing block page template:", err)
+		} else {
+			htmlTmpl = t
+		}
+	}
+	if path := p.BlockPage.TextTemplate; path != "" {
+		if data, err := os.ReadFile(path); err != nil {
+			fmt.Println("Error loading block page template:", err)
+		} else if t, err := texttemplate.New("block").Parse(string(data)); err != nil {
+			fmt.Println("Error parsing block page template:", err)
+		} else {
+			textTmpl = t
+		}
+	}
+
+	blockPageMu.Lock()
+	blockHTMLTmpl, blockTextTmpl = htmlTmpl, textTmpl
+	blockPageMu.Unlock()
+}
+
+// blockStatus 规则只允许使用 403 或 451
+func blockStatus(status int) int {
+	if status == http.StatusUnavailableForLegalReasons {
+		return status
+	}
+	return http.StatusForbidden
+}
+
+// denyRequest 按客户端的 Accept 头部返回 HTML、JSON 或纯文本的拦截页面
+func denyRequest(w http.ResponseWriter, r *http.Request, d blockDecision) {
+	d.Status = blockStatus(d.Status)
+	data := blockPageData{
+		blockDecision: d,
+		StatusText:    http.StatusText(d.Status),
+		Client:        clientIP(r.RemoteAddr),
+		URL:           r.URL.String(),
+		Host:          r.URL.Host,
+		Contact:       currentPolicy().BlockPage.Contact,
+		Time:          time.Now().Format(time.RFC1123),
+	}
+
+	blockPageMu.RLock()
+	htmlTmpl, textTmpl := blockHTMLTmpl, blockTextTmpl
+	blockPageMu.RUnlock()
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Proxy-Block-Rule", d.RuleID)
+	switch preferredBlockFormat(r.Header.Get("Accept")) {
+	case "json":
+		writeJSON(w, d.Status, map[string]any{
+			"error":    data.StatusText,
+			"rule":     d.RuleID,
+			"category": d.Category,
+			"reason":   d.Reason,
+			"status":   d.Status,
+			"client":   data.Client,
+			"url":      data.URL,
+			"contact":  data.Contact,
+		})
+	case "html":
+		var b strings.Builder
+		if err := htmlTmpl.Execute(&b, data); err != nil {
+			fmt.Println("Error rendering block page:", err)
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(d.Status)
+		_, _ = w.Write([]byte(b.String()))
+	default:
+		var b strings.Builder
+		if err := textTmpl.Execute(&b, data); err != nil {
+			fmt.Println("Error rendering block page:", err)
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(d.Status)
+		_, _ = w.Write([]byte(b.String()))
+	}
+}
+
+// preferredBlockFormat 根据 Accept 头部选择 "html"、"json" 或 "text"，q 值相同时依次优先 HTML、JSON
+func preferredBlockFormat(accept string) string {
+	if accept == "" {
+		return "text"
+	}
+	values := parseQualityValues(accept)
+	quality := func(mediaType string) float64 {
+		if q, ok := values[mediaType]; ok {
+			return q
+		}
+		major, _, _ := strings.Cut(mediaType, "/")
+		if q, ok := values[major+"/*"]; ok {
+			return q
+		}
+		return values["*/*"]
+	}
+
+	best, bestQ := "text", quality("text/plain")
+	for _, c := range []struct{ format, mediaType string }{
+		{"json", "application/json"},
+		{"html", "text/html"},
+	} {
+		if q := quality(c.mediaType); q > 0 && q >= bestQ {
+			best, bestQ = c.format, q
+		}
+	}
+	return best
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// withPolicy 在测试期间替换当前策略
+func withPolicy(t *testing.T, p *proxyPolicy) {
+	t.Helper()
+	policyMu.Lock()
+	old := activePolicy
+	activePolicy = p
+	policyMu.Unlock()
+	t.Cleanup(func() {
+		policyMu.Lock()
+		activePolicy = old
+		policyMu.Unlock()
+	})
+}
+
+func TestPreferredBlockFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", "text"},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", "html"},
+		{"application/json", "json"},
+		{"application/json, text/plain;q=0.5", "json"},
+		{"text/plain", "text"},
+		{"text/*", "html"},
+		{"text/html;q=0.1, application/json;q=0.9", "json"},
+		{"image/png", "text"},
+	}
+	for _, tt := range tests {
+		if got := preferredBlockFormat(tt.accept); got != tt.want {
+			t.Errorf("preferredBlockFormat(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestBlockPages(t *testing.T) {
+	p := defaultPolicy()
+	p.ForbidSites = true
+	p.InvalidWebsites = []string{"http://blocked.example/"}
+	p.SiteRules = []siteRule{
+		{ID: "court-order-7", Match: "legal.example", Category: "legal", Reason: "Blocked by court order.", Status: 451},
+		{Match: "bad-status.example", Status: 500},
+	}
+	p.BlockPage.Contact = "mailto:netadmin@example.edu"
+	withPolicy(t, p)
+
+	tests := []struct {
+		url        string
+		accept     string
+		wantStatus int
+		wantType   string
+		wantBody   []string
+	}{
+		{"http://legal.example/page", "application/json", 451, "application/json",
+			[]string{`"rule": "court-order-7"`, `"category": "legal"`, `"client": "192.0.2.10"`}},
+		{"http://legal.example/page", "text/html", 451, "text/html",
+			[]string{"court-order-7", "Blocked by court order.", `href="mailto:netadmin@example.edu"`}},
+		{"http://blocked.example/", "", 403, "text/plain",
+			[]string{"Forbidden", "Rule: invalid-website-1", "Contact: mailto:netadmin@example.edu"}},
+		{"http://bad-status.example/", "", 403, "text/plain", []string{"Rule: site-rule-2"}},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.url, nil)
+		r.RemoteAddr = "192.0.2.10:1234"
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		w := httptest.NewRecorder()
+		handleRequest(w, r)
+
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d", tt.url, w.Code, tt.wantStatus)
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, tt.wantType) {
+			t.Errorf("%s: Content-Type %q, want %q", tt.url, ct, tt.wantType)
+		}
+		for _, s := range tt.wantBody {
+			if !strings.Contains(w.Body.String(), s) {
+				t.Errorf("%s: body missing %q:\n%s", tt.url, s, w.Body.String())
+			}
+		}
+		if tt.wantType == "application/json" && !json.Valid(w.Body.Bytes()) {
+			t.Errorf("%s: invalid JSON body", tt.url)
+		}
+	}
+}
+
+func TestBlockPageEscapesHTML(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://x.example/<script>", nil)
+	r.Header.Set("Accept", "text/html")
+	w := httptest.NewRecorder()
+	denyRequest(w, r, blockDecision{RuleID: "<b>rule</b>", Status: http.StatusForbidden})
+	if strings.Contains(w.Body.String(), "<b>rule</b>") {
+		t.Error("rule ID was not escaped")
+	}
+}
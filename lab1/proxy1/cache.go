@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	cacheMu sync.RWMutex
+	cache   = make(map[string]*cachedResponse)
+)
+
+type cachedResponse struct {
+	response       *http.Response // 仅使用状态码和头部，不含 Content-Encoding/Content-Length
+	body           []byte         // 响应体，encoding 非空时为压缩后的数据；与内容相同的条目共享
+	digest         string         // body 的 SHA-256 摘要，即 blob 的地址
+	encoding       string         // body 的存储编码，空字符串表示未压缩
+	originEncoding string         // 源站响应的 Content-Encoding，用于判断发送的表示是否与源站一致
+	size           int            // 响应体解压后的长度
+	compressible   bool           // 是否可以按客户端能力协商压缩
+	timestamp      time.Time
+}
+
+// cacheMeta 缓存条目在磁盘上的元数据
+type cacheMeta struct {
+	URL            string      `json:"url"`
+	StatusCode     int         `json:"statusCode"`
+	Header         http.Header `json:"header"`
+	Encoding       string      `json:"encoding"`
+	OriginEncoding string      `json:"originEncoding"`
+	Size           int         `json:"size"`
+	Compressible   bool        `json:"compressible"`
+	Timestamp      time.Time   `json:"timestamp"`
+	Digest         string      `json:"digest,omitempty"` // 响应体 blob 的摘要，为空时响应体跟在元数据之后（旧格式）
+}
+
+// newCachedResponse 将源站响应转换为规范的缓存形式：
+// 可压缩的内容统一以 storageEncoding 存储，其余内容以原文存储
+func newCachedResponse(resp *http.Response, body []byte) (*cachedResponse, error) {
+	encoding := normalizeEncoding(resp.Header.Get("Content-Encoding"))
+	identity, err := decodeBody(encoding, body)
+	if err != nil {
+		return nil, err
+	}
+
+	header := resp.Header.Clone()
+	header.Del("Content-Encoding")
+	header.Del("Content-Length")
+
+	entry := &cachedResponse{
+		response:       &http.Response{StatusCode: resp.StatusCode, Header: header},
+		body:           identity,
+		originEncoding: encoding,
+		size:           len(identity),
+		timestamp:      time.Now(),
+	}
+	entry.compressible = resp.StatusCode == http.StatusOK &&
+		isCompressible(header.Get("Content-Type")) && !hasNoTransform(header)
+	if !entry.compressible {
+		return entry, nil
+	}
+
+	stored := body
+	if encoding != storageEncoding {
+		if stored, err = encodeBody(storageEncoding, identity); err != nil {
+			return nil, err
+		}
+	}
+	// 压缩后没有变小时保留原文
+	if len(stored) < len(identity) {
+		entry.body, entry.encoding = stored, storageEncoding
+	}
+	return entry, nil
+}
+
+// passthroughResponse 包装代理无法解压的响应，按源站的编码原样发送，不参与协商和缓存
+func passthroughResponse(resp *http.Response, body []byte) *cachedResponse {
+	header := resp.Header.Clone()
+	header.Del("Content-Length")
+	encoding := resp.Header.Get("Content-Encoding")
+	return &cachedResponse{
+		response:       &http.Response{StatusCode: resp.StatusCode, Header: header},
+		body:           body,
+		encoding:       encoding,
+		originEncoding: encoding,
+		size:           len(body),
+		timestamp:      time.Now(),
+	}
+}
+
+// identityBody 返回解压后的响应体
+func (c *cachedResponse) identityBody() ([]byte, error) {
+	return decodeBody(c.encoding, c.body)
+}
+
+// hasNoTransform 源站声明 Cache-Control: no-transform 时代理不得改变内容编码
+func hasNoTransform(header http.Header) bool {
+	for _, v := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(directive), "no-transform") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lookupCache 查找缓存，内存未命中时尝试从磁盘加载
+func lookupCache(key string) (*cachedResponse, bool) {
+	cacheMu.RLock()
+	entry, found := cache[key]
+	cacheMu.RUnlock()
+	// 每次读取都校验响应体，内容损坏的条目视为未命中
+	if found && !blobs.verify(entry.digest, entry.body) {
+		fmt.Println("Cache entry failed integrity check:", key)
+		cacheMu.Lock()
+		if cache[key] == entry {
+			delete(cache, key)
+			accountCacheEntry(entry, -1)
+		}
+		cacheMu.Unlock()
+		entry, found = nil, false
+	}
+	dir := currentPolicy().CacheDir
+	if found || dir == "" {
+		return entry, found
+	}
+
+	entry, err := loadCacheEntry(dir, key)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Println("Error loading cache entry:", err)
+		}
+		return nil, false
+	}
+	cacheMu.Lock()
+	if old, ok := cache[key]; ok {
+		entry = old
+	} else {
+		cache[key] = entry
+		accountCacheEntry(entry, 1)
+	}
+	cacheMu.Unlock()
+	return entry, true
+}
+
+// storeCache 保存缓存条目，并在策略配置了 cacheDir 时写入磁盘
+func storeCache(key string, entry *cachedResponse) {
+	cacheMu.Lock()
+	if old, ok := cache[key]; ok {
+		accountCacheEntry(old, -1)
+	}
+	cache[key] = entry
+	accountCacheEntry(entry, 1)
+	cacheMu.Unlock()
+
+	if dir := currentPolicy().CacheDir; dir != "" {
+		if err := saveCacheEntry(dir, key, entry); err != nil {
+			fmt.Println("Error saving cache entry:", err)
+		}
+	}
+}
+
+// accountCacheEntry 更新缓存占用统计和 blob 引用，sign 为 1 表示加入，-1 表示移除。
+// 调用方持有 cacheMu；加入时响应体与已有条目相同的条目改为共享已有的 blob
+func accountCacheEntry(entry *cachedResponse, sign int64) {
+	if sign > 0 {
+		blobs.acquire(entry)
+	} else {
+		blobs.release(entry)
+	}
+	stats.CacheStoredBytes.Add(sign * int64(len(entry.body)))
+	stats.CacheIdentBytes.Add(sign * int64(entry.size))
+}
+
+// cachePath 返回缓存键对应的磁盘文件路径
+func cachePath(dir, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(dir, hex.EncodeToString(sum[:])+".cache")
+}
+
+// saveCacheEntry 将元数据（一行 JSON）写入按缓存键命名的文件，响应体按摘要写入 blobs 目录，
+// 内容相同的条目共用一个 blob 文件。两者都先写临时文件再重命名，中途崩溃不会留下不完整的文件
+func saveCacheEntry(dir, key string, entry *cachedResponse) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	if entry.digest == "" {
+		entry.digest = blobDigest(entry.body)
+	}
+	meta, err := json.Marshal(cacheMeta{
+		URL:            key,
+		StatusCode:     entry.response.StatusCode,
+		Header:         entry.response.Header,
+		Encoding:       entry.encoding,
+		OriginEncoding: entry.originEncoding,
+		Size:           entry.size,
+		Compressible:   entry.compressible,
+		Timestamp:      entry.timestamp,
+		Digest:         entry.digest,
+	})
+	if err != nil {
+		return err
+	}
+	path := cachePath(dir, key)
+	return blobs.link(dir, entry.digest, entry.body, path, func() error {
+		return writeFileAtomic(path, append(meta, '\n'))
+	})
+}
+
+// loadCacheEntry 读取磁盘上的缓存条目并校验 blob 的摘要，内容损坏、无法解压或长度不符时删除该条目
+func loadCacheEntry(dir, key string) (*cachedResponse, error) {
+	path := cachePath(dir, key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	metaLine, body, ok := bytes.Cut(data, []byte{'\n'})
+	if !ok {
+		return nil, discardCacheFile(path, fmt.Errorf("malformed cache file %s", path))
+	}
+	var meta cacheMeta
+	if err := json.Unmarshal(metaLine, &meta); err != nil {
+		return nil, discardCacheFile(path, err)
+	}
+	if meta.URL != key {
+		return nil, os.ErrNotExist
+	}
+	if meta.Digest != "" {
+		body, err = os.ReadFile(blobPath(dir, meta.Digest))
+		if err != nil {
+			return nil, discardCacheEntry(dir, path, fmt.Errorf("cache file %s: %w", path, err))
+		}
+		if !blobs.verify(meta.Digest, body) {
+			blobs.discard(dir, meta.Digest)
+			return nil, discardCacheEntry(dir, path, fmt.Errorf("cache blob %s: digest mismatch", meta.Digest))
+		}
+	}
+
+	entry := &cachedResponse{
+		response:       &http.Response{StatusCode: meta.StatusCode, Header: meta.Header},
+		body:           body,
+		encoding:       meta.Encoding,
+		originEncoding: meta.OriginEncoding,
+		size:           meta.Size,
+		compressible:   meta.Compressible,
+		timestamp:      meta.Timestamp,
+		digest:         meta.Digest,
+	}
+	identity, err := entry.identityBody()
+	if err != nil {
+		return nil, discardCacheEntry(dir, path, err)
+	}
+	if len(identity) != entry.size {
+		return nil, discardCacheEntry(dir, path, fmt.Errorf("cache file %s: size mismatch", path))
+	}
+	return entry, nil
+}
+
+// discardCacheEntry 删除损坏的缓存条目（元数据文件及其 blob 引用）并返回原因
+func discardCacheEntry(dir, path string, cause error) error {
+	if err := blobs.unlink(dir, path); err != nil {
+		fmt.Println("Error removing cache file:", err)
+	}
+	return cause
+}
+
+// discardCacheFile 删除损坏的缓存文件并返回原因
+func discardCacheFile(path string, cause error) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		fmt.Println("Error removing cache file:", err)
+	}
+	return cause
+}
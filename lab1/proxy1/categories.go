@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// categoryRule 按网站分类拦截的规则，例如上课时间禁止学生访问游戏和视频网站
+type categoryRule struct {
+	ID         string   `json:"id"`
+	Categories []string `json:"categories"` // 分类数据库中的分类名
+	Groups     []string `json:"groups"`     // 适用的 clientGroups 分组
+	Clients    []string `json:"clients"`    // 适用的 IP、网段或 "user:用户名"；与 Groups 都为空时适用于所有客户端
+	Reason     string   `json:"reason"`
+	Status     int      `json:"status"` // 403 或 451，为空时使用 403
+
+	Schedule []scheduleWindow `json:"schedule"` // 规则生效的时间段，为空时始终生效
+}
+
+// categoryDB 从本地文件加载的分类数据库。
+// suffix 匹配域名及其所有子域名，exact 只匹配主机名本身（文件中以 "=" 开头）
+type categoryDB struct {
+	path     string
+	exact    map[string][]string
+	suffix   map[string][]string
+	entries  int
+	modTime  time.Time
+	loadedAt time.Time
+}
+
+var (
+	categoryMu       sync.Mutex // 串行化分类数据库的加载
+	activeCategories atomic.Pointer[categoryDB]
+	categoryLoadErr  atomic.Value // 最近一次加载失败的原因，成功时为空字符串
+	categoryReload   = make(chan struct{}, 1)
+)
+
+func init() {
+	activeCategories.Store(newCategoryDB(""))
+	categoryLoadErr.Store("")
+	localMux.HandleFunc("/admin/categories", adminOnly(serveCategories))
+	onPolicyReload(func(*proxyPolicy) {
+		select {
+		case categoryReload <- struct{}{}:
+		default:
+		}
+	})
+}
+
+func newCategoryDB(path string) *categoryDB {
+	return &categoryDB{
+		path:   path,
+		exact:  make(map[string][]string),
+		suffix: make(map[string][]string),
+	}
+}
+
+// parseCategoryDB 解析分类数据库，每行为域名和逗号分隔的分类，例如：
+//
+//	# 注释
+//	youtube.com          video
+//	steampowered.com     games,shopping
+//	=play.example.com    games
+func parseCategoryDB(path string, r io.Reader) (*categoryDB, error) {
+	db := newCategoryDB(path)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want \"domain category[,category]\"", path, n)
+		}
+		table := db.suffix
+		domain, exact := strings.CutPrefix(fields[0], "=")
+		if exact {
+			table = db.exact
+		}
+		domain = normalizeHost(strings.TrimPrefix(strings.TrimPrefix(domain, "*"), "."))
+		if domain == "" {
+			return nil, fmt.Errorf("%s:%d: empty domain", path, n)
+		}
+		for _, category := range strings.Split(fields[1], ",") {
+			if category = strings.ToLower(strings.TrimSpace(category)); category != "" {
+				table[domain] = append(table[domain], category)
+			}
+		}
+		db.entries++
+	}
+	return db, scanner.Err()
+}
+
+// lookup 返回主机所属的分类：精确匹配优先，其次是最长的域名后缀
+func (db *categoryDB) lookup(host string) []string {
+	host = normalizeHost(host)
+	if host == "" {
+		return nil
+	}
+	if categories, ok := db.exact[host]; ok {
+		return categories
+	}
+	for d := host; ; {
+		if categories, ok := db.suffix[d]; ok {
+			return categories
+		}
+		i := strings.IndexByte(d, '.')
+		if i < 0 {
+			return nil
+		}
+		d = d[i+1:]
+	}
+}
+
+// categoryCounts 返回每个分类的条目数
+func (db *categoryDB) categoryCounts() map[string]int {
+	counts := make(map[string]int)
+	for _, table := range []map[string][]string{db.exact, db.suffix} {
+		for _, categories := range table {
+			for _, c := range categories {
+				counts[c]++
+			}
+		}
+	}
+	return counts
+}
+
+// loadCategoryDB 读取分类数据库并替换当前的数据库。文件未变化时不重新解析，
+// 读取或解析失败时保留原来的数据库，避免更新出错时过滤失效
+func loadCategoryDB(path string, force bool) {
+	categoryMu.Lock()
+	defer categoryMu.Unlock()
+
+	if path == "" {
+		activeCategories.Store(newCategoryDB(""))
+		categoryLoadErr.Store("")
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		reportCategoryError(err)
+		return
+	}
+	current := activeCategories.Load()
+	if !force && current.path == path && info.ModTime().Equal(current.modTime) {
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		reportCategoryError(err)
+		return
+	}
+	defer f.Close()
+	db, err := parseCategoryDB(path, f)
+	if err != nil {
+		reportCategoryError(err)
+		return
+	}
+	db.modTime, db.loadedAt = info.ModTime(), time.Now()
+	activeCategories.Store(db)
+	categoryLoadErr.Store("")
+	fmt.Printf("Category database %s: %d entries\n", path, db.entries)
+}
+
+// reportCategoryError 记录加载失败的原因，同一个错误只输出一次
+func reportCategoryError(err error) {
+	if categoryLoadErr.Swap(err.Error()) != err.Error() {
+		fmt.Println("Error loading category database:", err)
+	}
+}
+
+// watchCategories 在策略重新加载或数据库文件被修改（例如离线更新）时重新加载分类数据库
+func watchCategories() {
+	ticker := time.NewTicker(policyPollInterval)
+	defer ticker.Stop()
+	for {
+		loadCategoryDB(currentPolicy().CategoryDB, false)
+		select {
+		case <-categoryReload:
+		case <-ticker.C:
+		}
+	}
+}
+
+// matchCategoryRules 按主机所属的分类依次检查 categoryRules。
+// categoryAllow 中列出的主机不受对应分类的规则限制
+func matchCategoryRules(p *proxyPolicy, r *http.Request) (blockDecision, bool) {
+	if len(p.CategoryRules) == 0 {
+		return blockDecision{}, false
+	}
+	host := r.URL.Hostname()
+	categories := activeCategories.Load().lookup(host)
+	if len(categories) == 0 {
+		return blockDecision{}, false
+	}
+	for i, rule := range p.CategoryRules {
+		if !scheduleActive(rule.Schedule, scheduleNow()) || !categoryRuleApplies(p, r, rule) {
+			continue
+		}
+		for _, category := range categories {
+			if !containsFold(rule.Categories, category) || hostMatchesAny(host, p.CategoryAllow[category]) {
+				continue
+			}
+			decision := blockDecision{RuleID: rule.ID, Category: category, Reason: rule.Reason, Status: rule.Status}
+			if decision.RuleID == "" {
+				decision.RuleID = fmt.Sprintf("category-rule-%d", i+1)
+			}
+			if decision.Reason == "" {
+				decision.Reason = "Sites in the " + category + " category are blocked."
+			}
+			return decision, true
+		}
+	}
+	return blockDecision{}, false
+}
+
+// categoryRuleApplies 判断规则是否适用于该客户端
+func categoryRuleApplies(p *proxyPolicy, r *http.Request, rule categoryRule) bool {
+	if len(rule.Groups) == 0 && len(rule.Clients) == 0 {
+		return true
+	}
+	return clientInGroups(p, r, rule.Groups) || clientMatches(r, rule.Clients)
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveCategories GET 返回分类数据库的状态，带 host 参数时返回该主机的分类；POST 立即重新加载
+func serveCategories(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		loadCategoryDB(currentPolicy().CategoryDB, true)
+	}
+	db := activeCategories.Load()
+	if host := r.URL.Query().Get("host"); host != "" {
+		writeJSON(w, http.StatusOK, map[string]any{"host": host, "categories": db.lookup(host)})
+		return
+	}
+	result := map[string]any{
+		"path":       db.path,
+		"entries":    db.entries,
+		"categories": db.categoryCounts(),
+		"loadedAt":   db.loadedAt,
+	}
+	if err := categoryLoadErr.Load().(string); err != "" {
+		result["error"] = err
+	}
+	writeJSON(w, http.StatusOK, result)
+}
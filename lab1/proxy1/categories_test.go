@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testCategoryDB = `# 测试用分类数据库
+youtube.com        video
+.bilibili.com      video
+steampowered.com   games,shopping
+play.example.com   games
+=casino.example.org gambling
+example.org        news
+`
+
+// withCategoryDB 把内容写入临时文件并作为当前的分类数据库加载
+func withCategoryDB(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "categories.txt")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := activeCategories.Load()
+	t.Cleanup(func() { activeCategories.Store(old) })
+	loadCategoryDB(path, true)
+	return path
+}
+
+func TestCategoryLookup(t *testing.T) {
+	db, err := parseCategoryDB("test", strings.NewReader(testCategoryDB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"youtube.com", "video"},
+		{"www.YouTube.com:443", "video"},
+		{"m.bilibili.com", "video"},
+		{"store.steampowered.com", "games,shopping"},
+		{"play.example.com", "games"},
+		{"example.com", ""},
+		{"casino.example.org", "gambling"},
+		{"www.casino.example.org", "news"}, // 精确条目不匹配子域名，落到 example.org
+		{"notyoutube.com", ""},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(db.lookup(tt.host), ","); got != tt.want {
+			t.Errorf("lookup(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"example.com", "example.com games extra", "= games"} {
+		if _, err := parseCategoryDB("bad", strings.NewReader(bad)); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestMatchCategoryRules(t *testing.T) {
+	withCategoryDB(t, testCategoryDB)
+	p := defaultPolicy()
+	p.ClientGroups = map[string][]string{
+		"students": {"10.1.0.0/16", "user:alice"},
+	}
+	p.ProxyAuth.Users = map[string]string{"alice": "x", "bob": "x"}
+	p.CategoryRules = []categoryRule{
+		{ID: "no-gambling", Categories: []string{"gambling"}, Status: http.StatusUnavailableForLegalReasons},
+		{ID: "students-no-games", Categories: []string{"games", "video"}, Groups: []string{"students"}},
+		{ID: "guest-no-shopping", Categories: []string{"shopping"}, Clients: []string{"192.0.2.7"}},
+	}
+	p.CategoryAllow = map[string][]string{
+		"video": {"*.bilibili.com"}, // 课程视频
+	}
+
+	tests := []struct {
+		name     string
+		url      string
+		remote   string
+		user     string
+		wantRule string
+		wantCat  string
+	}{
+		{"gambling blocked for everyone", "http://casino.example.org/", "192.0.2.1:1000", "", "no-gambling", "gambling"},
+		{"student blocked from games", "http://store.steampowered.com/", "10.1.2.3:1000", "", "students-no-games", "games"},
+		{"student user blocked from video", "http://www.youtube.com/watch", "192.0.2.1:1000", "alice", "students-no-games", "video"},
+		{"allow-list overrides the category", "http://www.bilibili.com/video/1", "10.1.2.3:1000", "", "", ""},
+		{"teacher not in the group", "http://www.youtube.com/", "10.2.0.1:1000", "bob", "", ""},
+		{"guest blocked by the second category", "http://store.steampowered.com/", "192.0.2.7:1000", "", "guest-no-shopping", "shopping"},
+		{"uncategorised site", "http://www.hit.edu.cn/", "10.1.2.3:1000", "", "", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		r.RemoteAddr = tt.remote
+		if tt.user != "" {
+			r = proxyLogin(p, r, tt.user, "x")
+		}
+		d, blocked := matchCategoryRules(p, r)
+		if blocked != (tt.wantRule != "") || d.RuleID != tt.wantRule || d.Category != tt.wantCat {
+			t.Errorf("%s: got %+v blocked=%v", tt.name, d, blocked)
+		}
+	}
+}
+
+func TestCategoryRuleSchedule(t *testing.T) {
+	withCategoryDB(t, testCategoryDB)
+	p := defaultPolicy()
+	p.CategoryRules = []categoryRule{{
+		Categories: []string{"games"},
+		Schedule:   []scheduleWindow{{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "08:00", End: "17:00", Timezone: "UTC"}},
+	}}
+	r := httptest.NewRequest(http.MethodGet, "http://play.example.com/", nil)
+
+	old := scheduleNow
+	t.Cleanup(func() { scheduleNow = old })
+	scheduleNow = func() time.Time { return time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC) } // 周一上午
+	if d, blocked := matchCategoryRules(p, r); !blocked || d.RuleID != "category-rule-1" || !strings.Contains(d.Reason, "games") {
+		t.Errorf("during school hours: %+v %v", d, blocked)
+	}
+	scheduleNow = func() time.Time { return time.Date(2024, 3, 9, 10, 0, 0, 0, time.UTC) } // 周六
+	if _, blocked := matchCategoryRules(p, r); blocked {
+		t.Error("blocked on the weekend")
+	}
+}
+
+func TestCategoryDBReload(t *testing.T) {
+	path := withCategoryDB(t, "games.example games\n")
+	if got := activeCategories.Load().lookup("games.example"); len(got) != 1 {
+		t.Fatalf("initial lookup: %v", got)
+	}
+
+	// 离线更新：文件修改后重新加载
+	if err := os.WriteFile(path, []byte("video.example video\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	_ = os.Chtimes(path, later, later)
+	loadCategoryDB(path, false)
+	db := activeCategories.Load()
+	if len(db.lookup("games.example")) != 0 || len(db.lookup("video.example")) != 1 {
+		t.Error("updated database was not loaded")
+	}
+
+	// 格式错误的更新不替换原来的数据库
+	silenceStdout(t)
+	if err := os.WriteFile(path, []byte("broken line here\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	loadCategoryDB(path, true)
+	if activeCategories.Load() != db || categoryLoadErr.Load().(string) == "" {
+		t.Error("broken database replaced the working one")
+	}
+}
+
+func TestCategoryFilterThroughProxy(t *testing.T) {
+	withCategoryDB(t, testCategoryDB+"127.0.0.1 games\n")
+	p := defaultPolicy()
+	p.CategoryRules = []categoryRule{{ID: "lab-games", Categories: []string{"games"}}}
+	withPolicy(t, p)
+	origin := startSmallObjectOrigin(t)
+
+	// 网站过滤开关关闭时不检查分类
+	if w := proxyGet(origin.URL + "/"); w.Code != http.StatusOK {
+		t.Errorf("filter disabled: status %d", w.Code)
+	}
+
+	enabled := *p
+	enabled.ForbidSites = true
+	withPolicy(t, &enabled)
+	r := httptest.NewRequest(http.MethodGet, origin.URL+"/", nil)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	handleRequest(w, r)
+	var body map[string]any
+	_ = json.Unmarshal(w.Body.Bytes(), &body)
+	if w.Code != http.StatusForbidden || body["rule"] != "lab-games" || body["category"] != "games" {
+		t.Errorf("filter enabled: status %d body %v", w.Code, body)
+	}
+
+	// 管理接口查询主机的分类
+	r = httptest.NewRequest(http.MethodGet, "/admin/categories?host=www.youtube.com", nil)
+	r.RemoteAddr = "127.0.0.1:5000"
+	w = httptest.NewRecorder()
+	handleRequest(w, r)
+	if !strings.Contains(w.Body.String(), `"video"`) {
+		t.Errorf("/admin/categories: %s", w.Body.String())
+	}
+}
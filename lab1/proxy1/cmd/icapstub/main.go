@@ -0,0 +1,37 @@
+// icapstub 在本地运行 ICAP 测试服务器，配合策略中的 icap 配置离线检查代理的内容适配功能：
+//
+//	go run ./cmd/icapstub -listen 127.0.0.1:1344 -block ads.example.com
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"proxy1/icapstub"
+)
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:1344", "监听地址")
+	signature := flag.String("signature", "", "拦截包含该字符串的内容，默认为 EICAR 测试特征")
+	block := flag.String("block", "", "以逗号分隔的 URL 子串，REQMOD 中匹配时拦截")
+	tag := flag.String("tag", "", "对干净的内容添加的头部名称，为空时返回 204")
+	flag.Parse()
+
+	s := &icapstub.Server{Signature: *signature, TagHeader: *tag}
+	if *block != "" {
+		s.BlockURLs = strings.Split(*block, ",")
+	}
+	if err := s.Listen(*listen); err != nil {
+		fmt.Println("Error starting ICAP stub:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("ICAP stub listening on %s (REQMOD %s, RESPMOD %s)\n", s.Addr(), s.URL("reqmod"), s.URL("respmod"))
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	<-stop
+	_ = s.Close()
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+const (
+	storageEncoding    = "gzip"   // 缓存中可压缩内容统一使用的存储编码
+	maxDecodedBodySize = 64 << 20 // 解压后响应体的最大长度，防止压缩炸弹
+)
+
+// contentCodec 一种 HTTP 内容编码的压缩与解压实现
+type contentCodec struct {
+	newWriter func(io.Writer) (io.WriteCloser, error)
+	newReader func(io.Reader) (io.ReadCloser, error)
+}
+
+// contentCodecs 代理支持的内容编码。brotli 不在标准库中，
+// 引入第三方实现后在 init 中调用 registerCodec("br", ...) 即可参与协商
+var contentCodecs = map[string]contentCodec{
+	"gzip": {
+		newWriter: func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriterLevel(w, gzip.BestCompression) },
+		newReader: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	},
+	"deflate": {
+		newWriter: func(w io.Writer) (io.WriteCloser, error) { return zlib.NewWriterLevel(w, zlib.BestCompression) },
+		newReader: func(r io.Reader) (io.ReadCloser, error) { return zlib.NewReader(r) },
+	},
+}
+
+// codecPreference 客户端 q 值相同时的编码优先顺序
+var codecPreference = []string{"br", "gzip", "deflate"}
+
+// registerCodec 注册额外的内容编码
+func registerCodec(name string, codec contentCodec) {
+	contentCodecs[name] = codec
+}
+
+// normalizeEncoding 统一编码名称，identity 与空值等价
+func normalizeEncoding(encoding string) string {
+	encoding = strings.ToLower(strings.TrimSpace(encoding))
+	switch encoding {
+	case "identity":
+		return ""
+	case "x-gzip":
+		return "gzip"
+	}
+	return encoding
+}
+
+// isCompressible 判断内容类型是否值得压缩存储（图片、视频等已压缩格式除外）
+func isCompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/javascript", "application/x-javascript",
+		"application/xml", "application/xhtml+xml", "application/rss+xml",
+		"application/atom+xml", "application/wasm", "image/svg+xml",
+		"application/x-ns-proxy-autoconfig", "application/manifest+json":
+		return true
+	}
+	return strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+// encodeBody 使用指定编码压缩数据
+func encodeBody(encoding string, body []byte) ([]byte, error) {
+	encoding = normalizeEncoding(encoding)
+	if encoding == "" {
+		return body, nil
+	}
+	codec, ok := contentCodecs[encoding]
+	if !ok {
+		return nil, fmt.Errorf("unsupported content encoding: %s", encoding)
+	}
+	var buf bytes.Buffer
+	zw, err := codec.newWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeBody 按 Content-Encoding 解压数据
+func decodeBody(encoding string, body []byte) ([]byte, error) {
+	encoding = normalizeEncoding(encoding)
+	if encoding == "" {
+		return body, nil
+	}
+	codec, ok := contentCodecs[encoding]
+	if !ok {
+		return nil, fmt.Errorf("unsupported content encoding: %s", encoding)
+	}
+	zr, err := codec.newReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = zr.Close()
+	}()
+	identity, err := io.ReadAll(io.LimitReader(zr, maxDecodedBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(identity) > maxDecodedBodySize {
+		return nil, fmt.Errorf("decoded body exceeds %d bytes", maxDecodedBodySize)
+	}
+	return identity, nil
+}
+
+// canDecode 判断代理能否解压该 Content-Encoding，叠加编码（如 "gzip, deflate"）不支持
+func canDecode(encoding string) bool {
+	encoding = normalizeEncoding(encoding)
+	if encoding == "" {
+		return true
+	}
+	_, ok := contentCodecs[encoding]
+	return ok
+}
+
+// upstreamAcceptEncoding 向源站请求时声明的可接受编码，只包含代理能够解压的编码
+func upstreamAcceptEncoding() string {
+	var names []string
+	for _, name := range codecPreference {
+		if _, ok := contentCodecs[name]; ok {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// negotiateEncoding 根据客户端的 Accept-Encoding 选择响应编码。
+// 优先选择与存储编码相同的编码以免重新压缩，返回空字符串表示不压缩
+func negotiateEncoding(acceptEncoding, stored string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+	accepted := make(map[string]float64)
+	for name, q := range parseQualityValues(acceptEncoding) {
+		accepted[normalizeEncoding(name)] = q
+	}
+
+	qualityOf := func(name string) float64 {
+		if q, ok := accepted[name]; ok {
+			return q
+		}
+		if q, ok := accepted["*"]; ok {
+			return q
+		}
+		return 0
+	}
+
+	best, bestQ := "", 0.0
+	if stored != "" && qualityOf(stored) > 0 {
+		best, bestQ = stored, qualityOf(stored)
+	}
+	for _, name := range codecPreference {
+		if _, ok := contentCodecs[name]; !ok {
+			continue
+		}
+		if q := qualityOf(name); q > bestQ {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// parseQualityValues 解析 Accept 类头部（如 "gzip;q=0.8, br"），返回小写名称到 q 值的映射
+func parseQualityValues(header string) map[string]float64 {
+	values := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if name = strings.ToLower(strings.TrimSpace(name)); name == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		values[name] = q
+	}
+	return values
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		accept string
+		stored string
+		want   string
+	}{
+		{"", "gzip", ""},
+		{"gzip", "gzip", "gzip"},
+		{"gzip", "", "gzip"},
+		{"deflate", "gzip", "deflate"},
+		{"gzip;q=0.5, deflate", "gzip", "deflate"},
+		{"gzip;q=1.0, deflate;q=1.0", "gzip", "gzip"},
+		{"deflate, gzip", "", "gzip"},
+		{"gzip;q=0", "gzip", ""},
+		{"*", "gzip", "gzip"},
+		{"*;q=0.1, gzip;q=0", "gzip", "deflate"},
+		{"identity", "gzip", ""},
+		{"identity;q=0", "gzip", ""},
+		{"br", "gzip", ""},
+		{"x-gzip", "gzip", "gzip"},
+		{"GZIP", "gzip", "gzip"},
+	}
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.accept, tt.stored); got != tt.want {
+			t.Errorf("negotiateEncoding(%q, %q) = %q, want %q", tt.accept, tt.stored, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeBodyLimit(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zeros := make([]byte, 1<<20)
+	for i := 0; i <= maxDecodedBodySize>>20; i++ {
+		if _, err := zw.Write(zeros); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decodeBody("gzip", buf.Bytes()); err == nil {
+		t.Fatal("decodeBody accepted a body larger than maxDecodedBodySize")
+	}
+}
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	body, err := encodeBody("gzip", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func originResponse(encoding, etag string) *http.Response {
+	header := http.Header{}
+	header.Set("Content-Type", "text/html; charset=utf-8")
+	header.Set("ETag", etag)
+	header.Set("Vary", "Accept-Encoding")
+	if encoding != "" {
+		header.Set("Content-Encoding", encoding)
+	}
+	return &http.Response{StatusCode: http.StatusOK, Header: header}
+}
+
+func TestCacheRoundTrip(t *testing.T) {
+	page := []byte(strings.Repeat("<p>hello lab</p>\n", 200))
+	tests := []struct {
+		name         string
+		originEnc    string
+		originBody   []byte
+		accept       string
+		wantEncoding string
+		wantWeakETag bool
+	}{
+		{"gzip origin to identity client", "gzip", gzipBytes(t, page), "", "", true},
+		{"gzip origin to gzip client", "gzip", gzipBytes(t, page), "gzip", "gzip", false},
+		{"identity origin to gzip client", "", page, "gzip, deflate", "gzip", true},
+		{"identity origin to identity client", "", page, "identity", "", false},
+		{"identity origin to deflate client", "", page, "deflate", "deflate", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, err := newCachedResponse(originResponse(tt.originEnc, `"v1"`), tt.originBody)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entry.encoding != storageEncoding {
+				t.Errorf("stored encoding = %q, want %q", entry.encoding, storageEncoding)
+			}
+
+			r := httptest.NewRequest("GET", "http://example.com/", nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept-Encoding", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			writeResponse(w, r, entry)
+
+			if got := w.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Errorf("Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+			got, err := decodeBody(tt.wantEncoding, w.Body.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, page) {
+				t.Error("decoded body does not match origin body")
+			}
+			if vary := w.Header().Values("Vary"); len(vary) != 1 {
+				t.Errorf("Vary = %q, want a single Accept-Encoding", vary)
+			}
+			wantETag := `"v1"`
+			if tt.wantWeakETag {
+				wantETag = `W/"v1"`
+			}
+			if got := w.Header().Get("ETag"); got != wantETag {
+				t.Errorf("ETag = %q, want %q", got, wantETag)
+			}
+		})
+	}
+}
+
+func TestPassthroughUnknownEncoding(t *testing.T) {
+	for _, encoding := range []string{"br", "zstd", "gzip, deflate"} {
+		if canDecode(encoding) {
+			t.Errorf("canDecode(%q) = true", encoding)
+		}
+		raw := []byte{0x1b, 0x00, 0xff}
+		entry := passthroughResponse(originResponse(encoding, `"v1"`), raw)
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		w := httptest.NewRecorder()
+		writeResponse(w, r, entry)
+		if got := w.Header().Get("Content-Encoding"); got != encoding {
+			t.Errorf("Content-Encoding = %q, want %q", got, encoding)
+		}
+		if !bytes.Equal(w.Body.Bytes(), raw) {
+			t.Errorf("%s body was modified", encoding)
+		}
+		if got := w.Header().Get("ETag"); got != `"v1"` {
+			t.Errorf("ETag = %q, want unchanged", got)
+		}
+	}
+}
+
+func TestDiskCacheRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	page := []byte(strings.Repeat("cached ", 500))
+	entry, err := newCachedResponse(originResponse("", `"v1"`), page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const key = "http://example.com/page"
+	if err := saveCacheEntry(dir, key, entry); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadCacheEntry(dir, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := loaded.identityBody()
+	if err != nil || !bytes.Equal(body, page) {
+		t.Fatalf("loaded body mismatch: %v", err)
+	}
+
+	// 损坏的缓存文件应被丢弃
+	path, blob := cachePath(dir, key), blobPath(dir, loaded.digest)
+	data, err := os.ReadFile(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(blob, data[:len(data)-10], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadCacheEntry(dir, key); err == nil {
+		t.Fatal("loadCacheEntry accepted a truncated body")
+	}
+	for _, p := range []string{path, blob} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("corrupt cache file %s was not removed", p)
+		}
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "tmp-*")); len(matches) != 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultListenAddr = ":8080" // 默认监听地址
+
+// duration 可以从命令行（"30s"）和 JSON 字符串解析的时间间隔
+type duration time.Duration
+
+func (d *duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+func (d *duration) String() string { return time.Duration(*d).String() }
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\": %w", err)
+	}
+	return d.Set(s)
+}
+
+// listenList 可重复指定、也可以用逗号分隔的监听地址。命令行中出现时替换配置文件中的值
+type listenList struct {
+	addrs *[]string
+	set   bool
+}
+
+func (l *listenList) Set(s string) error {
+	if !l.set {
+		*l.addrs = nil
+		l.set = true
+	}
+	for _, addr := range strings.Split(s, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			*l.addrs = append(*l.addrs, addr)
+		}
+	}
+	return nil
+}
+
+func (l *listenList) String() string {
+	if l.addrs == nil {
+		return ""
+	}
+	return strings.Join(*l.addrs, ",")
+}
+
+// serverConfig 服务器的运行参数，可以来自 -config 指定的 JSON 文件，命令行参数优先
+type serverConfig struct {
+	Listen          []string `json:"listen"`          // 监听地址，例如 ":8080"、"0.0.0.0:8080"、"[::1]:8080"
+	PolicyFile      string   `json:"policyFile"`      // 策略文件路径
+	ReadTimeout     duration `json:"readTimeout"`     // 读取整个客户端请求（含请求体）的超时
+	WriteTimeout    duration `json:"writeTimeout"`    // 写出响应的超时，0 表示不限制，便于大文件下载
+	IdleTimeout     duration `json:"idleTimeout"`     // 空闲的 keep-alive 连接保留时间
+	MaxHeaderBytes  int      `json:"maxHeaderBytes"`  // 客户端请求头部的最大字节数
+	MaxRequestBody  int64    `json:"maxRequestBody"`  // 客户端请求体的最大字节数，0 表示不限制
+	MaxResponseBody int64    `json:"maxResponseBody"` // 源站响应体的最大字节数，0 表示不限制
+	DialTimeout     duration `json:"dialTimeout"`     // 连接源站的超时
+	TLSTimeout      duration `json:"tlsTimeout"`      // 与源站 TLS 握手的超时
+	ShutdownTimeout duration `json:"shutdownTimeout"` // 收到 SIGTERM 后等待进行中的请求完成的最长时间
+}
+
+// 当前生效的请求体和响应体大小限制，由 applyServerConfig 设置
+var (
+	maxRequestBody  int64
+	maxResponseBody int64
+)
+
+func defaultServerConfig() *serverConfig {
+	return &serverConfig{
+		Listen:          []string{defaultListenAddr},
+		PolicyFile:      policyFile,
+		ReadTimeout:     duration(60 * time.Second),
+		IdleTimeout:     duration(120 * time.Second),
+		MaxHeaderBytes:  http.DefaultMaxHeaderBytes,
+		DialTimeout:     duration(30 * time.Second),
+		TLSTimeout:      duration(10 * time.Second),
+		ShutdownTimeout: duration(30 * time.Second),
+	}
+}
+
+// newServerFlagSet 定义命令行参数，未出现的参数保持 cfg 中的当前值
+func newServerFlagSet(cfg *serverConfig, configPath *string) *flag.FlagSet {
+	fs := flag.NewFlagSet("proxy1", flag.ContinueOnError)
+	fs.StringVar(configPath, "config", *configPath, "JSON 格式的服务器配置文件，命令行参数优先于文件中的值")
+	fs.Var(&listenList{addrs: &cfg.Listen}, "listen", "监听地址，可重复指定或用逗号分隔，例如 0.0.0.0:8080,[::]:8080")
+	fs.StringVar(&cfg.PolicyFile, "policy", cfg.PolicyFile, "策略文件路径")
+	fs.Var(&cfg.ReadTimeout, "read-timeout", "读取客户端请求的超时")
+	fs.Var(&cfg.WriteTimeout, "write-timeout", "写出响应的超时，0 表示不限制")
+	fs.Var(&cfg.IdleTimeout, "idle-timeout", "空闲连接的保留时间")
+	fs.IntVar(&cfg.MaxHeaderBytes, "max-header-bytes", cfg.MaxHeaderBytes, "请求头部的最大字节数")
+	fs.Int64Var(&cfg.MaxRequestBody, "max-request-body", cfg.MaxRequestBody, "请求体的最大字节数，0 表示不限制")
+	fs.Int64Var(&cfg.MaxResponseBody, "max-response-body", cfg.MaxResponseBody, "源站响应体的最大字节数，0 表示不限制")
+	fs.Var(&cfg.DialTimeout, "dial-timeout", "连接源站的超时")
+	fs.Var(&cfg.TLSTimeout, "tls-timeout", "与源站 TLS 握手的超时")
+	fs.Var(&cfg.ShutdownTimeout, "shutdown-timeout", "收到 SIGTERM 后等待请求完成的最长时间")
+	return fs
+}
+
+// parseServerConfig 依次应用默认值、-config 文件和命令行参数
+func parseServerConfig(args []string) (*serverConfig, error) {
+	cfg := defaultServerConfig()
+	var configPath string
+	if err := newServerFlagSet(cfg, &configPath).Parse(args); err != nil {
+		return nil, err
+	}
+	if configPath != "" {
+		cfg = defaultServerConfig()
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("%s: %w", configPath, err)
+		}
+		// 再解析一次命令行，覆盖文件中的值
+		if err := newServerFlagSet(cfg, &configPath).Parse(args); err != nil {
+			return nil, err
+		}
+	}
+	if len(cfg.Listen) == 0 {
+		return nil, fmt.Errorf("no listen address configured")
+	}
+	if cfg.MaxRequestBody < 0 || cfg.MaxResponseBody < 0 || cfg.MaxHeaderBytes < 0 {
+		return nil, fmt.Errorf("size limits must not be negative")
+	}
+	return cfg, nil
+}
+
+// applyServerConfig 把与连接源站和消息大小有关的参数应用到全局设置
+func applyServerConfig(cfg *serverConfig) {
+	listenAddr = cfg.Listen[0]
+	maxRequestBody = cfg.MaxRequestBody
+	maxResponseBody = cfg.MaxResponseBody
+	upstreamDialer.Timeout = time.Duration(cfg.DialTimeout)
+	upstreamTransport.TLSHandshakeTimeout = time.Duration(cfg.TLSTimeout)
+}
+
+// configureServer 设置服务器的超时和头部大小限制
+func (cfg *serverConfig) configureServer(srv *http.Server) {
+	srv.ReadTimeout = time.Duration(cfg.ReadTimeout)
+	srv.WriteTimeout = time.Duration(cfg.WriteTimeout)
+	srv.IdleTimeout = time.Duration(cfg.IdleTimeout)
+	srv.MaxHeaderBytes = cfg.MaxHeaderBytes
+}
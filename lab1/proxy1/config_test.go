@@ -0,0 +1,211 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseServerConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "server.json")
+	if err := os.WriteFile(file, []byte(`{
+		"listen": ["0.0.0.0:3128", "[::]:3128"],
+		"readTimeout": "5s",
+		"maxRequestBody": 1048576,
+		"dialTimeout": "3s"
+	}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		args  []string
+		check func(*serverConfig) bool
+	}{
+		{"defaults", nil, func(c *serverConfig) bool {
+			return len(c.Listen) == 1 && c.Listen[0] == ":8080" && c.PolicyFile == policyFile &&
+				time.Duration(c.ShutdownTimeout) == 30*time.Second && c.WriteTimeout == 0
+		}},
+		{"repeated and comma separated listen", []string{"-listen", "127.0.0.1:8080", "-listen", "[::1]:8080,10.0.0.1:8080"}, func(c *serverConfig) bool {
+			return strings.Join(c.Listen, " ") == "127.0.0.1:8080 [::1]:8080 10.0.0.1:8080"
+		}},
+		{"timeouts and sizes", []string{"-read-timeout", "2s", "-idle-timeout", "1m", "-max-header-bytes", "8192", "-tls-timeout", "4s"}, func(c *serverConfig) bool {
+			return time.Duration(c.ReadTimeout) == 2*time.Second && time.Duration(c.IdleTimeout) == time.Minute &&
+				c.MaxHeaderBytes == 8192 && time.Duration(c.TLSTimeout) == 4*time.Second
+		}},
+		{"config file", []string{"-config", file}, func(c *serverConfig) bool {
+			return strings.Join(c.Listen, " ") == "0.0.0.0:3128 [::]:3128" && time.Duration(c.ReadTimeout) == 5*time.Second &&
+				c.MaxRequestBody == 1<<20 && time.Duration(c.DialTimeout) == 3*time.Second &&
+				time.Duration(c.IdleTimeout) == 120*time.Second
+		}},
+		{"flags override config file", []string{"-listen", ":9090", "-config", file, "-read-timeout", "7s"}, func(c *serverConfig) bool {
+			return strings.Join(c.Listen, " ") == ":9090" && time.Duration(c.ReadTimeout) == 7*time.Second &&
+				c.MaxRequestBody == 1<<20
+		}},
+	}
+	for _, tt := range tests {
+		cfg, err := parseServerConfig(tt.args)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !tt.check(cfg) {
+			t.Errorf("%s: unexpected config %+v", tt.name, cfg)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"-read-timeout", "soon"},
+		{"-max-request-body", "-1"},
+		{"-config", filepath.Join(t.TempDir(), "missing.json")},
+		{"-unknown"},
+	} {
+		if _, err := parseServerConfig(args); err == nil {
+			t.Errorf("%v: expected error", args)
+		}
+	}
+}
+
+func TestBodyLimits(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		_, _ = io.WriteString(w, strings.Repeat("r", 100))
+	}))
+	defer origin.Close()
+
+	oldReq, oldResp := maxRequestBody, maxResponseBody
+	t.Cleanup(func() { maxRequestBody, maxResponseBody = oldReq, oldResp })
+
+	maxRequestBody, maxResponseBody = 10, 0
+	r := httptest.NewRequest("POST", origin.URL+"/upload", strings.NewReader(strings.Repeat("q", 100)))
+	w := httptest.NewRecorder()
+	handleRequest(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("large request: status %d, want 413", w.Code)
+	}
+
+	maxRequestBody, maxResponseBody = 0, 10
+	if w := proxyGet(origin.URL + "/download"); w.Code != http.StatusBadGateway {
+		t.Errorf("large response: status %d, want 502", w.Code)
+	}
+	maxResponseBody = 100
+	if w := proxyGet(origin.URL + "/download-exact"); w.Code != http.StatusOK || w.Body.Len() != 100 {
+		t.Errorf("response at the limit: status %d, %d bytes", w.Code, w.Body.Len())
+	}
+}
+
+// startSlowOrigin 先发送一部分响应，等待 delay 后再发送其余部分
+func startSlowOrigin(t *testing.T, delay time.Duration, started chan<- struct{}) *httptest.Server {
+	t.Helper()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		started <- struct{}{}
+		time.Sleep(delay)
+		_, _ = io.WriteString(w, strings.Repeat("d", 4096))
+	}))
+	t.Cleanup(origin.Close)
+	return origin
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	silenceStdout(t)
+	addrs := []string{"127.0.0.1:0"}
+	if ln, err := net.Listen("tcp", "[::1]:0"); err == nil {
+		_ = ln.Close()
+		addrs = append(addrs, "[::1]:0")
+	}
+	tests := []struct {
+		name       string
+		delay      time.Duration
+		timeout    time.Duration
+		wantFinish bool
+	}{
+		{"download finishes within deadline", 300 * time.Millisecond, 5 * time.Second, true},
+		{"deadline exceeded", 3 * time.Second, 200 * time.Millisecond, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started := make(chan struct{}, 1)
+			origin := startSlowOrigin(t, tt.delay, started)
+
+			cfg := defaultServerConfig()
+			cfg.Listen = addrs
+			cfg.ShutdownTimeout = duration(tt.timeout)
+			listeners, err := listenAll(cfg.Listen)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stop := make(chan os.Signal, 1)
+			done := make(chan error, 1)
+			go func() { done <- serveAll(cfg, listeners, http.HandlerFunc(handleRequest), stop) }()
+
+			// 每个监听地址都可以使用
+			for _, ln := range listeners[1:] {
+				resp, err := http.Get("http://" + ln.Addr().String() + "/stats")
+				if err != nil {
+					t.Fatal(err)
+				}
+				_ = resp.Body.Close()
+			}
+
+			proxyURL, _ := url.Parse("http://" + listeners[0].Addr().String())
+			client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+			type result struct {
+				n   int
+				err error
+			}
+			downloads := make(chan result, 1)
+			go func() {
+				resp, err := client.Get(origin.URL + "/big")
+				if err != nil {
+					downloads <- result{0, err}
+					return
+				}
+				defer resp.Body.Close()
+				body, err := io.ReadAll(resp.Body)
+				downloads <- result{len(body), err}
+			}()
+			<-started
+			begin := time.Now()
+			stop <- syscall.SIGTERM
+
+			// 停止后不再接受新连接
+			deadline := time.Now().Add(2 * time.Second)
+			for {
+				conn, err := net.DialTimeout("tcp", listeners[0].Addr().String(), 100*time.Millisecond)
+				if err != nil {
+					break
+				}
+				_ = conn.Close()
+				if time.Now().After(deadline) {
+					t.Fatal("listener still accepting after SIGTERM")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			got := <-downloads
+			if finished := got.err == nil && got.n == 4096; finished != tt.wantFinish {
+				t.Errorf("download finished = %v (%d bytes, err %v), want %v", finished, got.n, got.err, tt.wantFinish)
+			}
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Errorf("serveAll: %v", err)
+				}
+			case <-time.After(tt.timeout + 2*time.Second):
+				t.Fatal("serveAll did not return after the shutdown deadline")
+			}
+			if !tt.wantFinish && time.Since(begin) > tt.delay {
+				t.Error("shutdown waited for the slow download past its deadline")
+			}
+		})
+	}
+}
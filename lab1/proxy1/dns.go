@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DNS 报文的最小实现（RFC 1035），只支持代理需要的 A/AAAA 查询和 SOA 否定缓存时间（RFC 2308）
+
+const (
+	dnsTypeA     = 1
+	dnsTypeCNAME = 5
+	dnsTypeSOA   = 6
+	dnsTypeAAAA  = 28
+	dnsClassIN   = 1
+
+	dnsRcodeSuccess  = 0
+	dnsRcodeNXDomain = 3
+
+	dnsFlagResponse  = 0x8000
+	dnsFlagTruncated = 0x0200
+	dnsFlagRecursion = 0x0100
+)
+
+var errDNSMalformed = errors.New("malformed DNS message")
+
+// dnsAnswer 一次查询的结果
+type dnsAnswer struct {
+	rcode     int
+	truncated bool
+	ips       [][]byte      // A 记录为 4 字节，AAAA 记录为 16 字节
+	ttl       time.Duration // 应答中所有记录（包括 CNAME）的最小 TTL
+	negTTL    time.Duration // 权威部分 SOA 给出的否定缓存时间，没有 SOA 时为 -1
+}
+
+// buildDNSQuery 构造请求递归解析的查询报文
+func buildDNSQuery(id uint16, name string, qtype uint16) ([]byte, error) {
+	msg := make([]byte, 12, 12+len(name)+6)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], dnsFlagRecursion)
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	msg, err := appendDNSName(msg, name)
+	if err != nil {
+		return nil, err
+	}
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	msg = binary.BigEndian.AppendUint16(msg, dnsClassIN)
+	return msg, nil
+}
+
+// appendDNSName 以标签序列的形式追加域名
+func appendDNSName(msg []byte, name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if len(name) > 253 {
+		return nil, fmt.Errorf("DNS name too long: %q", name)
+	}
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if len(label) == 0 || len(label) > 63 {
+				return nil, fmt.Errorf("invalid DNS name %q", name)
+			}
+			msg = append(msg, byte(len(label)))
+			msg = append(msg, label...)
+		}
+	}
+	return append(msg, 0), nil
+}
+
+// skipDNSName 跳过报文中的域名（可能包含压缩指针），返回其后的偏移
+func skipDNSName(msg []byte, off int) (int, error) {
+	for {
+		if off >= len(msg) {
+			return 0, errDNSMalformed
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			return off + 1, nil
+		case n&0xC0 == 0xC0:
+			if off+2 > len(msg) {
+				return 0, errDNSMalformed
+			}
+			return off + 2, nil
+		case n&0xC0 != 0:
+			return 0, errDNSMalformed
+		}
+		off += 1 + n
+	}
+}
+
+// parseDNSResponse 解析应答报文，检查 ID 与请求一致
+func parseDNSResponse(msg []byte, id uint16) (*dnsAnswer, error) {
+	if len(msg) < 12 {
+		return nil, errDNSMalformed
+	}
+	if binary.BigEndian.Uint16(msg[0:]) != id {
+		return nil, errors.New("DNS response ID mismatch")
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if flags&dnsFlagResponse == 0 {
+		return nil, errDNSMalformed
+	}
+	ans := &dnsAnswer{
+		rcode:     int(flags & 0x000F),
+		truncated: flags&dnsFlagTruncated != 0,
+		ttl:       -1,
+		negTTL:    -1,
+	}
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	ancount := int(binary.BigEndian.Uint16(msg[6:]))
+	nscount := int(binary.BigEndian.Uint16(msg[8:]))
+
+	off := 12
+	var err error
+	for i := 0; i < qdcount; i++ {
+		if off, err = skipDNSName(msg, off); err != nil {
+			return nil, err
+		}
+		off += 4
+	}
+	for i := 0; i < ancount+nscount; i++ {
+		if off, err = skipDNSName(msg, off); err != nil {
+			return nil, err
+		}
+		if off+10 > len(msg) {
+			return nil, errDNSMalformed
+		}
+		rtype := binary.BigEndian.Uint16(msg[off:])
+		ttl := time.Duration(binary.BigEndian.Uint32(msg[off+4:])) * time.Second
+		rdlen := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+rdlen > len(msg) {
+			return nil, errDNSMalformed
+		}
+		rdata := msg[off : off+rdlen]
+
+		if i < ancount {
+			switch rtype {
+			case dnsTypeA, dnsTypeAAAA:
+				if (rtype == dnsTypeA && rdlen != 4) || (rtype == dnsTypeAAAA && rdlen != 16) {
+					return nil, errDNSMalformed
+				}
+				ans.ips = append(ans.ips, append([]byte(nil), rdata...))
+				ans.ttl = minTTL(ans.ttl, ttl)
+			case dnsTypeCNAME:
+				ans.ttl = minTTL(ans.ttl, ttl)
+			}
+		} else if rtype == dnsTypeSOA {
+			// SOA 的 RDATA 为两个域名后接 serial、refresh、retry、expire、minimum
+			p, err := skipDNSName(msg, off)
+			if err == nil {
+				p, err = skipDNSName(msg, p)
+			}
+			if err != nil || p+20 > off+rdlen {
+				return nil, errDNSMalformed
+			}
+			minimum := time.Duration(binary.BigEndian.Uint32(msg[p+16:])) * time.Second
+			ans.negTTL = minTTL(ttl, minimum)
+		}
+		off += rdlen
+	}
+	if ans.ttl < 0 {
+		ans.ttl = 0
+	}
+	return ans, nil
+}
+
+// minTTL 返回较小的 TTL，a 为负数表示尚未设置
+func minTTL(a, b time.Duration) time.Duration {
+	if a < 0 || b < a {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,486 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// e2eOrigin 端到端测试使用的源站，验证器、延迟和失败方式由路径控制：
+//
+//	/lm/<name>        带 Last-Modified，支持 If-Modified-Since
+//	/etag/<name>      只带 ETag，支持 If-None-Match
+//	/no-transform     Cache-Control: no-transform
+//	/slow/<name>      等待 delay 后返回
+//	/status/<code>    返回指定状态码
+//	/redirect/<code>  以指定状态码重定向到 /lm/target
+//	/hangup           声明 Content-Length 后中途断开连接
+type e2eOrigin struct {
+	*httptest.Server
+	delay time.Duration
+
+	mu          sync.Mutex
+	version     int
+	modTime     time.Time
+	hits        map[string]int // 每个路径收到的请求数
+	conditional map[string]int // 其中带条件头部的请求数
+}
+
+func startE2EOrigin(t *testing.T) *e2eOrigin {
+	t.Helper()
+	o := &e2eOrigin{
+		delay:       200 * time.Millisecond,
+		version:     1,
+		modTime:     time.Now().Add(-time.Hour).Truncate(time.Second),
+		hits:        make(map[string]int),
+		conditional: make(map[string]int),
+	}
+	o.Server = httptest.NewServer(http.HandlerFunc(o.serve))
+	t.Cleanup(o.Close)
+	return o
+}
+
+// update 修改源站内容，Last-Modified 和 ETag 随之变化
+func (o *e2eOrigin) update() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.version++
+	o.modTime = o.modTime.Add(time.Minute)
+}
+
+// counts 返回路径收到的请求数和条件请求数
+func (o *e2eOrigin) counts(path string) (int, int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.hits[path], o.conditional[path]
+}
+
+// body 源站内容，足够长以便代理压缩存储
+func (o *e2eOrigin) body(path string, version int) string {
+	return strings.Repeat(fmt.Sprintf("%s v%d\n", path, version), 64)
+}
+
+func (o *e2eOrigin) serve(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	o.hits[r.URL.Path]++
+	if r.Header.Get("If-Modified-Since") != "" || r.Header.Get("If-None-Match") != "" {
+		o.conditional[r.URL.Path]++
+	}
+	version, modTime := o.version, o.modTime
+	o.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	path := r.URL.Path
+	switch {
+	case strings.HasPrefix(path, "/lm/"):
+		w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+		if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modTime.After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	case strings.HasPrefix(path, "/etag/"):
+		etag := fmt.Sprintf(`"v%d"`, version)
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	case path == "/no-transform":
+		w.Header().Set("Cache-Control", "public, max-age=60, no-transform")
+		w.Header().Set("ETag", `"fixed"`)
+	case strings.HasPrefix(path, "/slow/"):
+		time.Sleep(o.delay)
+	case strings.HasPrefix(path, "/status/"):
+		var code int
+		fmt.Sscan(strings.TrimPrefix(path, "/status/"), &code)
+		http.Error(w, http.StatusText(code), code)
+		return
+	case strings.HasPrefix(path, "/redirect/"):
+		var code int
+		fmt.Sscan(strings.TrimPrefix(path, "/redirect/"), &code)
+		http.Redirect(w, r, "/lm/target", code)
+		return
+	case path == "/hangup":
+		w.Header().Set("Content-Length", "100000")
+		_, _ = io.WriteString(w, "partial")
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	_, _ = io.WriteString(w, o.body(path, version))
+}
+
+// e2eResult 经代理得到的响应，响应体已按 Content-Encoding 解压
+type e2eResult struct {
+	status int
+	header http.Header
+	body   string
+}
+
+// e2eClient 经 HTTP 代理访问源站的客户端，不跟随重定向，也不自动解压
+func e2eClient(proxy *httptest.Server) *http.Client {
+	client := proxyClient(proxy, false)
+	client.Transport.(*http.Transport).DisableCompression = true
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	return client
+}
+
+func e2eGet(client *http.Client, target string, header map[string]string) (e2eResult, error) {
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return e2eResult{}, err
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return e2eResult{}, err
+	}
+	defer resp.Body.Close()
+	var body io.Reader = resp.Body
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return e2eResult{}, err
+		}
+		body = zr
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return e2eResult{}, err
+	}
+	return e2eResult{resp.StatusCode, resp.Header, string(data)}, nil
+}
+
+// startE2E 使用独立的策略启动源站和代理
+func startE2E(t *testing.T, p *proxyPolicy) (*e2eOrigin, *http.Client) {
+	t.Helper()
+	silenceStdout(t)
+	withPolicy(t, p)
+	origin := startE2EOrigin(t)
+	proxy := startProxyServer(t)
+	return origin, e2eClient(proxy)
+}
+
+func mustGet(t *testing.T, client *http.Client, target string, header map[string]string) e2eResult {
+	t.Helper()
+	res, err := e2eGet(client, target, header)
+	if err != nil {
+		t.Fatalf("GET %s: %v", target, err)
+	}
+	return res
+}
+
+func TestE2ELastModifiedRevalidation(t *testing.T) {
+	origin, client := startE2E(t, defaultPolicy())
+	target := origin.URL + "/lm/page"
+	revalidated := stats.CacheRevalidated.Load()
+
+	steps := []struct {
+		name            string
+		update          bool
+		expire          bool
+		wantVersion     int
+		wantHits        int
+		wantConditional int
+		wantRevalidated int64
+	}{
+		{"first request fills the cache", false, false, 1, 1, 0, 0},
+		{"unchanged page is revalidated with 304", false, false, 1, 2, 1, 1},
+		{"changed page replaces the cache entry", true, false, 2, 3, 2, 1},
+		{"new entry is revalidated", false, false, 2, 4, 3, 2},
+		{"expired entry is fetched unconditionally", false, true, 2, 5, 3, 2},
+	}
+	for _, step := range steps {
+		if step.update {
+			origin.update()
+		}
+		if step.expire {
+			cacheMu.Lock()
+			cache[target].timestamp = time.Now().Add(-2 * cacheTTL)
+			cacheMu.Unlock()
+		}
+		res := mustGet(t, client, target, map[string]string{"Accept-Encoding": "gzip"})
+		if res.status != http.StatusOK || res.body != origin.body("/lm/page", step.wantVersion) {
+			t.Errorf("%s: status %d body %.20q", step.name, res.status, res.body)
+		}
+		if res.header.Get("Last-Modified") == "" {
+			t.Errorf("%s: Last-Modified missing", step.name)
+		}
+		hits, conditional := origin.counts("/lm/page")
+		if hits != step.wantHits || conditional != step.wantConditional {
+			t.Errorf("%s: origin hits %d conditional %d, want %d and %d", step.name, hits, conditional, step.wantHits, step.wantConditional)
+		}
+		if got := stats.CacheRevalidated.Load() - revalidated; got != step.wantRevalidated {
+			t.Errorf("%s: revalidated %d, want %d", step.name, got, step.wantRevalidated)
+		}
+	}
+}
+
+func TestE2EClientConditionalRequests(t *testing.T) {
+	origin, client := startE2E(t, defaultPolicy())
+
+	// 代理没有缓存时，源站对客户端条件请求的 304 直接转发
+	first := mustGet(t, client, origin.URL+"/etag/fresh", nil)
+	res := mustGet(t, client, origin.URL+"/etag/uncached", map[string]string{"If-None-Match": first.header.Get("ETag")})
+	if res.status != http.StatusNotModified || res.body != "" {
+		t.Errorf("uncached If-None-Match: status %d body %q", res.status, res.body)
+	}
+
+	// 只有 ETag 的页面不能用 If-Modified-Since 重新验证，内容变化后立即可见
+	target := origin.URL + "/etag/page"
+	for version := 1; version <= 3; version++ {
+		res := mustGet(t, client, target, nil)
+		if res.status != http.StatusOK || res.body != origin.body("/etag/page", version) {
+			t.Errorf("version %d: status %d body %.20q", version, res.status, res.body)
+		}
+		if want := fmt.Sprintf(`"v%d"`, version); res.header.Get("ETag") != want {
+			t.Errorf("version %d: ETag %q, want %q", version, res.header.Get("ETag"), want)
+		}
+		origin.update()
+	}
+	if hits, conditional := origin.counts("/etag/page"); hits != 3 || conditional != 0 {
+		t.Errorf("etag page: origin hits %d conditional %d", hits, conditional)
+	}
+}
+
+func TestE2ECacheControlAndEncoding(t *testing.T) {
+	origin, client := startE2E(t, defaultPolicy())
+
+	// 可压缩内容按客户端能力编码，改变表示时 ETag 变为弱 ETag
+	target := origin.URL + "/etag/negotiated"
+	gz := mustGet(t, client, target, map[string]string{"Accept-Encoding": "gzip"})
+	plain := mustGet(t, client, target, map[string]string{"Accept-Encoding": "identity"})
+	if gz.header.Get("Content-Encoding") != "gzip" || plain.header.Get("Content-Encoding") != "" {
+		t.Errorf("encodings: %q and %q", gz.header.Get("Content-Encoding"), plain.header.Get("Content-Encoding"))
+	}
+	if gz.body != plain.body || gz.header.Get("ETag") != `W/"v1"` || plain.header.Get("ETag") != `"v1"` {
+		t.Errorf("ETags %q and %q", gz.header.Get("ETag"), plain.header.Get("ETag"))
+	}
+	if !strings.Contains(gz.header.Get("Vary"), "Accept-Encoding") {
+		t.Errorf("Vary = %q", gz.header.Get("Vary"))
+	}
+
+	// no-transform 的内容按源站的表示原样发送
+	res := mustGet(t, client, origin.URL+"/no-transform", map[string]string{"Accept-Encoding": "gzip"})
+	if res.header.Get("Content-Encoding") != "" || res.header.Get("ETag") != `"fixed"` ||
+		res.header.Get("Cache-Control") != "public, max-age=60, no-transform" {
+		t.Errorf("no-transform: header %v", res.header)
+	}
+	if res.body != origin.body("/no-transform", 1) {
+		t.Errorf("no-transform: body %.20q", res.body)
+	}
+}
+
+func TestE2EFiltering(t *testing.T) {
+	p := defaultPolicy()
+	p.ForbidSites = true
+	p.SiteRules = []siteRule{
+		{ID: "no-admin", Match: "/lm/admin", Category: "internal"},
+		{ID: "legal", Match: "/lm/legal", Status: http.StatusUnavailableForLegalReasons},
+	}
+	origin, client := startE2E(t, p)
+
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantRule   string
+	}{
+		{"/lm/admin", http.StatusForbidden, "no-admin"},
+		{"/lm/legal", http.StatusUnavailableForLegalReasons, "legal"},
+		{"/lm/public", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		res := mustGet(t, client, origin.URL+tt.path, map[string]string{"Accept": "application/json"})
+		if res.status != tt.wantStatus || res.header.Get("X-Proxy-Block-Rule") != tt.wantRule {
+			t.Errorf("%s: status %d rule %q", tt.path, res.status, res.header.Get("X-Proxy-Block-Rule"))
+		}
+		hits, _ := origin.counts(tt.path)
+		if blocked := tt.wantRule != ""; blocked != (hits == 0) {
+			t.Errorf("%s: origin hits %d", tt.path, hits)
+		}
+	}
+
+	// 用户过滤：本机地址被限制后所有请求都被拒绝
+	restricted := *p
+	restricted.ForbidHosts = true
+	restricted.RestrictHosts = []string{"127.0.0.1"}
+	withPolicy(t, &restricted)
+	if res := mustGet(t, client, origin.URL+"/lm/public", nil); res.status != http.StatusForbidden ||
+		res.header.Get("X-Proxy-Block-Rule") != "restrict-hosts" {
+		t.Errorf("restricted client: status %d", res.status)
+	}
+}
+
+func TestE2ERedirects(t *testing.T) {
+	origin, client := startE2E(t, defaultPolicy())
+
+	// 源站的重定向原样交给客户端，代理不跟随
+	for _, code := range []int{http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect} {
+		path := fmt.Sprintf("/redirect/%d", code)
+		res := mustGet(t, client, origin.URL+path, nil)
+		if res.status != code || res.header.Get("Location") != "/lm/target" || res.body != "" {
+			t.Errorf("%d: status %d Location %q body %q", code, res.status, res.header.Get("Location"), res.body)
+		}
+		if hits, _ := origin.counts("/lm/target"); hits != 0 {
+			t.Errorf("%d: proxy followed the redirect", code)
+		}
+		// 重定向不进入缓存，每次都询问源站
+		mustGet(t, client, origin.URL+path, nil)
+		if hits, _ := origin.counts(path); hits != 2 {
+			t.Errorf("%d: origin hits %d", code, hits)
+		}
+	}
+
+	// 钓鱼网站引导由代理直接返回，不访问源站
+	res := mustGet(t, client, "http://"+fishingSrc+"/", nil)
+	if res.status != http.StatusFound || res.header.Get("Location") != fishingDest {
+		t.Errorf("fishing redirect: status %d Location %q", res.status, res.header.Get("Location"))
+	}
+}
+
+func TestE2EOriginFailures(t *testing.T) {
+	origin, client := startE2E(t, defaultPolicy())
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+	}{
+		{"origin error is forwarded", origin.URL + "/status/500", http.StatusInternalServerError},
+		{"not found is forwarded", origin.URL + "/status/404", http.StatusNotFound},
+		{"unavailable origin", origin.URL + "/status/503", http.StatusServiceUnavailable},
+		{"connection refused", closed.URL + "/lm/page", http.StatusBadGateway},
+		{"connection reset mid-body", origin.URL + "/hangup", http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		res := mustGet(t, client, tt.target, nil)
+		if res.status != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d", tt.name, res.status, tt.wantStatus)
+		}
+		if _, ok := lookupCache(tt.target); ok {
+			t.Errorf("%s: failed response was cached", tt.name)
+		}
+	}
+
+	// 失败后源站恢复，正常内容可以获取
+	if res := mustGet(t, client, origin.URL+"/lm/after-failure", nil); res.status != http.StatusOK {
+		t.Errorf("after failures: status %d", res.status)
+	}
+}
+
+func TestE2ESlowOriginsInParallel(t *testing.T) {
+	origin, client := startE2E(t, defaultPolicy())
+
+	const n = 8
+	begin := time.Now()
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			path := fmt.Sprintf("/slow/%d", i)
+			res, err := e2eGet(client, origin.URL+path, nil)
+			if err == nil && res.body != origin.body(path, 1) {
+				err = fmt.Errorf("%s: body %.20q", path, res.body)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	// 一个慢源站不会阻塞其他请求
+	if elapsed := time.Since(begin); elapsed > n*origin.delay/2 {
+		t.Errorf("%d slow requests took %v, want them served in parallel", n, elapsed)
+	}
+}
+
+// TestE2EConcurrentClients 多个客户端同时访问相同和不同的页面，期间源站内容和策略都在变化。
+// 使用 go test -race 运行以检查缓存、统计和策略的并发访问
+func TestE2EConcurrentClients(t *testing.T) {
+	p := defaultPolicy()
+	p.ForbidSites = true
+	p.SiteRules = []siteRule{{ID: "blocked", Match: "/lm/blocked"}}
+	origin, client := startE2E(t, p)
+
+	const clients, rounds = 16, 20
+	paths := []string{"/lm/a", "/lm/b", "/etag/c", "/no-transform", "/lm/blocked", "/status/404"}
+	stop := make(chan struct{})
+	var background sync.WaitGroup
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(5 * time.Millisecond):
+				origin.update()
+				policyMu.Lock()
+				next := *activePolicy
+				activePolicy = &next
+				policyMu.Unlock()
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, clients*rounds)
+	for c := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range rounds {
+				path := paths[(c+i)%len(paths)]
+				encoding := "gzip"
+				if c%2 == 0 {
+					encoding = "identity"
+				}
+				res, err := e2eGet(client, origin.URL+path, map[string]string{"Accept-Encoding": encoding})
+				if err != nil {
+					errs <- err
+					continue
+				}
+				switch {
+				case path == "/lm/blocked":
+					if res.status != http.StatusForbidden {
+						errs <- fmt.Errorf("%s: status %d", path, res.status)
+					}
+				case path == "/status/404":
+					if res.status != http.StatusNotFound {
+						errs <- fmt.Errorf("%s: status %d", path, res.status)
+					}
+				case res.status != http.StatusOK || !strings.HasPrefix(res.body, path+" v"):
+					errs <- fmt.Errorf("%s: status %d body %.20q", path, res.status, res.body)
+				case strings.Count(res.body, "\n") != 64:
+					errs <- fmt.Errorf("%s: truncated body of %d bytes", path, len(res.body))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	background.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if hits, _ := origin.counts("/lm/blocked"); hits != 0 {
+		t.Errorf("blocked page reached the origin %d times", hits)
+	}
+}
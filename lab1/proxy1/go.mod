@@ -1,3 +1,3 @@
 module proxy1
 
-go 1.23
+go 1.24
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -28,28 +29,89 @@ var restrictHosts = []string{
 var isAccessForbiddenHostEnabled = false // 将此设置为 false 以允许用户访问
 var isAccessForbiddenSiteEnabled = false // 将此设置为 false 以允许网站访问
 
-var cache = make(map[string]*cachedResponse)
-
-type cachedResponse struct {
-	response  *http.Response
-	body      []byte
-	timestamp time.Time
-}
+// localMux 处理直接发给代理本身（而非经代理转发）的请求，例如 PAC 文件
+var localMux = http.NewServeMux()
 
 func handleRequest(w http.ResponseWriter, r *http.Request) {
-	// 检查用户过滤（如果开关启用）
-	if isAccessForbiddenHostEnabled && isRestrictedHost(r.RemoteAddr) {
+	// 请求目标不是绝对 URL（HTTP/2 下 :authority 指向代理自身）时，说明是访问代理本身
+	proxied := resolveProxyTarget(r)
+
+	// 缓存失效请求既可以经代理发送，也可以直接发给代理
+	if r.Method == methodPurge || r.Method == methodBan {
+		serveInvalidation(w, r)
+		return
+	}
+
+	if !proxied {
+		localMux.ServeHTTP(w, r)
+		return
+	}
+
+	stats.Requests.Add(1)
+	policy := currentPolicy()
+
+	// 客户端正在录制 HAR 时记录本次请求
+	w, r, finishHAR := beginHARTransaction(w, r)
+	defer finishHAR()
+
+	// 限制客户端请求体的大小，声明的长度超出时直接拒绝
+	if maxRequestBody > 0 {
+		if r.ContentLength > maxRequestBody {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+		}
+	}
+
+	// 校验代理认证，之后的规则只信任通过认证的用户名
+	r, ok := authenticateProxyUser(policy, w, r)
+	if !ok {
+		fmt.Println("Proxy authentication required", r.RemoteAddr)
+		return
+	}
+
+	// 检查用户过滤（如果开关启用且处于生效时间段）
+	if policy.ForbidHosts && isRestrictedHost(policy, r.RemoteAddr) && scheduleActive(policy.RestrictSchedule, scheduleNow()) {
 		fmt.Println("Access forbidden", r.RemoteAddr)
-		http.Error(w, "Access denied", http.StatusForbidden)
+		denyRequest(w, r, blockDecision{
+			RuleID: "restrict-hosts",
+			Reason: "Your address is not allowed to use this proxy.",
+		})
 		return
 	}
 
 	// 检查网站过滤（如果开关启用）
-	if isAccessForbiddenSiteEnabled && isInvalidWebsite(r.URL.String()) {
-		fmt.Println("Access denied", r.URL.String())
-		http.Error(w, "Access to this website is forbidden", http.StatusForbidden)
+	if policy.ForbidSites {
+		if decision, blocked := matchInvalidWebsite(policy, r.URL.String()); blocked {
+			fmt.Println("Access denied", decision.RuleID, r.URL.String())
+			denyRequest(w, r, decision)
+			return
+		}
+		// 按分类数据库检查整类网站（游戏、视频等）
+		if decision, blocked := matchCategoryRules(policy, r); blocked {
+			fmt.Println("Access denied", decision.RuleID, decision.Category, r.URL.Host)
+			denyRequest(w, r, decision)
+			return
+		}
+	}
+
+	// 检查导入的黑名单
+	if decision, blocked := matchBlocklists(r.URL.Hostname()); blocked {
+		fmt.Println("Access denied by", decision.RuleID, r.URL.Host)
+		denyRequest(w, r, decision)
+		return
+	}
+
+	// 检查每日配额，未超出时计入本次请求并统计发送的字节数
+	quotaKeys, decision, overQuota := quotas.admit(policy, r, scheduleNow())
+	if overQuota {
+		fmt.Println("Quota exceeded", decision.RuleID, r.RemoteAddr)
+		denyRequest(w, r, decision)
 		return
 	}
+	w = withQuotaAccounting(w, quotaKeys)
 
 	// fmt.Println(r.URL.String())
 
@@ -66,22 +128,61 @@ func handleRequest(w http.ResponseWriter, r *http.Request) {
 	//	return
 	//}
 
-	// 检查缓存
-	cachedResp, found := cache[r.URL.String()]
+	// 转发给源站的请求副本，客户端原始头部保留用于内容协商
+	outReq := r.Clone(r.Context())
+	removeHopByHopHeaders(outReq.Header)
+
+	// 执行头部修改规则：请求规则在查找缓存之前生效，响应规则在写出响应头部之前生效
+	headerRules := matchHeaderRules(policy, r)
+	applyRequestHeaderRules(outReq, r, headerRules)
+	w = withResponseHeaderRules(w, r, headerRules)
+
+	// ICAP REQMOD：外部服务可以修改请求，或直接给出响应（例如拦截页面）而不访问源站
+	if policy.ICAP.ReqmodURL != "" {
+		adapted, err := icapReqmod(policy.ICAP, outReq)
+		if err != nil {
+			if !policy.ICAP.FailOpen {
+				fmt.Println("ICAP REQMOD failed:", err)
+				http.Error(w, "Content adaptation service unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Println("ICAP REQMOD failed, forwarding unchanged:", err)
+		} else if adapted != nil {
+			fmt.Println("ICAP REQMOD response", adapted.response.StatusCode, r.URL.String())
+			writeAdaptedResponse(w, adapted.response, adapted.body)
+			return
+		}
+	}
+
+	// 检查缓存（缓存以规范形式存储，键与客户端的 Accept-Encoding 无关）
+	cachedResp, found := lookupCache(r.URL.String())
 	if found && time.Since(cachedResp.timestamp) < cacheTTL {
 		// 如果缓存有效，检查并添加 If-Modified-Since 头部
 		lastModified := cachedResp.response.Header.Get("Last-Modified")
 		if lastModified != "" {
-			r.Header.Set("If-Modified-Since", lastModified)
+			outReq.Header.Set("If-Modified-Since", lastModified)
 			fmt.Printf("URL: %s\n", r.URL.String())
 			fmt.Printf("缓存的 Last-Modified: %s\n", lastModified)
-			fmt.Printf("添加的 If-Modified-Since 头部: %s\n", r.Header.Get("If-Modified-Since"))
+			fmt.Printf("添加的 If-Modified-Since 头部: %s\n", outReq.Header.Get("If-Modified-Since"))
 		}
 	}
 
+	// 只向源站声明代理能解压的编码；范围请求保持原文，避免 Content-Range 与压缩数据不一致
+	if r.Header.Get("Range") != "" {
+		outReq.Header.Set("Accept-Encoding", "identity")
+	} else {
+		outReq.Header.Set("Accept-Encoding", upstreamAcceptEncoding())
+	}
+
 	// 转发请求到原服务器
-	resp, err := http.DefaultTransport.RoundTrip(r)
+	markHAROutbound(r, outReq)
+	resp, err := upstreamTransport.RoundTrip(outReq)
 	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
 		http.Error(w, "Error connecting to the upstream server", http.StatusBadGateway)
 		return
 	}
@@ -92,37 +193,72 @@ func handleRequest(w http.ResponseWriter, r *http.Request) {
 		// 如果响应为 304 Not Modified，返回缓存的响应
 		if found {
 			fmt.Println("HTTP:304")
-			writeResponse(w, cachedResp)
+			stats.CacheRevalidated.Add(1)
+			markHARSource(r, "cache")
+			writeResponse(w, r, cachedResp)
 			return
 		}
 	} else if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent {
-		// 读取响应体
-		body, err := io.ReadAll(resp.Body)
+		// 读取响应体，超过 maxResponseBody 时不转发
+		body, err := readResponseBody(resp.Body)
+		if errors.Is(err, errResponseTooLarge) {
+			http.Error(w, "Upstream response too large", http.StatusBadGateway)
+			return
+		}
 		if err != nil {
 			http.Error(w, "Error reading response body", http.StatusInternalServerError)
 			return
 		}
+		markHARReceive(r)
+
+		stats.OriginBytes.Add(int64(len(body)))
+
+		// ICAP RESPMOD：在缓存之前检查完整的响应，缓存中保存的是检查后的内容
+		if policy.ICAP.RespmodURL != "" && resp.StatusCode == http.StatusOK {
+			adapted, adaptedBody, err := icapRespmod(policy.ICAP, outReq, resp, body)
+			switch {
+			case err != nil && !policy.ICAP.FailOpen:
+				fmt.Println("ICAP RESPMOD failed:", err)
+				http.Error(w, "Content adaptation service unavailable", http.StatusServiceUnavailable)
+				return
+			case err != nil:
+				fmt.Println("ICAP RESPMOD failed, forwarding unchanged:", err)
+			case adapted.StatusCode != http.StatusOK:
+				// 服务替换了响应（例如发现病毒后的拦截页面），不缓存
+				fmt.Println("ICAP RESPMOD response", adapted.StatusCode, r.URL.String())
+				writeAdaptedResponse(w, adapted, adaptedBody)
+				return
+			default:
+				resp, body = adapted, adaptedBody
+			}
+		}
 
 		// 打印调试信息
 		fmt.Printf("HTTP:%d\n", resp.StatusCode)
 
-		// 缓存响应，包括响应头和体
-		cache[r.URL.String()] = &cachedResponse{
-			response:  resp,
-			body:      body, // 保存读取的响应体
-			timestamp: time.Now(),
+		// 代理无法解压的编码（如 br、zstd 或叠加编码）原样转发且不缓存
+		if !canDecode(resp.Header.Get("Content-Encoding")) {
+			writeResponse(w, r, passthroughResponse(resp, body))
+			return
+		}
+
+		// 转换为规范形式（可压缩内容以 gzip 存储）
+		entry, err := newCachedResponse(resp, body)
+		if err != nil {
+			http.Error(w, "Error decoding response body", http.StatusBadGateway)
+			return
 		}
 
+		// 缓存响应，包括响应头和体
+		storeCache(r.URL.String(), entry)
+
 		// 将响应写回客户端
-		writeResponse(w, &cachedResponse{
-			response: resp,
-			body:     body,
-		})
+		writeResponse(w, r, entry)
 		return
 	}
 
 	// 如果不是 200, 206 或 304，直接写回原始响应
-	writeResponse(w, &cachedResponse{
+	writeResponse(w, r, &cachedResponse{
 		response: resp,
 		body:     nil, // 不缓存其他状态码的响应体
 	})
@@ -173,17 +309,48 @@ func handleRequest(w http.ResponseWriter, r *http.Request) {
 //	}
 //}
 
-func isInvalidWebsite(requestURL string) bool {
-	for _, invalidWebsite := range invalidWebsites {
+var errResponseTooLarge = errors.New("upstream response body exceeds maxResponseBody")
+
+// readResponseBody 读取源站响应体，配置了 maxResponseBody 时最多读取该长度
+func readResponseBody(body io.Reader) ([]byte, error) {
+	if maxResponseBody <= 0 {
+		return io.ReadAll(body)
+	}
+	data, err := io.ReadAll(io.LimitReader(body, maxResponseBody+1))
+	if err == nil && int64(len(data)) > maxResponseBody {
+		return nil, errResponseTooLarge
+	}
+	return data, err
+}
+
+// matchInvalidWebsite 依次检查带编号的 siteRules 和 invalidWebsites 列表
+func matchInvalidWebsite(policy *proxyPolicy, requestURL string) (blockDecision, bool) {
+	for i, rule := range policy.SiteRules {
+		if rule.Match == "" || !strings.Contains(requestURL, rule.Match) || !scheduleActive(rule.Schedule, scheduleNow()) {
+			continue
+		}
+		decision := blockDecision{RuleID: rule.ID, Category: rule.Category, Reason: rule.Reason, Status: rule.Status}
+		if decision.RuleID == "" {
+			decision.RuleID = fmt.Sprintf("site-rule-%d", i+1)
+		}
+		if decision.Reason == "" {
+			decision.Reason = "Access to this website is forbidden."
+		}
+		return decision, true
+	}
+	for i, invalidWebsite := range policy.InvalidWebsites {
 		if strings.Contains(requestURL, invalidWebsite) {
-			return true
+			return blockDecision{
+				RuleID: fmt.Sprintf("invalid-website-%d", i+1),
+				Reason: "Access to this website is forbidden.",
+			}, true
 		}
 	}
-	return false
+	return blockDecision{}, false
 }
 
-func isRestrictedHost(remoteAddr string) bool {
-	for _, host := range restrictHosts {
+func isRestrictedHost(policy *proxyPolicy, remoteAddr string) bool {
+	for _, host := range policy.RestrictHosts {
 		if strings.HasPrefix(remoteAddr, host) {
 			return true
 		}
@@ -201,30 +368,110 @@ func redirectToFishingSite(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, fishingDest, http.StatusFound)
 }
 
-func writeResponse(w http.ResponseWriter, cachedResp *cachedResponse) {
-	// 复制缓存响应的头部到响应
+func writeResponse(w http.ResponseWriter, r *http.Request, cachedResp *cachedResponse) {
+	// 复制缓存响应的头部到响应，长度和编码在协商后重新设置
 	for key, values := range cachedResp.response.Header {
+		if key == "Content-Length" || key == "Content-Encoding" {
+			continue
+		}
 		for _, value := range values {
 			w.Header().Set(key, value)
 		}
 	}
+	removeHopByHopHeaders(w.Header())
+	// Surrogate-Key 只供代理做标签失效，不发给客户端
+	w.Header().Del("Surrogate-Key")
+
+	// 对于非 200 或 206 状态码，不写入响应体
+	statusCode := cachedResp.response.StatusCode
+	if statusCode != http.StatusOK && statusCode != http.StatusPartialContent {
+		w.WriteHeader(statusCode)
+		fmt.Printf("状态码为 %d，不写入响应体\n", statusCode)
+		return
+	}
+
+	// 根据客户端的 Accept-Encoding 选择编码，必要时解压或重新压缩
+	body, encoding, err := encodeForClient(r, cachedResp)
+	if err != nil {
+		http.Error(w, "Error encoding response body", http.StatusInternalServerError)
+		return
+	}
+	if cachedResp.compressible {
+		addVary(w.Header(), "Accept-Encoding")
+	}
+	// 发送的表示与源站不同时，源站的强 ETag 不再适用，改为弱 ETag
+	if encoding != cachedResp.originEncoding {
+		weakenETag(w.Header())
+	}
+	if encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
+	}
+	w.Header().Set("Content-Length", fmt.Sprint(len(body)))
 
 	// 设置状态码
-	w.WriteHeader(cachedResp.response.StatusCode)
+	w.WriteHeader(statusCode)
 
-	// 如果状态码为 200 或 206，写入缓存的响应体
-	if cachedResp.response.StatusCode == http.StatusOK || cachedResp.response.StatusCode == http.StatusPartialContent {
-		// 调试输出缓存的状态码、头部信息和响应体大小
-		fmt.Printf("写入缓存响应，状态码: %d\n", cachedResp.response.StatusCode)
-		fmt.Printf("缓存响应头部: %v\n", cachedResp.response.Header)
-		fmt.Printf("缓存响应体大小: %d bytes\n", len(cachedResp.body))
+	// 调试输出缓存的状态码、头部信息和响应体大小
+	fmt.Printf("写入缓存响应，状态码: %d\n", statusCode)
+	fmt.Printf("缓存响应头部: %v\n", cachedResp.response.Header)
+	fmt.Printf("缓存响应体大小: %d bytes，发送 %d bytes（编码: %q）\n", cachedResp.size, len(body), encoding)
 
-		_, err := w.Write(cachedResp.body)
-		if err != nil {
-			http.Error(w, "Error writing response body", http.StatusInternalServerError)
+	n, err := w.Write(body)
+	stats.ClientBytes.Add(int64(n))
+	stats.IdentityBytes.Add(int64(cachedResp.size))
+	if err != nil {
+		fmt.Println("Error writing response body:", err)
+	}
+}
+
+// encodeForClient 返回按客户端能力编码后的响应体及其编码
+func encodeForClient(r *http.Request, cachedResp *cachedResponse) ([]byte, string, error) {
+	// 不可协商的响应（包括原样转发的未知编码）按存储形式发送
+	if !cachedResp.compressible {
+		return cachedResp.body, cachedResp.encoding, nil
+	}
+
+	target := negotiateEncoding(r.Header.Get("Accept-Encoding"), cachedResp.encoding)
+	if target == cachedResp.encoding {
+		if target != "" {
+			stats.CompressedServed.Add(1)
 		}
-	} else {
-		// 对于非 200 或 206 状态码，不写入响应体
-		fmt.Printf("状态码为 %d，不写入响应体\n", cachedResp.response.StatusCode)
+		return cachedResp.body, target, nil
+	}
+
+	identity, err := cachedResp.identityBody()
+	if err != nil {
+		return nil, "", err
+	}
+	if target == "" {
+		stats.DecompressedServed.Add(1)
+		return identity, "", nil
+	}
+	body, err := encodeBody(target, identity)
+	if err != nil {
+		return nil, "", err
+	}
+	stats.CompressedServed.Add(1)
+	return body, target, nil
+}
+
+// addVary 向 Vary 头部添加字段名，已存在时不重复添加
+func addVary(header http.Header, field string) {
+	for _, v := range header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" || strings.EqualFold(name, field) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", field)
+}
+
+// weakenETag 将强 ETag 转换为弱 ETag
+func weakenETag(header http.Header) {
+	etag := header.Get("ETag")
+	if etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("ETag", "W/"+etag)
 	}
 }
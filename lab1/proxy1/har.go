@@ -0,0 +1,584 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultHARBodyLimit = 64 << 10 // 记录响应体时每个条目的默认上限
+	defaultHARDir       = "har"    // 策略未配置 harDir 时 HAR 文件的保存目录
+	harMaxEntries       = 1000     // 一次录制最多保留的条目数，超出时丢弃最早的条目
+	harMaxBytes         = 32 << 20 // 一次录制保留的条目总大小（头部和记录的请求体、响应体）上限
+)
+
+// harRecorder 某个客户端的 HAR 录制会话
+type harRecorder struct {
+	client    string
+	bodies    bool // 是否记录请求体和响应体
+	bodyLimit int
+	started   time.Time
+
+	mu      sync.Mutex
+	entries []harEntry
+	size    int // entries 的近似大小，见 harEntrySize
+	dropped int // 因超出上限而丢弃的最早条目数
+}
+
+var (
+	harMu        sync.RWMutex
+	harRecorders = make(map[string]*harRecorder) // 以客户端 IP 为键
+)
+
+func init() {
+	// 开始和结束录制会改变状态，只接受 POST
+	localMux.HandleFunc("POST /admin/har/start", adminOnly(serveHARStart))
+	localMux.HandleFunc("POST /admin/har/stop", adminOnly(serveHARStop))
+	localMux.HandleFunc("/admin/har", adminOnly(serveHARSnapshot))
+}
+
+// HAR 1.2 格式，参见 http://www.softwareishard.com/blog/har-12-spec/
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+	Comment string     `json:"comment,omitempty"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	Source          string      `json:"_source"` // "cache" 表示响应来自代理缓存，"origin" 表示来自源站
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harContent struct {
+	Size        int    `json:"size"`
+	Compression int    `json:"compression,omitempty"`
+	MimeType    string `json:"mimeType"`
+	Text        string `json:"text,omitempty"`
+	Encoding    string `json:"encoding,omitempty"`
+}
+
+// harTimings 各阶段耗时（毫秒），-1 表示不适用
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// harTransaction 记录一次代理请求过程中的时间点
+type harTransaction struct {
+	mu                      sync.Mutex
+	start                   time.Time
+	dnsStart, dnsDone       time.Time
+	connectStart, connected time.Time
+	tlsStart, tlsDone       time.Time
+	gotConn, wroteRequest   time.Time
+	firstByte, receiveDone  time.Time
+	serverIP                string
+	source                  string
+	outReq                  *http.Request // 实际发给源站的请求（头部规则和 ICAP 修改之后）
+}
+
+type harContextKey struct{}
+
+// harRecorderFor 返回客户端当前的录制会话，未在录制时返回 nil
+func harRecorderFor(remoteAddr string) *harRecorder {
+	harMu.RLock()
+	defer harMu.RUnlock()
+	return harRecorders[clientIP(remoteAddr)]
+}
+
+// startHARRecording 为客户端开始录制，已在录制时重新开始
+func startHARRecording(client string, bodies bool, bodyLimit int) *harRecorder {
+	rec := &harRecorder{client: client, bodies: bodies, bodyLimit: bodyLimit, started: time.Now()}
+	harMu.Lock()
+	harRecorders[client] = rec
+	harMu.Unlock()
+	return rec
+}
+
+// stopHARRecording 结束录制并返回会话，客户端未在录制时返回 nil
+func stopHARRecording(client string) *harRecorder {
+	harMu.Lock()
+	defer harMu.Unlock()
+	rec := harRecorders[client]
+	delete(harRecorders, client)
+	return rec
+}
+
+// beginHARTransaction 为正在录制的客户端包装 ResponseWriter 并在请求上下文中挂载时间追踪。
+// 返回的 finish 必须在请求处理结束后调用；客户端未在录制时原样返回
+func beginHARTransaction(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request, func()) {
+	rec := harRecorderFor(r.RemoteAddr)
+	if rec == nil {
+		return w, r, func() {}
+	}
+
+	tx := &harTransaction{start: time.Now(), source: "origin"}
+	ctx := httptrace.WithClientTrace(r.Context(), tx.clientTrace())
+	r = r.WithContext(context.WithValue(ctx, harContextKey{}, tx))
+
+	var reqBody *limitedBuffer
+	if rec.bodies && r.Body != nil && r.Body != http.NoBody {
+		reqBody = &limitedBuffer{limit: rec.bodyLimit}
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(r.Body, reqBody), r.Body}
+	}
+
+	hw := &harResponseWriter{ResponseWriter: w, body: limitedBuffer{limit: rec.bodyLimit}, capture: rec.bodies}
+	return hw, r, func() {
+		rec.add(newHAREntry(r, reqBody, hw, tx))
+	}
+}
+
+// markHARSource 标记响应来源（"cache" 或 "origin"）
+func markHARSource(r *http.Request, source string) {
+	if tx, ok := r.Context().Value(harContextKey{}).(*harTransaction); ok {
+		tx.mu.Lock()
+		tx.source = source
+		tx.mu.Unlock()
+	}
+}
+
+// markHAROutbound 记录实际发给源站的请求，HAR 中的请求部分以它为准
+func markHAROutbound(r *http.Request, outReq *http.Request) {
+	if tx, ok := r.Context().Value(harContextKey{}).(*harTransaction); ok {
+		tx.mu.Lock()
+		tx.outReq = outReq
+		tx.mu.Unlock()
+	}
+}
+
+// markHARReceive 记录响应体读取完成的时间
+func markHARReceive(r *http.Request) {
+	if tx, ok := r.Context().Value(harContextKey{}).(*harTransaction); ok {
+		tx.mu.Lock()
+		tx.receiveDone = time.Now()
+		tx.mu.Unlock()
+	}
+}
+
+func (tx *harTransaction) set(field *time.Time) {
+	tx.mu.Lock()
+	*field = time.Now()
+	tx.mu.Unlock()
+}
+
+func (tx *harTransaction) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { tx.set(&tx.dnsStart) },
+		DNSDone:           func(httptrace.DNSDoneInfo) { tx.set(&tx.dnsDone) },
+		ConnectStart:      func(string, string) { tx.set(&tx.connectStart) },
+		ConnectDone:       func(string, string, error) { tx.set(&tx.connected) },
+		TLSHandshakeStart: func() { tx.set(&tx.tlsStart) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { tx.set(&tx.tlsDone) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			tx.mu.Lock()
+			tx.gotConn = time.Now()
+			if info.Conn != nil {
+				tx.serverIP = clientIP(info.Conn.RemoteAddr().String())
+			}
+			tx.mu.Unlock()
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { tx.set(&tx.wroteRequest) },
+		GotFirstResponseByte: func() { tx.set(&tx.firstByte) },
+	}
+}
+
+// span 返回两个时间点之间的毫秒数，任一时间点缺失时返回 -1
+func span(from, to time.Time) float64 {
+	if from.IsZero() || to.IsZero() || to.Before(from) {
+		return -1
+	}
+	return float64(to.Sub(from).Microseconds()) / 1000
+}
+
+func (tx *harTransaction) timings(end time.Time) harTimings {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	t := harTimings{
+		Blocked: -1,
+		DNS:     span(tx.dnsStart, tx.dnsDone),
+		Connect: span(tx.connectStart, tx.connected),
+		SSL:     span(tx.tlsStart, tx.tlsDone),
+		Send:    span(tx.gotConn, tx.wroteRequest),
+		Wait:    span(tx.wroteRequest, tx.firstByte),
+		Receive: span(tx.firstByte, tx.receiveDone),
+	}
+	if t.Connect >= 0 && t.SSL >= 0 {
+		// HAR 规定 connect 包含 ssl
+		t.Connect += t.SSL
+	}
+	if tx.gotConn.IsZero() {
+		// 没有访问源站（来自缓存或被过滤），整个处理时间计入 wait
+		t.Send, t.Wait, t.Receive = 0, span(tx.start, end), 0
+	} else if tx.receiveDone.IsZero() {
+		t.Receive = span(tx.firstByte, end)
+	}
+	for _, v := range []*float64{&t.Send, &t.Wait, &t.Receive} {
+		if *v < 0 {
+			*v = 0
+		}
+	}
+	return t
+}
+
+// limitedBuffer 最多保存 limit 字节的缓冲区，超出部分只计数
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+	total int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.total += len(p)
+	if room := b.limit - b.Len(); room > 0 {
+		if len(p) > room {
+			b.Buffer.Write(p[:room])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) truncated() bool {
+	return b.total > b.Len()
+}
+
+// harResponseWriter 记录写给客户端的状态码和响应体
+type harResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	body    limitedBuffer
+	capture bool
+}
+
+func (hw *harResponseWriter) WriteHeader(status int) {
+	if hw.status == 0 {
+		hw.status = status
+	}
+	hw.ResponseWriter.WriteHeader(status)
+}
+
+func (hw *harResponseWriter) Write(p []byte) (int, error) {
+	if hw.status == 0 {
+		hw.status = http.StatusOK
+	}
+	n, err := hw.ResponseWriter.Write(p)
+	if hw.capture {
+		_, _ = hw.body.Write(p[:n])
+	} else {
+		hw.body.total += n
+	}
+	return n, err
+}
+
+func (hw *harResponseWriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
+
+// add 追加一个条目，条目数或总大小超出上限时丢弃最早的条目
+func (rec *harRecorder) add(entry harEntry) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.entries = append(rec.entries, entry)
+	rec.size += harEntrySize(entry)
+	n := 0
+	for len(rec.entries)-n > 1 && (len(rec.entries)-n > harMaxEntries || rec.size > harMaxBytes) {
+		rec.size -= harEntrySize(rec.entries[n])
+		n++
+	}
+	if n > 0 {
+		rec.dropped += n
+		rec.entries = append(rec.entries[:0:0], rec.entries[n:]...)
+	}
+}
+
+// harEntrySize 估计条目占用的内存：头部和记录的请求体、响应体
+func harEntrySize(e harEntry) int {
+	size := len(e.Request.URL) + len(e.Response.Content.Text)
+	if e.Request.PostData != nil {
+		size += len(e.Request.PostData.Text)
+	}
+	for _, list := range [][]harNameValue{e.Request.Headers, e.Response.Headers} {
+		for _, h := range list {
+			size += len(h.Name) + len(h.Value)
+		}
+	}
+	return size
+}
+
+// har 返回当前录制内容的 HAR 文件
+func (rec *harRecorder) har() harFile {
+	rec.mu.Lock()
+	entries := append([]harEntry{}, rec.entries...)
+	dropped := rec.dropped
+	rec.mu.Unlock()
+	log := harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "proxy1", Version: "1.0"},
+		Entries: entries,
+	}
+	if dropped > 0 {
+		log.Comment = fmt.Sprintf("%d earliest entries dropped to stay within %d entries and %d bytes", dropped, harMaxEntries, harMaxBytes)
+	}
+	return harFile{Log: log}
+}
+
+func newHAREntry(r *http.Request, reqBody *limitedBuffer, hw *harResponseWriter, tx *harTransaction) harEntry {
+	end := time.Now()
+	status := hw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	header := hw.Header()
+
+	// 请求发往源站时记录修改后的请求，来自缓存或被拦截时记录客户端的请求
+	tx.mu.Lock()
+	req := tx.outReq
+	tx.mu.Unlock()
+	if req == nil {
+		req = r
+	}
+
+	entry := harEntry{
+		StartedDateTime: tx.start.Format(time.RFC3339Nano),
+		Time:            span(tx.start, end),
+		Request: harRequest{
+			Method:      req.Method,
+			URL:         req.URL.String(),
+			HTTPVersion: r.Proto,
+			Cookies:     harCookies(req.Cookies()),
+			Headers:     harHeaders(req.Header),
+			QueryString: harQuery(req),
+			HeadersSize: -1,
+			BodySize:    int(max(req.ContentLength, 0)),
+		},
+		Response: harResponse{
+			Status:      status,
+			StatusText:  http.StatusText(status),
+			HTTPVersion: r.Proto,
+			Cookies:     harCookies((&http.Response{Header: header}).Cookies()),
+			Headers:     harHeaders(header),
+			Content:     harResponseContent(header, &hw.body, hw.capture),
+			RedirectURL: header.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    hw.body.total,
+		},
+		Timings: tx.timings(end),
+	}
+
+	tx.mu.Lock()
+	entry.Source = tx.source
+	entry.ServerIPAddress = tx.serverIP
+	tx.mu.Unlock()
+
+	if reqBody != nil {
+		entry.Request.BodySize = reqBody.total
+		entry.Request.PostData = &harPostData{
+			MimeType: req.Header.Get("Content-Type"),
+			Text:     reqBody.String(),
+		}
+	}
+	return entry
+}
+
+// harResponseContent 记录解压后的响应内容，二进制内容以 base64 保存
+func harResponseContent(header http.Header, body *limitedBuffer, capture bool) harContent {
+	content := harContent{Size: body.total, MimeType: header.Get("Content-Type")}
+	if !capture || body.truncated() {
+		return content
+	}
+	text := body.Bytes()
+	if encoding := header.Get("Content-Encoding"); encoding != "" {
+		decoded, err := decodeBody(encoding, text)
+		if err != nil {
+			return content
+		}
+		content.Size = len(decoded)
+		content.Compression = len(decoded) - len(text)
+		text = decoded
+	}
+	if utf8.Valid(text) {
+		content.Text = string(text)
+	} else {
+		content.Text = base64.StdEncoding.EncodeToString(text)
+		content.Encoding = "base64"
+	}
+	return content
+}
+
+func harHeaders(header http.Header) []harNameValue {
+	list := make([]harNameValue, 0, len(header))
+	for name, values := range header {
+		for _, v := range values {
+			list = append(list, harNameValue{Name: name, Value: v})
+		}
+	}
+	return list
+}
+
+func harCookies(cookies []*http.Cookie) []harNameValue {
+	list := make([]harNameValue, 0, len(cookies))
+	for _, c := range cookies {
+		list = append(list, harNameValue{Name: c.Name, Value: c.Value})
+	}
+	return list
+}
+
+func harQuery(r *http.Request) []harNameValue {
+	list := []harNameValue{}
+	for name, values := range r.URL.Query() {
+		for _, v := range values {
+			list = append(list, harNameValue{Name: name, Value: v})
+		}
+	}
+	return list
+}
+
+// harClientParam 取出管理请求中的 client 参数，缺省为管理请求的来源地址
+func harClientParam(r *http.Request) string {
+	if client := r.URL.Query().Get("client"); client != "" {
+		return client
+	}
+	return clientIP(r.RemoteAddr)
+}
+
+// serveHARStart 开始录制：POST /admin/har/start?client=IP&bodies=1&maxBody=65536
+func serveHARStart(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	bodyLimit := defaultHARBodyLimit
+	if v := q.Get("maxBody"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid maxBody", http.StatusBadRequest)
+			return
+		}
+		bodyLimit = n
+	}
+	bodies, _ := strconv.ParseBool(q.Get("bodies"))
+
+	client := harClientParam(r)
+	startHARRecording(client, bodies, bodyLimit)
+	fmt.Println("HAR recording started for", client)
+	writeJSON(w, http.StatusOK, map[string]any{"client": client, "bodies": bodies, "maxBody": bodyLimit})
+}
+
+// serveHARStop 结束录制，将 HAR 文件写入 harDir 并返回其内容
+func serveHARStop(w http.ResponseWriter, r *http.Request) {
+	client := harClientParam(r)
+	rec := stopHARRecording(client)
+	if rec == nil {
+		http.Error(w, "No HAR recording for "+client, http.StatusNotFound)
+		return
+	}
+
+	har := rec.har()
+	path, err := saveHARFile(rec, har)
+	if err != nil {
+		fmt.Println("Error saving HAR file:", err)
+	} else {
+		fmt.Println("HAR recording saved to", path)
+		w.Header().Set("X-HAR-File", path)
+	}
+	writeJSON(w, http.StatusOK, har)
+}
+
+// serveHARSnapshot 返回正在进行的录制内容而不结束录制
+func serveHARSnapshot(w http.ResponseWriter, r *http.Request) {
+	client := harClientParam(r)
+	rec := harRecorderFor(client)
+	if rec == nil {
+		http.Error(w, "No HAR recording for "+client, http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, rec.har())
+}
+
+func saveHARFile(rec *harRecorder, har harFile) (string, error) {
+	dir := currentPolicy().HARDir
+	if dir == "" {
+		dir = defaultHARDir
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	name := strings.NewReplacer(":", "_", "/", "_").Replace(rec.client)
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s.har", name, rec.started.Format("20060102-150405")))
+	data, err := json.MarshalIndent(har, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return path, os.WriteFile(path, data, 0o644)
+}
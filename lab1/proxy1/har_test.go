@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHARRecording(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+		if r.Header.Get("If-Modified-Since") != "" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_, _ = w.Write([]byte("hello har"))
+	}))
+	defer origin.Close()
+
+	const client = "10.1.2.3"
+	startHARRecording(client, true, 1024)
+	defer stopHARRecording(client)
+
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest("GET", origin.URL+"/har?x=1", nil)
+		r.RemoteAddr = client + ":40000"
+		w := httptest.NewRecorder()
+		handleRequest(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i, w.Code)
+		}
+	}
+
+	// 其他客户端的请求不应被记录
+	r := httptest.NewRequest("GET", origin.URL+"/other", nil)
+	r.RemoteAddr = "10.9.9.9:40000"
+	handleRequest(httptest.NewRecorder(), r)
+
+	rec := stopHARRecording(client)
+	if rec == nil {
+		t.Fatal("recording disappeared")
+	}
+	har := rec.har()
+	if har.Log.Version != "1.2" {
+		t.Errorf("version = %q", har.Log.Version)
+	}
+	if len(har.Log.Entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(har.Log.Entries))
+	}
+
+	first, second := har.Log.Entries[0], har.Log.Entries[1]
+	if first.Source != "origin" || second.Source != "cache" {
+		t.Errorf("sources = %q, %q; want origin, cache", first.Source, second.Source)
+	}
+	if first.Response.Content.Text != "hello har" {
+		t.Errorf("response text = %q", first.Response.Content.Text)
+	}
+	if len(first.Request.QueryString) != 1 || first.Request.QueryString[0].Name != "x" {
+		t.Errorf("query string = %+v", first.Request.QueryString)
+	}
+	for _, e := range har.Log.Entries {
+		tm := e.Timings
+		if tm.Send < 0 || tm.Wait < 0 || tm.Receive < 0 {
+			t.Errorf("negative mandatory timing: %+v", tm)
+		}
+		if tm.Connect == 0 || tm.DNS == 0 {
+			t.Errorf("dns/connect must be -1 or measured: %+v", tm)
+		}
+	}
+
+	data, err := json.Marshal(har)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"_source":"cache"`) {
+		t.Error("serialized HAR lacks _source field")
+	}
+}
+
+func TestHARAdminAccess(t *testing.T) {
+	r := httptest.NewRequest("POST", "/admin/har/start?client=10.0.0.5", nil)
+	r.RemoteAddr = "192.0.2.1:5000"
+	w := httptest.NewRecorder()
+	localMux.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("remote admin request: status %d, want 403", w.Code)
+	}
+
+	// 开始和结束录制会改变状态，不接受 GET
+	for _, path := range []string{"/admin/har/start?client=10.0.0.5", "/admin/har/stop?client=10.0.0.5"} {
+		r = httptest.NewRequest("GET", path, nil)
+		r.RemoteAddr = "127.0.0.1:5000"
+		w = httptest.NewRecorder()
+		localMux.ServeHTTP(w, r)
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("GET %s: status %d, want 405", path, w.Code)
+		}
+	}
+	if harRecorderFor("10.0.0.5:1") != nil {
+		t.Fatal("GET started a recording")
+	}
+
+	r = httptest.NewRequest("POST", "/admin/har/start?client=10.0.0.5&bodies=1", nil)
+	r.RemoteAddr = "127.0.0.1:5000"
+	w = httptest.NewRecorder()
+	localMux.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("local admin request: status %d", w.Code)
+	}
+	if harRecorderFor("10.0.0.5:1") == nil {
+		t.Error("recording not started")
+	}
+	stopHARRecording("10.0.0.5")
+}
+
+// TestHAROutboundRequest HAR 中记录头部规则修改之后发给源站的请求
+func TestHAROutboundRequest(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer origin.Close()
+
+	p := defaultPolicy()
+	p.HeaderRules = []headerRule{{
+		ID: "har",
+		Request: []headerAction{
+			{Action: "remove", Name: "Cookie"},
+			{Action: "set", Name: "X-Proxy-Rule", Value: "$rule"},
+		},
+	}}
+	withPolicy(t, p)
+
+	const client = "10.1.2.4"
+	startHARRecording(client, false, 0)
+	defer stopHARRecording(client)
+	r := httptest.NewRequest("GET", origin.URL+"/outbound", nil)
+	r.RemoteAddr = client + ":40000"
+	r.Header.Set("Cookie", "session=1")
+	handleRequest(httptest.NewRecorder(), r)
+
+	har := stopHARRecording(client).har()
+	if len(har.Log.Entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(har.Log.Entries))
+	}
+	headers := map[string]string{}
+	for _, h := range har.Log.Entries[0].Request.Headers {
+		headers[h.Name] = h.Value
+	}
+	if headers["X-Proxy-Rule"] != "har" || headers["Cookie"] != "" {
+		t.Errorf("recorded request headers %v, want the outbound request", headers)
+	}
+}
+
+// TestHARLimits 条目数或总大小超出上限时丢弃最早的条目
+func TestHARLimits(t *testing.T) {
+	rec := &harRecorder{}
+	for i := 0; i < harMaxEntries+10; i++ {
+		rec.add(harEntry{Request: harRequest{URL: fmt.Sprintf("http://example.com/%d", i)}})
+	}
+	har := rec.har()
+	if len(har.Log.Entries) != harMaxEntries || har.Log.Entries[0].Request.URL != "http://example.com/10" {
+		t.Errorf("%d entries starting at %s", len(har.Log.Entries), har.Log.Entries[0].Request.URL)
+	}
+	if !strings.Contains(har.Log.Comment, "10 earliest entries dropped") {
+		t.Errorf("comment %q", har.Log.Comment)
+	}
+
+	big := strings.Repeat("x", harMaxBytes/3+1)
+	rec = &harRecorder{}
+	for i := 0; i < 5; i++ {
+		rec.add(harEntry{Response: harResponse{Content: harContent{Text: big}}})
+	}
+	if len(rec.entries) != 2 || rec.size > harMaxBytes || rec.dropped != 3 {
+		t.Errorf("%d entries, %d bytes, %d dropped", len(rec.entries), rec.size, rec.dropped)
+	}
+	// 单个超出上限的条目仍然保留
+	rec.add(harEntry{Response: harResponse{Content: harContent{Text: big + big + big + big}}})
+	if len(rec.entries) != 1 {
+		t.Errorf("%d entries after an oversized entry", len(rec.entries))
+	}
+}
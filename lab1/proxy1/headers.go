@@ -0,0 +1,181 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// headerRule 按主机、路径和客户端分组匹配的头部修改规则
+type headerRule struct {
+	ID       string         `json:"id"`
+	Hosts    []string       `json:"hosts"`  // 主机名、*.example.com 或 .example.com，为空时匹配所有主机
+	Paths    []string       `json:"paths"`  // 路径前缀，为空时匹配所有路径
+	Groups   []string       `json:"groups"` // clientGroups 中的分组名，为空时匹配所有客户端
+	Request  []headerAction `json:"request"`
+	Response []headerAction `json:"response"`
+}
+
+// headerAction 一个头部操作。Value 中可以使用 $client_ip、$user、$host、$path、$rule 和 $time
+type headerAction struct {
+	Action string `json:"action"` // add、set 或 remove
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+}
+
+// matchHeaderRules 返回适用于该请求的规则，按策略中的顺序排列
+func matchHeaderRules(p *proxyPolicy, r *http.Request) []headerRule {
+	var matched []headerRule
+	for _, rule := range p.HeaderRules {
+		if len(rule.Hosts) > 0 && !hostMatchesAny(r.URL.Hostname(), rule.Hosts) {
+			continue
+		}
+		if len(rule.Paths) > 0 && !pathMatchesAny(r.URL.Path, rule.Paths) {
+			continue
+		}
+		if len(rule.Groups) > 0 && !clientInGroups(p, r, rule.Groups) {
+			continue
+		}
+		matched = append(matched, rule)
+	}
+	return matched
+}
+
+// hostMatchesAny 支持精确主机名、*.suffix 和 .suffix（后两者同时匹配域名本身）
+func hostMatchesAny(host string, patterns []string) bool {
+	host = normalizeHost(host)
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if suffix, ok := strings.CutPrefix(p, "*"); ok {
+			p = suffix
+		}
+		if strings.HasPrefix(p, ".") {
+			if host == p[1:] || strings.HasSuffix(host, p) {
+				return true
+			}
+		} else if host == p {
+			return true
+		}
+	}
+	return false
+}
+
+func pathMatchesAny(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientInGroups 判断客户端是否属于任一分组。分组成员可以是 IP、网段或 "user:用户名"（只匹配已认证的用户）
+func clientInGroups(p *proxyPolicy, r *http.Request, groups []string) bool {
+	for _, group := range groups {
+		if clientMatches(r, p.ClientGroups[group]) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientMatches 判断客户端是否匹配成员列表中的任一 IP、网段或 "user:用户名"
+func clientMatches(r *http.Request, members []string) bool {
+	user := clientUser(r)
+	var networks []string
+	for _, member := range members {
+		if name, ok := strings.CutPrefix(member, "user:"); ok {
+			if user != "" && name == user {
+				return true
+			}
+			continue
+		}
+		networks = append(networks, member)
+	}
+	return clientInNetworks(r.RemoteAddr, networks)
+}
+
+// expandHeaderValue 替换头部值中的模板变量
+func expandHeaderValue(value string, r *http.Request, rule headerRule) string {
+	return os.Expand(value, func(name string) string {
+		switch name {
+		case "client_ip":
+			return clientIP(r.RemoteAddr)
+		case "user":
+			return clientUser(r)
+		case "host":
+			return r.URL.Hostname()
+		case "path":
+			return r.URL.Path
+		case "rule":
+			return rule.ID
+		case "time":
+			return time.Now().UTC().Format(time.RFC3339)
+		}
+		return "$" + name
+	})
+}
+
+// applyHeaderActions 依次执行头部操作
+func applyHeaderActions(header http.Header, actions []headerAction, r *http.Request, rule headerRule) {
+	for _, a := range actions {
+		if a.Name == "" {
+			continue
+		}
+		switch strings.ToLower(a.Action) {
+		case "add":
+			header.Add(a.Name, expandHeaderValue(a.Value, r, rule))
+		case "set":
+			header.Set(a.Name, expandHeaderValue(a.Value, r, rule))
+		case "remove":
+			header.Del(a.Name)
+		}
+	}
+}
+
+// applyRequestHeaderRules 修改发往源站的请求头部，在查找缓存之前执行
+func applyRequestHeaderRules(outReq *http.Request, r *http.Request, rules []headerRule) {
+	for _, rule := range rules {
+		applyHeaderActions(outReq.Header, rule.Request, r, rule)
+	}
+}
+
+// headerRuleWriter 在写出状态码之前对响应头部执行规则，缓存中的响应不受影响
+type headerRuleWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	rules       []headerRule
+	wroteHeader bool
+}
+
+// withResponseHeaderRules 包装 ResponseWriter，使响应规则在 writeResponse 写出头部前生效
+func withResponseHeaderRules(w http.ResponseWriter, r *http.Request, rules []headerRule) http.ResponseWriter {
+	for _, rule := range rules {
+		if len(rule.Response) > 0 {
+			return &headerRuleWriter{ResponseWriter: w, r: r, rules: rules}
+		}
+	}
+	return w
+}
+
+func (hw *headerRuleWriter) WriteHeader(status int) {
+	if !hw.wroteHeader {
+		hw.wroteHeader = true
+		for _, rule := range hw.rules {
+			applyHeaderActions(hw.Header(), rule.Response, hw.r, rule)
+		}
+	}
+	hw.ResponseWriter.WriteHeader(status)
+}
+
+func (hw *headerRuleWriter) Write(p []byte) (int, error) {
+	if !hw.wroteHeader {
+		hw.WriteHeader(http.StatusOK)
+	}
+	return hw.ResponseWriter.Write(p)
+}
+
+func (hw *headerRuleWriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHostMatchesAny(t *testing.T) {
+	tests := []struct {
+		host     string
+		patterns []string
+		want     bool
+	}{
+		{"example.com", []string{"example.com"}, true},
+		{"www.example.com", []string{"example.com"}, false},
+		{"www.example.com", []string{"*.example.com"}, true},
+		{"example.com", []string{".example.com"}, true},
+		{"badexample.com", []string{".example.com"}, false},
+		{"EXAMPLE.com.", []string{"example.com"}, true},
+	}
+	for _, tt := range tests {
+		if got := hostMatchesAny(tt.host, tt.patterns); got != tt.want {
+			t.Errorf("hostMatchesAny(%q, %q) = %v, want %v", tt.host, tt.patterns, got, tt.want)
+		}
+	}
+}
+
+func TestMatchHeaderRules(t *testing.T) {
+	p := defaultPolicy()
+	p.ClientGroups = map[string][]string{
+		"lab":   {"10.0.0.0/8"},
+		"staff": {"user:alice"},
+	}
+	p.ProxyAuth.Users = map[string]string{"alice": "secret", "bob": "secret"}
+	p.HeaderRules = []headerRule{
+		{ID: "all"},
+		{ID: "api", Hosts: []string{".example.com"}, Paths: []string{"/api/"}},
+		{ID: "lab", Groups: []string{"lab"}},
+		{ID: "staff", Groups: []string{"staff"}},
+	}
+
+	tests := []struct {
+		url        string
+		remoteAddr string
+		user       string
+		password   string
+		want       []string
+	}{
+		{"http://www.example.com/api/v1", "192.0.2.1:1000", "", "", []string{"all", "api"}},
+		{"http://www.example.com/index", "10.1.2.3:1000", "", "", []string{"all", "lab"}},
+		{"http://other.test/api/", "192.0.2.1:1000", "alice", "secret", []string{"all", "staff"}},
+		{"http://other.test/", "192.0.2.1:1000", "bob", "secret", []string{"all"}},
+		// 密码错误时用户名不被信任
+		{"http://other.test/", "192.0.2.1:1000", "alice", "guess", []string{"all"}},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.url, nil)
+		r.RemoteAddr = tt.remoteAddr
+		if tt.user != "" {
+			r = proxyLogin(p, r, tt.user, tt.password)
+		}
+		var got []string
+		for _, rule := range matchHeaderRules(p, r) {
+			got = append(got, rule.ID)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s from %s: rules %v, want %v", tt.url, tt.remoteAddr, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s from %s: rules %v, want %v", tt.url, tt.remoteAddr, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestHeaderRulesEndToEnd(t *testing.T) {
+	var originHeader http.Header
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		originHeader = r.Header.Clone()
+		w.Header().Set("Server", "origin/1.0")
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer origin.Close()
+
+	p := defaultPolicy()
+	p.HeaderRules = []headerRule{{
+		ID: "strip",
+		Request: []headerAction{
+			{Action: "remove", Name: "Cookie"},
+			{Action: "set", Name: "X-Forwarded-For", Value: "$client_ip"},
+			{Action: "add", Name: "X-Proxy-Rule", Value: "${rule}@${host}"},
+		},
+		Response: []headerAction{
+			{Action: "remove", Name: "Server"},
+			{Action: "set", Name: "X-Served-For", Value: "$client_ip"},
+		},
+	}}
+	withPolicy(t, p)
+
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest("GET", origin.URL+"/headers", nil)
+		r.RemoteAddr = "192.0.2.7:5555"
+		r.Header.Set("Cookie", "session=1")
+		w := httptest.NewRecorder()
+		handleRequest(w, r)
+
+		if w.Code != http.StatusOK || w.Body.String() != "ok" {
+			t.Fatalf("request %d: status %d body %q", i, w.Code, w.Body.String())
+		}
+		if originHeader.Get("Cookie") != "" {
+			t.Errorf("request %d: Cookie forwarded to origin", i)
+		}
+		if got := originHeader.Get("X-Forwarded-For"); got != "192.0.2.7" {
+			t.Errorf("request %d: X-Forwarded-For = %q", i, got)
+		}
+		if got := originHeader.Get("X-Proxy-Rule"); got != "strip@127.0.0.1" {
+			t.Errorf("request %d: X-Proxy-Rule = %q", i, got)
+		}
+		if w.Header().Get("Server") != "" {
+			t.Errorf("request %d: Server header not removed", i)
+		}
+		if got := w.Header().Get("X-Served-For"); got != "192.0.2.7" {
+			t.Errorf("request %d: X-Served-For = %q", i, got)
+		}
+	}
+
+	// 响应规则只作用于发给客户端的副本，缓存中仍保留源站头部
+	entry, ok := lookupCache(origin.URL + "/headers")
+	if !ok || entry.response.Header.Get("Server") != "origin/1.0" {
+		t.Error("cached response was modified by header rules")
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ICAP 客户端（RFC 3507），把请求和响应交给外部的杀毒或 DLP 服务检查或修改
+
+const (
+	defaultICAPTimeout = 10 * time.Second
+	defaultICAPPort    = "1344"
+)
+
+// icapConfig 策略中的 ICAP 服务配置
+type icapConfig struct {
+	ReqmodURL  string `json:"reqmodURL"`  // REQMOD 服务，例如 icap://127.0.0.1:1344/reqmod，为空时不检查请求
+	RespmodURL string `json:"respmodURL"` // RESPMOD 服务，为空时不检查响应
+	Preview    int    `json:"preview"`    // 预览字节数，0 表示不使用预览直接发送全部内容
+	FailOpen   bool   `json:"failOpen"`   // ICAP 服务出错时放行（fail-open），默认返回 503（fail-closed）
+	Timeout    string `json:"timeout"`    // 单次 ICAP 交互的超时，默认 10s
+}
+
+// icapStats ICAP 交互的统计
+type icapStats struct {
+	Requests       atomic.Int64
+	NoModification atomic.Int64 // 204 No Modification
+	Modified       atomic.Int64 // 200，服务修改了消息或直接给出响应
+	Errors         atomic.Int64
+}
+
+var icapCounters icapStats
+
+// icapResult ICAP 服务的应答，status 为 200 时 request 或 response 至少有一个非空
+type icapResult struct {
+	status   int
+	request  *http.Request  // REQMOD 修改后的请求
+	response *http.Response // RESPMOD 修改后的响应，或 REQMOD 中服务直接给出的响应
+	body     []byte
+}
+
+func (c icapConfig) timeout() time.Duration {
+	if d, err := time.ParseDuration(c.Timeout); err == nil && d > 0 {
+		return d
+	}
+	return defaultICAPTimeout
+}
+
+// icapReqmod 把发往源站的请求交给 REQMOD 服务。服务修改请求时直接修改 outReq；
+// 服务给出响应（例如拦截页面）时返回该响应，调用方应把它发给客户端而不再访问源站。
+// 请求体过大或读取出错时返回错误，outReq.Body 仍是完整的请求体，fail-open 时可以原样转发
+func icapReqmod(cfg icapConfig, outReq *http.Request) (*icapResult, error) {
+	var body []byte
+	hasBody := outReq.Body != nil && outReq.Body != http.NoBody
+	if hasBody {
+		var err error
+		body, err = io.ReadAll(io.LimitReader(outReq.Body, maxDecodedBodySize+1))
+		if err == nil && len(body) > maxDecodedBodySize {
+			err = errors.New("request body too large for ICAP")
+		}
+		if err != nil {
+			// 已读出的部分放回请求体之前
+			outReq.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), outReq.Body), outReq.Body}
+			return nil, err
+		}
+		outReq.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	result, err := icapExchange(cfg, "REQMOD", cfg.ReqmodURL, icapRequestHeader(outReq), nil, body, hasBody)
+	if err != nil || result.status == http.StatusNoContent {
+		return nil, err
+	}
+	if result.response != nil {
+		return result, nil
+	}
+	if mod := result.request; mod != nil {
+		outReq.Method = mod.Method
+		outReq.URL = mod.URL
+		outReq.Host = mod.Host
+		outReq.Header = mod.Header
+		outReq.Body = io.NopCloser(bytes.NewReader(result.body))
+		outReq.ContentLength = int64(len(result.body))
+		if len(result.body) == 0 {
+			outReq.Body = http.NoBody
+		}
+	}
+	return nil, nil
+}
+
+// icapRespmod 把源站的响应交给 RESPMOD 服务，返回检查后的响应头部和响应体。
+// 代理能解压的内容以原文发送给服务，便于扫描
+func icapRespmod(cfg icapConfig, outReq *http.Request, resp *http.Response, body []byte) (*http.Response, []byte, error) {
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "" && canDecode(encoding) {
+		identity, err := decodeBody(encoding, body)
+		if err == nil {
+			decoded := *resp
+			decoded.Header = resp.Header.Clone()
+			decoded.Header.Del("Content-Encoding")
+			decoded.Header.Set("Content-Length", strconv.Itoa(len(identity)))
+			resp, body = &decoded, identity
+		}
+	}
+
+	result, err := icapExchange(cfg, "RESPMOD", cfg.RespmodURL, icapRequestHeader(outReq), icapResponseHeader(resp), body, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	if result.status == http.StatusNoContent {
+		return resp, body, nil
+	}
+	if result.response == nil {
+		return nil, nil, errors.New("ICAP RESPMOD reply without response headers")
+	}
+	result.response.Body = http.NoBody
+	return result.response, result.body, nil
+}
+
+// icapRequestHeader 把请求行和头部序列化为 ICAP 封装的 req-hdr
+func icapRequestHeader(r *http.Request) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s HTTP/1.1\r\nHost: %s\r\n", r.Method, r.URL.String(), r.URL.Host)
+	_ = r.Header.WriteSubset(&b, map[string]bool{"Host": true})
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+// icapResponseHeader 把状态行和头部序列化为 ICAP 封装的 res-hdr
+func icapResponseHeader(resp *http.Response) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "HTTP/1.1 %d %s\r\n", resp.StatusCode, http.StatusText(resp.StatusCode))
+	_ = resp.Header.Write(&b)
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+// icapExchange 完成一次 ICAP 交互。配置了预览时先发送预览部分，服务返回 100 Continue 后再发送其余内容
+func icapExchange(cfg icapConfig, method, service string, reqHdr, resHdr, body []byte, hasBody bool) (*icapResult, error) {
+	icapCounters.Requests.Add(1)
+	result, err := doICAPExchange(cfg, method, service, reqHdr, resHdr, body, hasBody)
+	switch {
+	case err != nil:
+		icapCounters.Errors.Add(1)
+	case result.status == http.StatusNoContent:
+		icapCounters.NoModification.Add(1)
+	default:
+		icapCounters.Modified.Add(1)
+	}
+	return result, err
+}
+
+func doICAPExchange(cfg icapConfig, method, service string, reqHdr, resHdr, body []byte, hasBody bool) (*icapResult, error) {
+	u, err := url.Parse(service)
+	if err != nil || u.Scheme != "icap" || u.Host == "" {
+		return nil, fmt.Errorf("invalid ICAP service URL %q", service)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), defaultICAPPort)
+	}
+	timeout := cfg.timeout()
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	// Encapsulated 给出各部分在封装内容中的偏移
+	var parts []string
+	offset := 0
+	if reqHdr != nil {
+		parts = append(parts, fmt.Sprintf("req-hdr=%d", offset))
+		offset += len(reqHdr)
+	}
+	if resHdr != nil {
+		parts = append(parts, fmt.Sprintf("res-hdr=%d", offset))
+		offset += len(resHdr)
+	}
+	bodyName := "req-body"
+	if method == "RESPMOD" {
+		bodyName = "res-body"
+	}
+	if !hasBody {
+		bodyName = "null-body"
+	}
+	parts = append(parts, fmt.Sprintf("%s=%d", bodyName, offset))
+
+	preview := -1
+	if hasBody && cfg.Preview > 0 {
+		preview = min(cfg.Preview, len(body))
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s ICAP/1.0\r\nHost: %s\r\nAllow: 204\r\nConnection: close\r\nEncapsulated: %s\r\n",
+		method, service, u.Host, strings.Join(parts, ", "))
+	if preview >= 0 {
+		fmt.Fprintf(&b, "Preview: %d\r\n", preview)
+	}
+	b.WriteString("\r\n")
+	b.Write(reqHdr)
+	b.Write(resHdr)
+	if hasBody {
+		if preview >= 0 {
+			writeICAPChunk(&b, body[:preview])
+			// 全部内容都在预览中时用 ieof 告知服务无需等待
+			if preview == len(body) {
+				b.WriteString("0; ieof\r\n\r\n")
+			} else {
+				b.WriteString("0\r\n\r\n")
+			}
+		} else {
+			writeICAPChunk(&b, body)
+			b.WriteString("0\r\n\r\n")
+		}
+	}
+	if _, err := conn.Write(b.Bytes()); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	result, err := readICAPResponse(br)
+	if err != nil {
+		return nil, err
+	}
+	if result.status == http.StatusContinue {
+		if preview < 0 || preview == len(body) {
+			return nil, errors.New("unexpected ICAP 100 Continue")
+		}
+		b.Reset()
+		writeICAPChunk(&b, body[preview:])
+		b.WriteString("0\r\n\r\n")
+		if _, err := conn.Write(b.Bytes()); err != nil {
+			return nil, err
+		}
+		if result, err = readICAPResponse(br); err != nil {
+			return nil, err
+		}
+	}
+	if result.status != http.StatusOK && result.status != http.StatusNoContent {
+		return nil, fmt.Errorf("ICAP server returned status %d", result.status)
+	}
+	return result, nil
+}
+
+func writeICAPChunk(b *bytes.Buffer, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "%x\r\n", len(data))
+	b.Write(data)
+	b.WriteString("\r\n")
+}
+
+// readICAPResponse 读取 ICAP 状态行、头部以及 200 应答中封装的 HTTP 头部和消息体
+func readICAPResponse(br *bufio.Reader) (*icapResult, error) {
+	tp := textproto.NewReader(br)
+	line, err := tp.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	version, rest, _ := strings.Cut(line, " ")
+	codeText, _, _ := strings.Cut(rest, " ")
+	code, err := strconv.Atoi(codeText)
+	if version != "ICAP/1.0" || err != nil {
+		return nil, fmt.Errorf("malformed ICAP status line %q", line)
+	}
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	result := &icapResult{status: code}
+	if code != http.StatusOK {
+		return result, nil
+	}
+
+	type part struct {
+		name   string
+		offset int
+	}
+	var parts []part
+	for _, item := range strings.Split(header.Get("Encapsulated"), ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		offset, err := strconv.Atoi(value)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("malformed Encapsulated header %q", header.Get("Encapsulated"))
+		}
+		parts = append(parts, part{name, offset})
+	}
+	for i, p := range parts {
+		if strings.HasSuffix(p.name, "-body") {
+			if p.name != "null-body" {
+				body, err := io.ReadAll(io.LimitReader(httputil.NewChunkedReader(br), maxDecodedBodySize+1))
+				if err != nil {
+					return nil, err
+				}
+				if len(body) > maxDecodedBodySize {
+					return nil, errors.New("ICAP body too large")
+				}
+				result.body = body
+			}
+			break
+		}
+		if i+1 >= len(parts) || parts[i+1].offset < p.offset {
+			return nil, errors.New("malformed Encapsulated offsets")
+		}
+		section := make([]byte, parts[i+1].offset-p.offset)
+		if _, err := io.ReadFull(br, section); err != nil {
+			return nil, err
+		}
+		switch p.name {
+		case "req-hdr":
+			if result.request, err = http.ReadRequest(bufio.NewReader(bytes.NewReader(section))); err != nil {
+				return nil, err
+			}
+		case "res-hdr":
+			if result.response, err = http.ReadResponse(bufio.NewReader(bytes.NewReader(section)), nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if result.request == nil && result.response == nil {
+		return nil, errors.New("ICAP 200 reply without encapsulated headers")
+	}
+	return result, nil
+}
+
+// writeAdaptedResponse 写出 ICAP 服务给出的响应（例如拦截页面），不经过缓存和编码协商
+func writeAdaptedResponse(w http.ResponseWriter, resp *http.Response, body []byte) {
+	for key, values := range resp.Header {
+		if key == "Content-Length" {
+			continue
+		}
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	removeHopByHopHeaders(w.Header())
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(resp.StatusCode)
+	n, err := w.Write(body)
+	stats.ClientBytes.Add(int64(n))
+	if err != nil {
+		fmt.Println("Error writing response body:", err)
+	}
+}
+
+// icapStatsSnapshot 返回 ICAP 统计
+func icapStatsSnapshot() map[string]int64 {
+	return map[string]int64{
+		"requests":       icapCounters.Requests.Load(),
+		"noModification": icapCounters.NoModification.Load(),
+		"modified":       icapCounters.Modified.Load(),
+		"errors":         icapCounters.Errors.Load(),
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"proxy1/icapstub"
+)
+
+func startICAPStub(t *testing.T, s *icapstub.Server) *icapstub.Server {
+	t.Helper()
+	if err := s.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+// startContentOrigin 按路径返回不同内容的源站，并统计收到的请求
+func startContentOrigin(t *testing.T, hits *atomic.Int64, seen *atomic.Value) *httptest.Server {
+	t.Helper()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if seen != nil {
+			seen.Store(r.Header.Clone())
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		switch r.URL.Path {
+		case "/virus":
+			_, _ = io.WriteString(w, strings.Repeat("padding ", 300)+icapstub.EICAR)
+		case "/gzip":
+			w.Header().Set("Content-Encoding", "gzip")
+			_, _ = w.Write(gzipBytes(t, []byte("compressed "+icapstub.EICAR)))
+		default:
+			_, _ = io.WriteString(w, strings.Repeat("clean text ", 300))
+		}
+	}))
+	t.Cleanup(origin.Close)
+	return origin
+}
+
+func proxyGet(target string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", target, nil)
+	w := httptest.NewRecorder()
+	handleRequest(w, r)
+	return w
+}
+
+func TestICAPRespmod(t *testing.T) {
+	stub := startICAPStub(t, &icapstub.Server{})
+	var hits atomic.Int64
+	origin := startContentOrigin(t, &hits, nil)
+
+	p := defaultPolicy()
+	p.ICAP = icapConfig{RespmodURL: stub.URL("respmod"), Preview: 1024}
+	withPolicy(t, p)
+
+	// 干净的内容超过预览长度：服务要求继续发送，最终返回 204
+	w := proxyGet(origin.URL + "/clean")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "clean text") {
+		t.Fatalf("clean: status %d", w.Code)
+	}
+	if stub.Previews.Load() != 1 || stub.Continues.Load() != 1 {
+		t.Errorf("previews %d continues %d, want 1 and 1", stub.Previews.Load(), stub.Continues.Load())
+	}
+	if _, ok := lookupCache(origin.URL + "/clean"); !ok {
+		t.Error("scanned response was not cached")
+	}
+
+	// 特征在预览之后：继续发送后被拦截，且不缓存
+	w = proxyGet(origin.URL + "/virus")
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "Blocked by ICAP stub") {
+		t.Errorf("virus: status %d body %q", w.Code, w.Body.String())
+	}
+	if _, ok := lookupCache(origin.URL + "/virus"); ok {
+		t.Error("blocked response was cached")
+	}
+
+	// 压缩的响应解压后再交给服务扫描，特征在预览内，无需继续发送
+	continues := stub.Continues.Load()
+	w = proxyGet(origin.URL + "/gzip")
+	if w.Code != http.StatusForbidden {
+		t.Errorf("gzip virus: status %d", w.Code)
+	}
+	if stub.Continues.Load() != continues {
+		t.Error("service asked to continue although the preview contained the whole body")
+	}
+}
+
+func TestICAPRespmodModifiesHeaders(t *testing.T) {
+	stub := startICAPStub(t, &icapstub.Server{TagHeader: "X-Scanned"})
+	var hits atomic.Int64
+	origin := startContentOrigin(t, &hits, nil)
+
+	p := defaultPolicy()
+	p.ICAP = icapConfig{RespmodURL: stub.URL("respmod")}
+	withPolicy(t, p)
+
+	w := proxyGet(origin.URL + "/tagged")
+	if w.Code != http.StatusOK || w.Header().Get("X-Scanned") != "scanned" {
+		t.Errorf("status %d X-Scanned %q", w.Code, w.Header().Get("X-Scanned"))
+	}
+	if !strings.HasPrefix(w.Body.String(), "clean text") {
+		t.Errorf("body changed: %.40q", w.Body.String())
+	}
+	if stub.Previews.Load() != 0 {
+		t.Error("preview sent although disabled")
+	}
+}
+
+func TestICAPReqmod(t *testing.T) {
+	stub := startICAPStub(t, &icapstub.Server{BlockURLs: []string{"/upload-secret"}, TagHeader: "X-DLP"})
+	var hits atomic.Int64
+	var seen atomic.Value
+	origin := startContentOrigin(t, &hits, &seen)
+
+	p := defaultPolicy()
+	p.ICAP = icapConfig{ReqmodURL: stub.URL("reqmod")}
+	withPolicy(t, p)
+
+	w := proxyGet(origin.URL + "/upload-secret")
+	if w.Code != http.StatusForbidden || hits.Load() != 0 {
+		t.Errorf("blocked request: status %d, origin hits %d", w.Code, hits.Load())
+	}
+
+	// 服务修改请求头部后再转发给源站
+	r := httptest.NewRequest("POST", origin.URL+"/form", strings.NewReader("name=value"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handleRequest(rec, r)
+	if rec.Code != http.StatusOK || hits.Load() != 1 {
+		t.Fatalf("modified request: status %d, origin hits %d", rec.Code, hits.Load())
+	}
+	if h := seen.Load().(http.Header); h.Get("X-Dlp") != "scanned" {
+		t.Errorf("origin headers: %v", h)
+	}
+}
+
+// zeroReader 无限长的零字节流
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// failingReader 先读出 data，之后返回 err
+type failingReader struct {
+	data []byte
+	err  error
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if len(f.data) == 0 {
+		return 0, f.err
+	}
+	n := copy(p, f.data)
+	f.data = f.data[n:]
+	return n, nil
+}
+
+// TestICAPReqmodBodyRestored 请求体无法交给 REQMOD 服务时，已经读出的部分放回请求体：
+// fail-open 转发给源站的是完整的请求体
+func TestICAPReqmodBodyRestored(t *testing.T) {
+	stub := startICAPStub(t, &icapstub.Server{})
+	var received atomic.Int64
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(io.Discard, r.Body)
+		received.Store(n)
+	}))
+	defer origin.Close()
+
+	p := defaultPolicy()
+	p.ICAP = icapConfig{ReqmodURL: stub.URL("reqmod"), FailOpen: true}
+	withPolicy(t, p)
+
+	size := int64(maxDecodedBodySize + 100)
+	r := httptest.NewRequest("POST", origin.URL+"/upload", io.LimitReader(zeroReader{}, size))
+	r.ContentLength = size
+	w := httptest.NewRecorder()
+	handleRequest(w, r)
+	if w.Code != http.StatusOK || received.Load() != size {
+		t.Errorf("oversized body: status %d, origin received %d of %d bytes", w.Code, received.Load(), size)
+	}
+
+	// 读取出错时已读出的数据也不丢失
+	readErr := errors.New("client went away")
+	outReq := httptest.NewRequest("POST", "http://example.com/", nil)
+	outReq.Body = io.NopCloser(&failingReader{data: []byte("partial"), err: readErr})
+	if _, err := icapReqmod(p.ICAP, outReq); !errors.Is(err, readErr) {
+		t.Fatalf("err = %v", err)
+	}
+	if b, err := io.ReadAll(outReq.Body); string(b) != "partial" || !errors.Is(err, readErr) {
+		t.Errorf("restored body %q, err %v", b, err)
+	}
+}
+
+func TestICAPFailurePolicy(t *testing.T) {
+	var hits atomic.Int64
+	origin := startContentOrigin(t, &hits, nil)
+
+	// 找一个没有监听的端口
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadURL := "icap://" + ln.Addr().String() + "/respmod"
+	_ = ln.Close()
+	slow := startICAPStub(t, &icapstub.Server{Delay: 500 * time.Millisecond})
+
+	tests := []struct {
+		name     string
+		cfg      icapConfig
+		wantCode int
+	}{
+		{"fail closed", icapConfig{RespmodURL: deadURL}, http.StatusServiceUnavailable},
+		{"fail open", icapConfig{RespmodURL: deadURL, FailOpen: true}, http.StatusOK},
+		{"timeout closed", icapConfig{RespmodURL: slow.URL("respmod"), Timeout: "100ms"}, http.StatusServiceUnavailable},
+		{"reqmod fail closed", icapConfig{ReqmodURL: deadURL}, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		p := defaultPolicy()
+		p.ICAP = tt.cfg
+		withPolicy(t, p)
+		if w := proxyGet(origin.URL + "/" + strings.ReplaceAll(tt.name, " ", "-")); w.Code != tt.wantCode {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.wantCode)
+		}
+	}
+	if icapCounters.Errors.Load() < 4 {
+		t.Errorf("errors counter = %d", icapCounters.Errors.Load())
+	}
+}
@@ -0,0 +1,301 @@
+// Package icapstub 是一个最小的 ICAP（RFC 3507）服务器，用于在没有真实杀毒或 DLP 服务时
+// 离线测试代理的 REQMOD/RESPMOD 支持。内容包含 Signature 或 URL 包含 BlockURLs 中的字符串时
+// 返回 403 页面，否则返回 204；设置 TagHeader 时对干净的内容返回 200 并添加该头部。
+package icapstub
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EICAR 杀毒软件测试文件的开头部分，默认的病毒特征
+const EICAR = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!`
+
+// PreviewSize OPTIONS 中声明的预览字节数
+const PreviewSize = 1024
+
+// Server ICAP 测试服务器
+type Server struct {
+	Signature string        // 响应体或请求体包含该字符串时拦截，为空时使用 EICAR
+	BlockURLs []string      // REQMOD 中 URL 包含其中任一字符串时拦截
+	TagHeader string        // 非空时对干净的内容返回 200 并添加 "TagHeader: scanned"
+	Delay     time.Duration // 每次应答前的延迟，用于测试超时
+
+	Requests  atomic.Int64 // 收到的 REQMOD/RESPMOD 请求数
+	Previews  atomic.Int64 // 带 Preview 的请求数
+	Continues atomic.Int64 // 预览后要求客户端继续发送的次数
+	Blocked   atomic.Int64 // 拦截的次数
+
+	mu sync.Mutex
+	ln net.Listener
+}
+
+// Listen 在 addr 上监听并在后台处理连接，addr 为 "127.0.0.1:0" 时随机选择端口
+func (s *Server) Listen(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.ln = ln
+	s.mu.Unlock()
+	go func() { _ = s.Serve(ln) }()
+	return nil
+}
+
+// Serve 处理 ln 上的连接，直到 ln 被关闭
+func (s *Server) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+// Addr 返回监听地址
+func (s *Server) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ln.Addr().String()
+}
+
+// URL 返回服务的 ICAP URL，例如 icap://127.0.0.1:1344/respmod
+func (s *Server) URL(service string) string {
+	return "icap://" + s.Addr() + "/" + service
+}
+
+// Close 停止监听
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ln.Close()
+}
+
+// request 一个已解析的 ICAP 请求
+type request struct {
+	method   string
+	header   textproto.MIMEHeader
+	sections map[string][]byte // req-hdr、res-hdr
+	body     []byte
+	hasBody  bool
+	ieof     bool // 预览中已包含全部内容
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	tp := textproto.NewReader(br)
+	for {
+		_ = conn.SetDeadline(time.Now().Add(30 * time.Second))
+		req, err := readRequest(tp, br)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				writeStatus(conn, 400, "Bad Request", nil)
+			}
+			return
+		}
+		if s.Delay > 0 {
+			time.Sleep(s.Delay)
+		}
+		if req.method == "OPTIONS" {
+			writeStatus(conn, 200, "OK", []string{
+				"Methods: REQMOD, RESPMOD",
+				"Preview: " + strconv.Itoa(PreviewSize),
+				"Allow: 204",
+			})
+			continue
+		}
+		s.Requests.Add(1)
+
+		if req.header.Get("Preview") != "" {
+			s.Previews.Add(1)
+			// 预览中已经发现特征时不再需要其余内容
+			if !req.ieof && !s.infected(req) {
+				s.Continues.Add(1)
+				if _, err := io.WriteString(conn, "ICAP/1.0 100 Continue\r\n\r\n"); err != nil {
+					return
+				}
+				rest, _, err := readChunked(br)
+				if err != nil {
+					return
+				}
+				req.body = append(req.body, rest...)
+			}
+		}
+		if err := s.respond(conn, req); err != nil {
+			return
+		}
+	}
+}
+
+// infected 判断请求是否应被拦截
+func (s *Server) infected(req *request) bool {
+	signature := s.Signature
+	if signature == "" {
+		signature = EICAR
+	}
+	if bytes.Contains(req.body, []byte(signature)) {
+		return true
+	}
+	if req.method == "REQMOD" {
+		line, _, _ := strings.Cut(string(req.sections["req-hdr"]), "\r\n")
+		for _, u := range s.BlockURLs {
+			if strings.Contains(line, u) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *Server) respond(w io.Writer, req *request) error {
+	if s.infected(req) {
+		s.Blocked.Add(1)
+		page := []byte("Blocked by ICAP stub: content matched the scanner signature.\n")
+		resHdr := "HTTP/1.1 403 Forbidden\r\nContent-Type: text/plain\r\nContent-Length: " +
+			strconv.Itoa(len(page)) + "\r\n\r\n"
+		return writeEncapsulated(w, "res-hdr", []byte(resHdr), page, true)
+	}
+	if s.TagHeader == "" {
+		writeStatus(w, 204, "No Content", []string{"Encapsulated: null-body=0"})
+		return nil
+	}
+
+	// 添加标记头部后原样返回
+	name := "res-hdr"
+	if req.method == "REQMOD" {
+		name = "req-hdr"
+	}
+	hdr := req.sections[name]
+	hdr = append(bytes.TrimSuffix(hdr, []byte("\r\n")), []byte(s.TagHeader+": scanned\r\n\r\n")...)
+	return writeEncapsulated(w, name, hdr, req.body, req.hasBody)
+}
+
+// writeStatus 写出没有封装内容的 ICAP 应答
+func writeStatus(w io.Writer, code int, text string, headers []string) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "ICAP/1.0 %d %s\r\nISTag: \"icapstub-1\"\r\n", code, text)
+	for _, h := range headers {
+		b.WriteString(h + "\r\n")
+	}
+	b.WriteString("\r\n")
+	_, _ = io.WriteString(w, b.String())
+}
+
+// writeEncapsulated 写出 200 应答，封装一个 HTTP 头部段以及可选的分块消息体
+func writeEncapsulated(w io.Writer, name string, hdr, body []byte, hasBody bool) error {
+	bodyName := strings.Replace(name, "-hdr", "-body", 1)
+	encapsulated := fmt.Sprintf("%s=0, %s=%d", name, bodyName, len(hdr))
+	if !hasBody {
+		encapsulated = fmt.Sprintf("%s=0, null-body=%d", name, len(hdr))
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "ICAP/1.0 200 OK\r\nISTag: \"icapstub-1\"\r\nEncapsulated: %s\r\n\r\n", encapsulated)
+	b.Write(hdr)
+	if hasBody {
+		if len(body) > 0 {
+			fmt.Fprintf(&b, "%x\r\n", len(body))
+			b.Write(body)
+			b.WriteString("\r\n")
+		}
+		b.WriteString("0\r\n\r\n")
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// readRequest 读取 ICAP 请求行、头部、封装的 HTTP 头部和（预览）消息体
+func readRequest(tp *textproto.Reader, br *bufio.Reader) (*request, error) {
+	line, err := tp.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 3 || fields[2] != "ICAP/1.0" {
+		return nil, fmt.Errorf("bad request line %q", line)
+	}
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	req := &request{method: fields[0], header: header, sections: make(map[string][]byte)}
+	if req.method == "OPTIONS" {
+		return req, nil
+	}
+
+	type part struct {
+		name   string
+		offset int
+	}
+	var parts []part
+	for _, item := range strings.Split(header.Get("Encapsulated"), ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		offset, err := strconv.Atoi(value)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("bad Encapsulated header %q", header.Get("Encapsulated"))
+		}
+		parts = append(parts, part{name, offset})
+	}
+	for i, p := range parts {
+		if strings.HasSuffix(p.name, "-body") {
+			req.hasBody = p.name != "null-body"
+			break
+		}
+		if i+1 >= len(parts) || parts[i+1].offset < p.offset {
+			return nil, errors.New("bad Encapsulated offsets")
+		}
+		section := make([]byte, parts[i+1].offset-p.offset)
+		if _, err := io.ReadFull(br, section); err != nil {
+			return nil, err
+		}
+		req.sections[p.name] = section
+	}
+	if req.hasBody {
+		req.body, req.ieof, err = readChunked(br)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// readChunked 读取分块消息体直到长度为 0 的块，返回最后一块是否带有 ieof 扩展
+func readChunked(br *bufio.Reader) ([]byte, bool, error) {
+	var body []byte
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, false, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		sizeText, ext, _ := strings.Cut(line, ";")
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeText), 16, 64)
+		if err != nil || size < 0 {
+			return nil, false, fmt.Errorf("bad chunk size %q", line)
+		}
+		if size == 0 {
+			// 最后一块之后是空行
+			if _, err := br.ReadString('\n'); err != nil {
+				return nil, false, err
+			}
+			return body, strings.TrimSpace(ext) == "ieof", nil
+		}
+		chunk := make([]byte, size+2)
+		if _, err := io.ReadFull(br, chunk); err != nil {
+			return nil, false, err
+		}
+		body = append(body, chunk[:size]...)
+	}
+}
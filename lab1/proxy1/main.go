@@ -1,16 +1,120 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 )
 
+// listenAddr 代理的第一个监听地址，PAC 文件无法从请求中得到代理地址时使用
+var listenAddr = defaultListenAddr
+
 func main() {
+	cfg, err := parseServerConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Println("Error parsing configuration:", err)
+		os.Exit(2)
+	}
+	applyServerConfig(cfg)
+
 	go http.HandleFunc("/", handleRequest) // 使用 http.HandleFunc 注册请求处理
-	fmt.Println("Proxy server is listening on :8080")
-	err := http.ListenAndServe(":8080", nil) // 启动 HTTP 服务器
+	go watchPolicy(cfg.PolicyFile)         // 加载策略文件并监听变更
+	go watchBlocklists()                   // 编译并定期刷新导入的黑名单
+	go watchCategories()                   // 加载分类数据库并在文件更新时重新加载
+	go watchQuotas()                       // 定期保存配额计数
+
+	listeners, err := listenAll(cfg.Listen)
 	if err != nil {
 		fmt.Println("Error starting the proxy server:", err)
-		return
+		os.Exit(1)
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	if err := serveAll(cfg, listeners, nil, stop); err != nil {
+		os.Exit(1)
+	}
+}
+
+// listenAll 绑定所有监听地址，任何一个失败时关闭已绑定的地址并返回错误
+func listenAll(addrs []string) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, addr := range addrs {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, ln)
+	}
+	return listeners, nil
+}
+
+// serveAll 在所有监听地址上提供服务，直到 stop 收到信号或某个服务器出错。
+// 停止时不再接受新连接，并在 shutdownTimeout 内等待进行中的请求（包括下载）完成，超时后强制关闭
+func serveAll(cfg *serverConfig, listeners []net.Listener, handler http.Handler, stop <-chan os.Signal) error {
+	var servers []*http.Server
+	errs := make(chan error, len(listeners))
+	for _, ln := range listeners {
+		srv := newProxyServer(ln.Addr().String(), handler)
+		cfg.configureServer(srv)
+		servers = append(servers, srv)
+		fmt.Println("Proxy server is listening on", ln.Addr())
+		go func() {
+			if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+				errs <- err
+			}
+		}()
+	}
+
+	var serveErr error
+	select {
+	case sig := <-stop:
+		fmt.Printf("Received %v, shutting down (timeout %v)\n", sig, time.Duration(cfg.ShutdownTimeout))
+	case serveErr = <-errs:
+		fmt.Println("Proxy server stopped:", serveErr)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout))
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				fmt.Println("Shutdown deadline exceeded, closing remaining connections:", err)
+				_ = srv.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	quotas.flush()
+	fmt.Println("Proxy server stopped")
+	return serveErr
+}
+
+// newProxyServer 创建同时接受 HTTP/1.1 和 h2c（prior knowledge）的服务器，
+// 每个 HTTP/2 流由独立的 goroutine 调用 handler，与 HTTP/1.1 连接共用缓存和过滤流程
+func newProxyServer(addr string, handler http.Handler) *http.Server {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+	return &http.Server{
+		Addr:      addr,
+		Handler:   handler,
+		Protocols: protocols,
 	}
 }
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pacProxyPlaceholder 生成 PAC 时代理指令的占位符，位于字符串字面量之外，
+// 返回给浏览器时替换为加了引号的 "PROXY host:port"
+const pacProxyPlaceholder = "__PROXY_DIRECTIVE__"
+
+var (
+	pacMu        sync.RWMutex
+	pacBody      string
+	pacUpdatedAt time.Time
+)
+
+func init() {
+	localMux.HandleFunc("/proxy.pac", servePAC)
+	localMux.HandleFunc("/wpad.dat", servePAC)
+	onPolicyReload(rebuildPAC)
+}
+
+// rebuildPAC 根据策略重新生成 PAC 文件
+func rebuildPAC(p *proxyPolicy) {
+	body := generatePAC(p)
+	pacMu.Lock()
+	pacBody = body
+	pacUpdatedAt = p.loadedAt
+	pacMu.Unlock()
+}
+
+// generatePAC 生成 FindProxyForURL 函数：黑名单域名发往黑洞，直连名单返回 DIRECT，其余走代理
+func generatePAC(p *proxyPolicy) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "// Generated by proxy1 at %s\n", p.loadedAt.UTC().Format(time.RFC3339))
+	b.WriteString("function FindProxyForURL(url, host) {\n")
+
+	if len(p.BlockedDomains) > 0 && p.Sinkhole != "" {
+		fmt.Fprintf(&b, "\tif (%s)\n\t\treturn %s;\n", pacConditions(p.BlockedDomains), strconv.Quote("PROXY "+p.Sinkhole))
+	}
+
+	b.WriteString("\tif (isPlainHostName(host)")
+	if len(p.DirectHosts) > 0 {
+		b.WriteString(" ||\n\t\t" + pacConditions(p.DirectHosts))
+	}
+	b.WriteString(")\n\t\treturn \"DIRECT\";\n")
+
+	if len(p.ProxiedHosts) > 0 {
+		fmt.Fprintf(&b, "\tif (%s)\n\t\treturn %s;\n", pacConditions(p.ProxiedHosts), pacProxyPlaceholder)
+		b.WriteString("\treturn \"DIRECT\";\n")
+	} else {
+		fmt.Fprintf(&b, "\treturn %s + \"; DIRECT\";\n", pacProxyPlaceholder)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// pacConditions 将主机列表转换为 PAC 中以 || 连接的条件表达式
+func pacConditions(hosts []string) string {
+	conds := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		if c := pacCondition(strings.TrimSpace(h)); c != "" {
+			conds = append(conds, c)
+		}
+	}
+	if len(conds) == 0 {
+		return "false"
+	}
+	return strings.Join(conds, " ||\n\t\t")
+}
+
+// pacCondition 支持网段（10.0.0.0/8）、通配符（*.example.com）、后缀（.example.com）和域名
+func pacCondition(host string) string {
+	if host == "" {
+		return ""
+	}
+	if _, ipNet, err := net.ParseCIDR(host); err == nil {
+		mask := net.IP(ipNet.Mask)
+		if len(ipNet.Mask) == net.IPv6len {
+			// PAC 的 isInNet 只支持 IPv4
+			fmt.Println("PAC: skipping IPv6 network", host)
+			return ""
+		}
+		return fmt.Sprintf("isInNet(host, %s, %s)", strconv.Quote(ipNet.IP.String()), strconv.Quote(mask.String()))
+	}
+	if strings.HasPrefix(host, "*.") {
+		host = host[1:]
+	}
+	if strings.HasPrefix(host, ".") {
+		return fmt.Sprintf("dnsDomainIs(host, %s)", strconv.Quote(host))
+	}
+	if strings.Contains(host, "*") {
+		return fmt.Sprintf("shExpMatch(host, %s)", strconv.Quote(host))
+	}
+	return fmt.Sprintf("(host == %s || dnsDomainIs(host, %s))", strconv.Quote(host), strconv.Quote("."+host))
+}
+
+// servePAC 返回 PAC/WPAD 文件，代理地址取自客户端访问本服务时使用的 Host
+func servePAC(w http.ResponseWriter, r *http.Request) {
+	pacMu.RLock()
+	body, updatedAt := pacBody, pacUpdatedAt
+	pacMu.RUnlock()
+
+	directive := strconv.Quote("PROXY " + pacProxyAddr(r))
+	body = strings.ReplaceAll(body, pacProxyPlaceholder, directive)
+
+	w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, "", updatedAt, strings.NewReader(body))
+}
+
+// pacProxyAddr 返回写入 PAC 的代理地址。Host 由客户端控制，必须是合法的 host:port，
+// 否则（例如 WPAD 请求的 "Host: wpad"）使用本次连接的本地地址
+func pacProxyAddr(r *http.Request) string {
+	if host, port, err := net.SplitHostPort(r.Host); err == nil && isValidPACHost(host) && isValidPort(port) {
+		return net.JoinHostPort(host, port)
+	}
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		return addr.String()
+	}
+	return listenAddr
+}
+
+// isValidPACHost 判断是否为 IP 地址或只含字母、数字、点和连字符的主机名
+func isValidPACHost(host string) bool {
+	if host == "" {
+		return false
+	}
+	if net.ParseIP(host) != nil {
+		return true
+	}
+	for _, c := range host {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func isValidPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n <= 65535
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPACCondition(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"10.0.0.0/8", `isInNet(host, "10.0.0.0", "255.0.0.0")`},
+		{"192.168.1.0/24", `isInNet(host, "192.168.1.0", "255.255.255.0")`},
+		{"fd00::/8", ""},
+		{"*.hit.edu.cn", `dnsDomainIs(host, ".hit.edu.cn")`},
+		{".example.com", `dnsDomainIs(host, ".example.com")`},
+		{"www.*.example.com", `shExpMatch(host, "www.*.example.com")`},
+		{"localhost", `(host == "localhost" || dnsDomainIs(host, ".localhost"))`},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := pacCondition(tt.host); got != tt.want {
+			t.Errorf("pacCondition(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestGeneratePAC(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  *proxyPolicy
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "blocked domains go to sinkhole",
+			policy: &proxyPolicy{
+				BlockedDomains: []string{"ads.example.com"},
+				Sinkhole:       "127.0.0.1:9",
+			},
+			want: []string{
+				`(host == "ads.example.com" || dnsDomainIs(host, ".ads.example.com"))`,
+				`return "PROXY 127.0.0.1:9";`,
+				`return ` + pacProxyPlaceholder + ` + "; DIRECT";`,
+			},
+		},
+		{
+			name: "no sinkhole means no block rule",
+			policy: &proxyPolicy{
+				BlockedDomains: []string{"ads.example.com"},
+			},
+			notWant: []string{"ads.example.com"},
+		},
+		{
+			name: "direct hosts",
+			policy: &proxyPolicy{
+				DirectHosts: []string{"10.0.0.0/8", "*.hit.edu.cn"},
+			},
+			want: []string{
+				`isInNet(host, "10.0.0.0", "255.0.0.0")`,
+				`dnsDomainIs(host, ".hit.edu.cn")`,
+				"\t\treturn \"DIRECT\";",
+			},
+		},
+		{
+			name: "proxied hosts fall back to direct",
+			policy: &proxyPolicy{
+				ProxiedHosts: []string{".example.com"},
+			},
+			want: []string{
+				`if (dnsDomainIs(host, ".example.com"))` + "\n\t\treturn " + pacProxyPlaceholder + ";",
+				"\treturn \"DIRECT\";\n}",
+			},
+			notWant: []string{`"; DIRECT"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.policy.loadedAt = time.Unix(0, 0)
+			pac := generatePAC(tt.policy)
+			for _, s := range tt.want {
+				if !strings.Contains(pac, s) {
+					t.Errorf("PAC missing %q:\n%s", s, pac)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(pac, s) {
+					t.Errorf("PAC unexpectedly contains %q:\n%s", s, pac)
+				}
+			}
+		})
+	}
+}
+
+func TestPACProxyAddr(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"proxy.lab:8080", "proxy.lab:8080"},
+		{"10.0.0.1:3128", "10.0.0.1:3128"},
+		{"[::1]:8080", "[::1]:8080"},
+		{"wpad", listenAddr},
+		{`evil";alert(1);":80`, listenAddr},
+		{"proxy.lab:0", listenAddr},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/wpad.dat", nil)
+		r.Host = tt.host
+		if got := pacProxyAddr(r); got != tt.want {
+			t.Errorf("pacProxyAddr(Host=%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestServePACQuotesHost(t *testing.T) {
+	rebuildPAC(&proxyPolicy{loadedAt: time.Unix(0, 0)})
+	r := httptest.NewRequest("GET", "/proxy.pac", nil)
+	r.Host = "proxy.lab:8080"
+	w := httptest.NewRecorder()
+	servePAC(w, r)
+	if body := w.Body.String(); !strings.Contains(body, `return "PROXY proxy.lab:8080" + "; DIRECT";`) {
+		t.Errorf("unexpected PAC body:\n%s", body)
+	}
+}
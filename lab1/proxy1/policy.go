@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	policyFile         = "policy.json"   // 策略文件路径
+	policyPollInterval = 5 * time.Second // 策略文件变更检查间隔
+)
+
+// proxyPolicy 代理的访问策略，可以从 policy.json 加载并在运行时重新加载
+type proxyPolicy struct {
+	InvalidWebsites  []string          `json:"invalidWebsites"`  // 禁止访问的网站（URL 子串匹配）
+	RestrictHosts    []string          `json:"restrictHosts"`    // 限制访问的用户（IP 前缀匹配）
+	ForbidHosts      bool              `json:"forbidHosts"`      // 用户过滤开关
+	ForbidSites      bool              `json:"forbidSites"`      // 网站过滤开关
+	SiteRules        []siteRule        `json:"siteRules"`        // 带编号、分类和状态码的网站过滤规则
+	BlockPage        blockPageConfig   `json:"blockPage"`        // 拦截页面的模板与联系方式
+	Blocklists       []blocklistConfig `json:"blocklists"`       // 导入的 hosts/Adblock 格式黑名单
+	BlocklistRefresh string            `json:"blocklistRefresh"` // 重新读取黑名单的间隔，例如 "30m"
+	AdminClients     []string          `json:"adminClients"`     // 允许访问管理接口的网段或 IP，为空时仅允许本机
+	HARDir           string            `json:"harDir"`           // HAR 录制文件的保存目录
+	CacheDir         string            `json:"cacheDir"`         // 非空时缓存同时以压缩形式持久化到该目录，重启后仍可命中
+	PurgeClients     []string          `json:"purgeClients"`     // 允许发送 PURGE/BAN 的网段或 IP，为空时仅允许本机
+
+	// 以下字段用于修改转发的请求和响应头部
+	ClientGroups map[string][]string `json:"clientGroups"` // 客户端分组，成员为 IP、网段或 "user:用户名"
+	ProxyAuth    proxyAuthConfig     `json:"proxyAuth"`    // 代理认证，"user:" 成员只匹配通过认证的用户
+	HeaderRules  []headerRule        `json:"headerRules"`  // 按主机、路径和分组匹配的头部修改规则
+
+	// 以下字段用于按时间段过滤和每日配额
+	RestrictSchedule []scheduleWindow `json:"restrictSchedule"` // 用户过滤生效的时间段，为空时始终生效
+	Quotas           []quotaRule      `json:"quotas"`           // 每个用户或 IP 每天的请求数和字节数上限
+	QuotaFile        string           `json:"quotaFile"`        // 配额计数的保存文件，默认 quota.json
+	QuotaTimezone    string           `json:"quotaTimezone"`    // 配额按该时区的午夜清零，为空时使用本地时区
+
+	// 以下字段用于按网站分类过滤，在 forbidSites 开启时与 siteRules 一起检查
+	CategoryDB    string              `json:"categoryDB"`    // 分类数据库文件，每行为域名和分类
+	CategoryRules []categoryRule      `json:"categoryRules"` // 按分类和用户/分组拦截的规则
+	CategoryAllow map[string][]string `json:"categoryAllow"` // 分类 → 不受该分类规则限制的主机（可用 *.example.com）
+
+	DNS  dnsConfig  `json:"dns"`  // 访问源站时使用的 DNS 解析配置
+	ICAP icapConfig `json:"icap"` // 外部内容检查服务（REQMOD/RESPMOD）
+
+	// 以下字段用于生成 PAC/WPAD 文件
+	DirectHosts    []string `json:"directHosts"`    // 浏览器直连的主机、域名后缀或网段
+	ProxiedHosts   []string `json:"proxiedHosts"`   // 必须经过代理的主机，为空时默认全部代理
+	BlockedDomains []string `json:"blockedDomains"` // 由 PAC 发往黑洞地址的域名
+	Sinkhole       string   `json:"sinkhole"`       // 黑洞代理地址
+
+	loadedAt time.Time
+}
+
+var (
+	policyMu        sync.RWMutex
+	activePolicy    = defaultPolicy()
+	policyModTime   time.Time
+	policyListeners []func(*proxyPolicy)
+)
+
+// defaultPolicy 没有策略文件时使用 handler.go 中的内置配置
+func defaultPolicy() *proxyPolicy {
+	return &proxyPolicy{
+		InvalidWebsites: invalidWebsites,
+		RestrictHosts:   restrictHosts,
+		ForbidHosts:     isAccessForbiddenHostEnabled,
+		ForbidSites:     isAccessForbiddenSiteEnabled,
+		DirectHosts:     []string{"localhost", "127.0.0.1"},
+		Sinkhole:        "127.0.0.1:9",
+		loadedAt:        time.Now(),
+	}
+}
+
+// currentPolicy 返回当前生效的策略，调用方不得修改返回值
+func currentPolicy() *proxyPolicy {
+	policyMu.RLock()
+	defer policyMu.RUnlock()
+	return activePolicy
+}
+
+// onPolicyReload 注册策略重新加载后的回调，注册时会立即以当前策略调用一次
+func onPolicyReload(fn func(*proxyPolicy)) {
+	policyMu.Lock()
+	policyListeners = append(policyListeners, fn)
+	p := activePolicy
+	policyMu.Unlock()
+	fn(p)
+}
+
+// loadPolicy 从文件读取策略，文件中未出现的字段保持默认值
+func loadPolicy(path string) (*proxyPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := defaultPolicy()
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("failed to parse policy %s: %v", path, err)
+	}
+	p.loadedAt = time.Now()
+	return p, nil
+}
+
+// reloadPolicy 重新加载策略文件并通知所有监听者
+func reloadPolicy(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	// 先记录修改时间，解析失败的文件只报告一次，直到再次被修改
+	policyMu.Lock()
+	policyModTime = info.ModTime()
+	policyMu.Unlock()
+
+	p, err := loadPolicy(path)
+	if err != nil {
+		return err
+	}
+
+	policyMu.Lock()
+	activePolicy = p
+	listeners := append([]func(*proxyPolicy){}, policyListeners...)
+	policyMu.Unlock()
+
+	for _, fn := range listeners {
+		fn(p)
+	}
+	fmt.Println("Policy reloaded from", path)
+	return nil
+}
+
+// watchPolicy 在收到 SIGHUP 或策略文件修改时重新加载策略
+func watchPolicy(path string) {
+	if err := reloadPolicy(path); err != nil && !os.IsNotExist(err) {
+		fmt.Println("Error loading policy:", err)
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(policyPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hup:
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			policyMu.RLock()
+			unchanged := info.ModTime().Equal(policyModTime)
+			policyMu.RUnlock()
+			if unchanged {
+				continue
+			}
+		}
+		if err := reloadPolicy(path); err != nil {
+			fmt.Println("Error reloading policy:", err)
+		}
+	}
+}
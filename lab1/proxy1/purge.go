@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// 发布系统使用的缓存失效方法
+const (
+	methodPurge = "PURGE" // 删除单个 URL，或带 Surrogate-Key 头部时删除带有这些标签的所有条目
+	methodBan   = "BAN"   // 删除 URL 匹配 X-Ban-URL 正则表达式的所有条目
+)
+
+// serveInvalidation 处理 PURGE 和 BAN 请求，只允许 purgeClients 中的地址使用
+func serveInvalidation(w http.ResponseWriter, r *http.Request) {
+	allowed := currentPolicy().PurgeClients
+	if len(allowed) == 0 {
+		allowed = defaultAdminClients
+	}
+	if !clientInNetworks(r.RemoteAddr, allowed) {
+		http.Error(w, "Cache invalidation not allowed", http.StatusForbidden)
+		return
+	}
+
+	var purged int
+	result := map[string]any{"method": r.Method}
+	switch {
+	case r.Method == methodPurge && r.Header.Get("Surrogate-Key") != "":
+		tags := strings.Fields(r.Header.Get("Surrogate-Key"))
+		purged = removeCacheEntries(func(_ string, header http.Header) bool {
+			return hasSurrogateKey(header, tags)
+		})
+		result["surrogateKeys"] = tags
+	case r.Method == methodPurge:
+		key := invalidationURL(r)
+		purged = removeCacheEntries(func(k string, _ http.Header) bool { return k == key })
+		result["url"] = key
+	default:
+		pattern := r.Header.Get("X-Ban-URL")
+		if pattern == "" {
+			http.Error(w, "BAN requires an X-Ban-URL header with a URL regular expression", http.StatusBadRequest)
+			return
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			http.Error(w, "Invalid X-Ban-URL: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		purged = removeCacheEntries(func(k string, _ http.Header) bool { return re.MatchString(k) })
+		result["pattern"] = pattern
+	}
+	result["purged"] = purged
+	fmt.Println("Cache invalidation", r.Method, r.RemoteAddr, purged, "entries")
+
+	status := http.StatusOK
+	if purged == 0 && r.Method == methodPurge && result["url"] != nil {
+		status = http.StatusNotFound
+	}
+	writeJSON(w, status, result)
+}
+
+// invalidationURL 返回 PURGE 的目标缓存键。经代理发送时使用绝对 URL，
+// 直接发给代理时由 Host 头部和路径组成
+func invalidationURL(r *http.Request) string {
+	if r.URL.Host != "" {
+		return r.URL.String()
+	}
+	return "http://" + r.Host + r.URL.RequestURI()
+}
+
+// hasSurrogateKey 判断响应的 Surrogate-Key 头部（以空格分隔的标签）是否包含任一标签
+func hasSurrogateKey(header http.Header, tags []string) bool {
+	for _, v := range header.Values("Surrogate-Key") {
+		for _, key := range strings.Fields(v) {
+			for _, tag := range tags {
+				if key == tag {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// removeCacheEntries 删除内存和磁盘中所有匹配的缓存条目，返回删除的缓存键数量
+func removeCacheEntries(match func(key string, header http.Header) bool) int {
+	removed := make(map[string]bool)
+
+	cacheMu.Lock()
+	for key, entry := range cache {
+		if match(key, entry.response.Header) {
+			delete(cache, key)
+			accountCacheEntry(entry, -1)
+			removed[key] = true
+		}
+	}
+	cacheMu.Unlock()
+
+	// 磁盘上的条目可能尚未加载到内存中，按元数据逐个检查
+	if dir := currentPolicy().CacheDir; dir != "" {
+		paths, _ := filepath.Glob(filepath.Join(dir, "*.cache"))
+		for _, path := range paths {
+			meta, err := readCacheMeta(path)
+			if err != nil || !match(meta.URL, meta.Header) {
+				continue
+			}
+			if err := blobs.unlink(dir, path); err != nil {
+				fmt.Println("Error removing cache file:", err)
+				continue
+			}
+			removed[meta.URL] = true
+		}
+	}
+	return len(removed)
+}
+
+// readCacheMeta 只读取缓存文件第一行的元数据
+func readCacheMeta(path string) (*cacheMeta, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	var meta cacheMeta
+	if err := json.Unmarshal(line, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// startTaggedOrigin 按路径为响应添加 Surrogate-Key 标签的源站
+func startTaggedOrigin(t *testing.T) *httptest.Server {
+	t.Helper()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/news/"):
+			w.Header().Set("Surrogate-Key", "news front-page")
+		case strings.HasPrefix(r.URL.Path, "/sports/"):
+			w.Header().Set("Surrogate-Key", "sports")
+		}
+		_, _ = io.WriteString(w, "page "+r.URL.Path)
+	}))
+	t.Cleanup(origin.Close)
+	return origin
+}
+
+func invalidate(method, target, remoteAddr string, header map[string]string) (*httptest.ResponseRecorder, map[string]any) {
+	r := httptest.NewRequest(method, target, nil)
+	r.RemoteAddr = remoteAddr
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	handleRequest(w, r)
+	var result map[string]any
+	_ = json.Unmarshal(w.Body.Bytes(), &result)
+	return w, result
+}
+
+func TestPurgeBanAndSurrogateKeys(t *testing.T) {
+	p := defaultPolicy()
+	p.CacheDir = t.TempDir()
+	p.PurgeClients = []string{"10.1.0.0/16"}
+	withPolicy(t, p)
+	origin := startTaggedOrigin(t)
+
+	paths := []string{"/news/1", "/news/2", "/sports/1", "/about", "/about/team"}
+	fill := func() {
+		for _, path := range paths {
+			w := proxyGet(origin.URL + path)
+			if w.Header().Get("Surrogate-Key") != "" {
+				t.Errorf("%s: Surrogate-Key leaked to client", path)
+			}
+		}
+	}
+	cached := func(path string) bool {
+		_, ok := lookupCache(origin.URL + path)
+		return ok
+	}
+	fill()
+
+	publisher := "10.1.2.3:5000"
+	if w, _ := invalidate(methodPurge, origin.URL+"/about", "192.0.2.1:5000", nil); w.Code != http.StatusForbidden {
+		t.Errorf("PURGE from outside purgeClients: status %d", w.Code)
+	}
+
+	// 单个 URL：同时删除内存和磁盘中的条目
+	w, result := invalidate(methodPurge, origin.URL+"/about", publisher, nil)
+	if w.Code != http.StatusOK || result["purged"] != 1.0 || cached("/about") || !cached("/about/team") {
+		t.Errorf("PURGE /about: status %d result %v", w.Code, result)
+	}
+	if w, _ := invalidate(methodPurge, origin.URL+"/about", publisher, nil); w.Code != http.StatusNotFound {
+		t.Errorf("second PURGE: status %d, want 404", w.Code)
+	}
+
+	// 标签：删除所有带 news 标签的页面
+	_, result = invalidate(methodPurge, origin.URL+"/", publisher, map[string]string{"Surrogate-Key": "news"})
+	if result["purged"] != 2.0 || cached("/news/1") || cached("/news/2") || !cached("/sports/1") {
+		t.Errorf("PURGE Surrogate-Key news: %v", result)
+	}
+
+	// 正则表达式
+	fill()
+	_, result = invalidate(methodBan, origin.URL+"/", publisher, map[string]string{"X-Ban-URL": `/(news|sports)/\d+$`})
+	if result["purged"] != 3.0 || cached("/news/1") || cached("/sports/1") || !cached("/about") {
+		t.Errorf("BAN: %v", result)
+	}
+	if w, _ := invalidate(methodBan, origin.URL+"/", publisher, map[string]string{"X-Ban-URL": "("}); w.Code != http.StatusBadRequest {
+		t.Errorf("BAN with invalid regex: status %d", w.Code)
+	}
+}
+
+func TestPurgeDiskOnlyEntries(t *testing.T) {
+	p := defaultPolicy()
+	p.CacheDir = t.TempDir()
+	withPolicy(t, p)
+	origin := startTaggedOrigin(t)
+
+	proxyGet(origin.URL + "/sports/live")
+	// 模拟重启：内存中的缓存清空，条目只存在于磁盘
+	cacheMu.Lock()
+	entry := cache[origin.URL+"/sports/live"]
+	delete(cache, origin.URL+"/sports/live")
+	accountCacheEntry(entry, -1)
+	cacheMu.Unlock()
+
+	// 直接发给代理的 PURGE 用 Host 头部指定站点
+	r := httptest.NewRequest(methodPurge, "/", nil)
+	r.Host = strings.TrimPrefix(origin.URL, "http://")
+	r.Header.Set("Surrogate-Key", "sports")
+	r.RemoteAddr = "127.0.0.1:4000"
+	w := httptest.NewRecorder()
+	handleRequest(w, r)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"purged": 1`) {
+		t.Errorf("status %d body %s", w.Code, w.Body.String())
+	}
+	if _, ok := lookupCache(origin.URL + "/sports/live"); ok {
+		t.Error("disk entry survived the purge")
+	}
+}
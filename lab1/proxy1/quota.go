@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultQuotaFile  = "quota.json"     // 策略未配置 quotaFile 时保存配额计数的文件
+	quotaSaveInterval = 30 * time.Second // 配额计数写入磁盘的间隔
+)
+
+// quotaRule 每个客户端每天的请求数和下载字节数上限
+type quotaRule struct {
+	ID            string   `json:"id"`
+	Clients       []string `json:"clients"`       // IP、网段或 "user:用户名"，与 Groups 都为空时适用于所有客户端
+	Groups        []string `json:"groups"`        // clientGroups 中的分组名
+	PerUser       bool     `json:"perUser"`       // 按通过代理认证的用户名计数，未认证时按 IP 计数
+	DailyBytes    int64    `json:"dailyBytes"`    // 每天发送给客户端的响应体字节数上限，0 表示不限制
+	DailyRequests int64    `json:"dailyRequests"` // 每天的请求数上限，0 表示不限制
+}
+
+// quotaUsage 一个客户端在一条配额规则下当天的用量
+type quotaUsage struct {
+	Requests int64 `json:"requests"`
+	Bytes    int64 `json:"bytes"`
+}
+
+// quotaKey 一次请求计入的一个配额计数及其字节数上限
+type quotaKey struct {
+	key        string
+	dailyBytes int64 // 0 表示不限制
+}
+
+// quotaState 配额计数，按日期整体清零，定期保存到 quotaFile
+type quotaState struct {
+	mu    sync.Mutex
+	path  string
+	dirty bool
+	Day   string                 `json:"day"`
+	Usage map[string]*quotaUsage `json:"usage"` // 键为 "规则ID|ip:地址" 或 "规则ID|user:用户名"
+}
+
+var quotas = &quotaState{Usage: make(map[string]*quotaUsage)}
+
+func init() {
+	localMux.HandleFunc("/admin/quotas", adminOnly(serveQuotas))
+	onPolicyReload(func(p *proxyPolicy) {
+		path := p.QuotaFile
+		if path == "" {
+			path = defaultQuotaFile
+		}
+		quotas.open(path)
+	})
+}
+
+// quotaDay 返回配额时区中的日期，日期变化时计数清零
+func quotaDay(p *proxyPolicy, t time.Time) string {
+	if p.QuotaTimezone != "" {
+		if loc, err := time.LoadLocation(p.QuotaTimezone); err == nil {
+			t = t.In(loc)
+		}
+	}
+	return t.Format(time.DateOnly)
+}
+
+// quotaSubject 返回客户端在规则下的计数对象
+func quotaSubject(rule quotaRule, r *http.Request) string {
+	if rule.PerUser {
+		if user := clientUser(r); user != "" {
+			return "user:" + user
+		}
+	}
+	return "ip:" + clientIP(r.RemoteAddr)
+}
+
+// open 切换保存文件并读取其中当天的计数，文件不存在或日期已过时从零开始
+func (q *quotaState) open(path string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if path == q.path {
+		return
+	}
+	q.flushLocked()
+	q.path = path
+	q.Day = ""
+	q.Usage = make(map[string]*quotaUsage)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			fmt.Println("Error loading quota file:", err)
+		}
+		return
+	}
+	var saved quotaState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		fmt.Println("Error parsing quota file:", err)
+		return
+	}
+	q.Day = saved.Day
+	if saved.Usage != nil {
+		q.Usage = saved.Usage
+	}
+}
+
+// rollover 日期变化时清零所有计数，调用方持有锁
+func (q *quotaState) rollover(day string) {
+	if q.Day != day {
+		q.Day = day
+		q.Usage = make(map[string]*quotaUsage)
+		q.dirty = true
+	}
+}
+
+// admit 检查客户端在所有适用规则下是否超出配额。未超出时计入本次请求，
+// 返回的键用于之后累加和限制下载字节数；超出时返回拦截原因
+func (q *quotaState) admit(p *proxyPolicy, r *http.Request, now time.Time) ([]quotaKey, blockDecision, bool) {
+	if len(p.Quotas) == 0 {
+		return nil, blockDecision{}, false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollover(quotaDay(p, now))
+
+	var keys []quotaKey
+	for _, rule := range p.Quotas {
+		if (len(rule.Clients) > 0 || len(rule.Groups) > 0) &&
+			!clientMatches(r, rule.Clients) && !clientInGroups(p, r, rule.Groups) {
+			continue
+		}
+		subject := quotaSubject(rule, r)
+		key := rule.ID + "|" + subject
+		u := q.Usage[key]
+		if u == nil {
+			u = &quotaUsage{}
+		}
+		if (rule.DailyRequests > 0 && u.Requests >= rule.DailyRequests) ||
+			(rule.DailyBytes > 0 && u.Bytes >= rule.DailyBytes) {
+			return nil, quotaDecision(rule, subject, u), true
+		}
+		keys = append(keys, quotaKey{key: key, dailyBytes: rule.DailyBytes})
+	}
+	for _, k := range keys {
+		u := q.Usage[k.key]
+		if u == nil {
+			u = &quotaUsage{}
+			q.Usage[k.key] = u
+		}
+		u.Requests++
+	}
+	if len(keys) > 0 {
+		q.dirty = true
+	}
+	return keys, blockDecision{}, false
+}
+
+// quotaDecision 说明超出的是哪一项配额以及当前用量
+func quotaDecision(rule quotaRule, subject string, u *quotaUsage) blockDecision {
+	reason := fmt.Sprintf("Daily quota %q exceeded for %s:", rule.ID, subject)
+	if rule.DailyRequests > 0 {
+		reason += fmt.Sprintf(" %d of %d requests used;", u.Requests, rule.DailyRequests)
+	}
+	if rule.DailyBytes > 0 {
+		reason += fmt.Sprintf(" %d of %d bytes downloaded;", u.Bytes, rule.DailyBytes)
+	}
+	reason += " the quota resets at midnight."
+	return blockDecision{RuleID: "quota:" + rule.ID, Category: "quota", Reason: reason}
+}
+
+// addBytes 为本次请求匹配的所有计数累加下载字节数
+func (q *quotaState) addBytes(keys []quotaKey, n int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, k := range keys {
+		// 请求跨越午夜时计数已经清零，字节计入新的一天
+		u := q.Usage[k.key]
+		if u == nil {
+			u = &quotaUsage{}
+			q.Usage[k.key] = u
+		}
+		u.Bytes += n
+	}
+	q.dirty = true
+}
+
+// allowBytes 返回在所有字节数配额内还能发送的字节数，最多为 n
+func (q *quotaState) allowBytes(keys []quotaKey, n int64) int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, k := range keys {
+		if k.dailyBytes <= 0 {
+			continue
+		}
+		var used int64
+		if u := q.Usage[k.key]; u != nil {
+			used = u.Bytes
+		}
+		n = min(n, max(k.dailyBytes-used, 0))
+	}
+	return n
+}
+
+// flush 将有变化的计数写入文件
+func (q *quotaState) flush() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.flushLocked()
+}
+
+func (q *quotaState) flushLocked() {
+	if !q.dirty || q.path == "" {
+		return
+	}
+	data, err := json.MarshalIndent(q, "", "  ")
+	if err != nil {
+		fmt.Println("Error encoding quota file:", err)
+		return
+	}
+	// 先写临时文件再重命名，避免进程中断时留下不完整的文件
+	tmp, err := os.CreateTemp(filepath.Dir(q.path), ".quota-*")
+	if err != nil {
+		fmt.Println("Error saving quota file:", err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), q.path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		fmt.Println("Error saving quota file:", err)
+		return
+	}
+	q.dirty = false
+}
+
+// watchQuotas 定期保存配额计数，并在没有请求时也按日期清零
+func watchQuotas() {
+	ticker := time.NewTicker(quotaSaveInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		quotas.mu.Lock()
+		if len(quotas.Usage) > 0 {
+			quotas.rollover(quotaDay(currentPolicy(), time.Now()))
+		}
+		quotas.mu.Unlock()
+		quotas.flush()
+	}
+}
+
+// quotaWriter 统计发送给客户端的响应体字节数。配额只在请求开始时检查，
+// 一个很大的响应仍可能超出剩余的字节数，所以发送时也检查：达到上限后中断连接，
+// 客户端看到的是不完整的响应，而不是被静默截断的内容
+type quotaWriter struct {
+	http.ResponseWriter
+	keys []quotaKey
+}
+
+// withQuotaAccounting 返回累加并限制下载字节数的 ResponseWriter
+func withQuotaAccounting(w http.ResponseWriter, keys []quotaKey) http.ResponseWriter {
+	if len(keys) == 0 {
+		return w
+	}
+	return &quotaWriter{ResponseWriter: w, keys: keys}
+}
+
+func (qw *quotaWriter) Write(p []byte) (int, error) {
+	allowed := quotas.allowBytes(qw.keys, int64(len(p)))
+	n, err := qw.ResponseWriter.Write(p[:allowed])
+	quotas.addBytes(qw.keys, int64(n))
+	if err == nil && allowed < int64(len(p)) {
+		fmt.Println("Quota exceeded while sending the response, aborting")
+		panic(http.ErrAbortHandler)
+	}
+	return n, err
+}
+
+func (qw *quotaWriter) Unwrap() http.ResponseWriter {
+	return qw.ResponseWriter
+}
+
+// serveQuotas 返回当天的配额用量
+func serveQuotas(w http.ResponseWriter, r *http.Request) {
+	quotas.mu.Lock()
+	defer quotas.mu.Unlock()
+	keys := make([]string, 0, len(quotas.Usage))
+	for key := range quotas.Usage {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	usage := make([]map[string]any, 0, len(keys))
+	for _, key := range keys {
+		u := quotas.Usage[key]
+		usage = append(usage, map[string]any{"key": key, "requests": u.Requests, "bytes": u.Bytes})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"day": quotas.Day, "usage": usage})
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withQuotaFile 在测试期间使用临时目录中的配额文件
+func withQuotaFile(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "quota.json")
+	quotas.mu.Lock()
+	oldPath := quotas.path
+	quotas.path = ""
+	quotas.mu.Unlock()
+	quotas.open(path)
+	t.Cleanup(func() {
+		quotas.mu.Lock()
+		quotas.path, quotas.dirty = oldPath, false
+		quotas.Day, quotas.Usage = "", make(map[string]*quotaUsage)
+		quotas.mu.Unlock()
+	})
+	return path
+}
+
+func TestQuotaAdmit(t *testing.T) {
+	withQuotaFile(t)
+	p := defaultPolicy()
+	p.ClientGroups = map[string][]string{"students": {"10.0.0.0/8"}}
+	p.Quotas = []quotaRule{
+		{ID: "students", Groups: []string{"students"}, PerUser: true, DailyRequests: 2},
+		{ID: "everyone", DailyBytes: 100},
+	}
+	p.ProxyAuth.Users = map[string]string{"alice": "x", "bob": "x"}
+	day := time.Date(2024, 9, 2, 10, 0, 0, 0, time.Local)
+
+	request := func(remoteAddr, user string) *http.Request {
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		r.RemoteAddr = remoteAddr
+		if user != "" {
+			r = proxyLogin(p, r, user, "x")
+		}
+		return r
+	}
+
+	// 同一用户从不同地址访问共享请求数配额
+	for _, addr := range []string{"10.0.0.1:1", "10.0.0.2:1"} {
+		if _, d, over := quotas.admit(p, request(addr, "alice"), day); over {
+			t.Fatalf("alice from %s: unexpectedly over quota: %s", addr, d.Reason)
+		}
+	}
+	_, d, over := quotas.admit(p, request("10.0.0.3:1", "alice"), day)
+	if !over || d.RuleID != "quota:students" || !strings.Contains(d.Reason, "2 of 2 requests") {
+		t.Errorf("alice third request: over=%v decision=%+v", over, d)
+	}
+	if _, _, over := quotas.admit(p, request("10.0.0.3:1", "bob"), day); over {
+		t.Error("bob should have a separate counter")
+	}
+
+	// 字节数配额在发送响应后累加
+	keys, _, over := quotas.admit(p, request("192.0.2.1:1", ""), day)
+	if over || len(keys) != 1 {
+		t.Fatalf("outside client: keys %v over %v", keys, over)
+	}
+	quotas.addBytes(keys, 150)
+	if _, d, over := quotas.admit(p, request("192.0.2.1:1", ""), day); !over || d.RuleID != "quota:everyone" {
+		t.Errorf("byte quota: over=%v decision=%+v", over, d)
+	}
+
+	// 第二天计数清零
+	if _, _, over := quotas.admit(p, request("10.0.0.3:1", "alice"), day.AddDate(0, 0, 1)); over {
+		t.Error("quota did not reset on the next day")
+	}
+}
+
+func TestQuotaPersistence(t *testing.T) {
+	path := withQuotaFile(t)
+	p := defaultPolicy()
+	p.Quotas = []quotaRule{{ID: "daily", DailyRequests: 3}}
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+	r.RemoteAddr = "192.0.2.9:1"
+	now := time.Now()
+
+	keys, _, _ := quotas.admit(p, r, now)
+	quotas.addBytes(keys, 42)
+	quotas.flush()
+
+	// 模拟重启：重新打开文件后计数保留
+	quotas.mu.Lock()
+	quotas.path, quotas.Usage = "", make(map[string]*quotaUsage)
+	quotas.mu.Unlock()
+	quotas.open(path)
+	quotas.mu.Lock()
+	u := quotas.Usage["daily|ip:192.0.2.9"]
+	quotas.mu.Unlock()
+	if u == nil || u.Requests != 1 || u.Bytes != 42 {
+		t.Fatalf("usage after reopen: %+v", u)
+	}
+}
+
+// TestQuotaDenialPage 第一个响应在配额之内；第二个响应发送到一半用完配额，连接被中断；
+// 之后的请求得到拒绝页面
+func TestQuotaDenialPage(t *testing.T) {
+	withQuotaFile(t)
+	silenceStdout(t)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, strings.Repeat("x", 64))
+	}))
+	defer origin.Close()
+
+	p := defaultPolicy()
+	p.Quotas = []quotaRule{{ID: "tiny", DailyBytes: 100}}
+	withPolicy(t, p)
+	client := proxyClient(startProxyServer(t), false)
+	// 中断发生在响应头发出之前时，复用的连接上的 GET 会被客户端自动重试
+	client.Transport.(*http.Transport).DisableKeepAlives = true
+
+	get := func() (*http.Response, []byte, error) {
+		req, _ := http.NewRequest("GET", origin.URL+"/big", nil)
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Accept-Encoding", "identity") // 不压缩，发送的字节数就是响应体长度
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return resp, body, err
+	}
+
+	if resp, body, err := get(); err != nil || resp.StatusCode != 200 || len(body) != 64 {
+		t.Fatalf("first request: %v %v %d bytes", resp, err, len(body))
+	}
+	if _, body, err := get(); err == nil || len(body) > 36 {
+		t.Errorf("second request exceeding the quota: err %v, %d bytes", err, len(body))
+	}
+	resp, body, err := get()
+	if err != nil || resp.StatusCode != 403 || !strings.Contains(string(body), `"rule": "quota:tiny"`) {
+		t.Errorf("third request: %v %s", err, body)
+	}
+}
@@ -0,0 +1,545 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http/httptrace"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	systemResolverTTL  = 60 * time.Second       // 使用系统解析器时无法得知 TTL，按该时间缓存
+	defaultNegativeTTL = 30 * time.Second       // 应答中没有 SOA 时否定结果的缓存时间
+	maxDNSTTL          = 24 * time.Hour         // 缓存时间上限
+	dnsQueryTimeout    = 5 * time.Second        // 单次向上游 DNS 服务器查询的超时
+	maxDNSCacheEntries = 10000                  // 缓存的域名数上限
+	dialAttemptDelay   = 250 * time.Millisecond // 连接一个地址多久没有结果就同时尝试下一个（RFC 8305）
+)
+
+// dnsConfig 策略中的 DNS 解析配置
+type dnsConfig struct {
+	Server    string              `json:"server"`    // 上游 DNS 服务器 "ip:port"，为空时使用系统解析器
+	Protocol  string              `json:"protocol"`  // "udp"（默认，应答被截断时改用 TCP）或 "tcp"
+	Overrides map[string][]string `json:"overrides"` // 主机名到 IP 的固定映射，优先于 DNS 查询
+	HostsFile string              `json:"hostsFile"` // hosts 格式的覆盖文件，与 overrides 合并
+}
+
+// dnsCacheEntry 一个域名的缓存结果，ips 为空表示否定缓存
+type dnsCacheEntry struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+// dnsCall 正在进行的查询，同一域名的并发查询共享结果
+type dnsCall struct {
+	done chan struct{}
+	ips  []net.IP
+	err  error
+}
+
+// cachingResolver 代理访问源站时使用的解析器：覆盖表、遵循 TTL 的正向与否定缓存、可配置的上游服务器
+type cachingResolver struct {
+	mu        sync.Mutex
+	server    string
+	protocol  string
+	overrides map[string][]net.IP
+	cache     map[string]*dnsCacheEntry
+	inflight  map[string]*dnsCall
+	now       func() time.Time
+
+	hits         atomic.Int64
+	negativeHits atomic.Int64
+	misses       atomic.Int64
+	overrideHits atomic.Int64
+	queries      atomic.Int64
+	failures     atomic.Int64
+}
+
+// upstreamDialer 建立到源站的 TCP 连接
+var upstreamDialer = &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+
+var resolver = newCachingResolver()
+
+func init() {
+	onPolicyReload(func(p *proxyPolicy) {
+		resolver.configure(p.DNS)
+	})
+}
+
+func newCachingResolver() *cachingResolver {
+	return &cachingResolver{
+		overrides: make(map[string][]net.IP),
+		cache:     make(map[string]*dnsCacheEntry),
+		inflight:  make(map[string]*dnsCall),
+		now:       time.Now,
+	}
+}
+
+// configure 应用新的 DNS 配置并清空缓存
+func (c *cachingResolver) configure(cfg dnsConfig) {
+	overrides := make(map[string][]net.IP)
+	for name, addrs := range cfg.Overrides {
+		for _, a := range addrs {
+			if ip := net.ParseIP(a); ip != nil {
+				overrides[normalizeDNSName(name)] = append(overrides[normalizeDNSName(name)], ip)
+			} else {
+				fmt.Printf("DNS override %s: invalid IP %q\n", name, a)
+			}
+		}
+	}
+	if cfg.HostsFile != "" {
+		if err := loadHostsOverrides(cfg.HostsFile, overrides); err != nil {
+			fmt.Println("Error loading DNS hosts file:", err)
+		}
+	}
+
+	server := cfg.Server
+	if server != "" {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(strings.Trim(server, "[]"), "53")
+		}
+	}
+	protocol := strings.ToLower(cfg.Protocol)
+	if protocol != "" && protocol != "udp" && protocol != "tcp" {
+		fmt.Printf("Unknown DNS protocol %q, using udp\n", cfg.Protocol)
+		protocol = ""
+	}
+
+	c.mu.Lock()
+	c.server, c.protocol, c.overrides = server, protocol, overrides
+	c.cache = make(map[string]*dnsCacheEntry)
+	c.mu.Unlock()
+}
+
+// loadHostsOverrides 读取 "IP 主机名..." 格式的文件，# 之后为注释
+func loadHostsOverrides(path string, overrides map[string][]net.IP) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil {
+			continue
+		}
+		for _, name := range fields[1:] {
+			overrides[normalizeDNSName(name)] = append(overrides[normalizeDNSName(name)], ip)
+		}
+	}
+	return scanner.Err()
+}
+
+func normalizeDNSName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
+
+// dialContext 供 upstreamTransport 使用：先解析主机名，再按 Happy Eyeballs（RFC 8305）的方式连接。
+// 解析期间调用请求上下文中 httptrace 的 DNSStart/DNSDone，HAR 因此能记录 DNS 耗时
+func (c *cachingResolver) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := c.tracedLookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []string
+	for _, ip := range interleaveFamilies(ips) {
+		if (network == "tcp4" && ip.To4() == nil) || (network == "tcp6" && ip.To4() != nil) {
+			continue
+		}
+		addrs = append(addrs, net.JoinHostPort(ip.String(), port))
+	}
+	if len(addrs) == 0 {
+		return nil, &net.AddrError{Err: "no suitable address", Addr: host}
+	}
+	// 所有地址共用一个连接超时，而不是每个地址各等一个完整的超时
+	if upstreamDialer.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, upstreamDialer.Timeout)
+		defer cancel()
+	}
+	return raceDial(ctx, addrs, func(ctx context.Context, addr string) (net.Conn, error) {
+		return upstreamDialer.DialContext(ctx, network, addr)
+	})
+}
+
+// tracedLookup 解析主机名，并把解析过程报告给请求的 httptrace；IP 字面量不经过解析，不报告
+func (c *cachingResolver) tracedLookup(ctx context.Context, host string) ([]net.IP, error) {
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace == nil || net.ParseIP(strings.Trim(host, "[]")) != nil {
+		return c.lookup(ctx, host)
+	}
+	if trace.DNSStart != nil {
+		trace.DNSStart(httptrace.DNSStartInfo{Host: host})
+	}
+	ips, err := c.lookup(ctx, host)
+	if trace.DNSDone != nil {
+		addrs := make([]net.IPAddr, len(ips))
+		for i, ip := range ips {
+			addrs[i] = net.IPAddr{IP: ip}
+		}
+		trace.DNSDone(httptrace.DNSDoneInfo{Addrs: addrs, Err: err})
+	}
+	return ips, err
+}
+
+// interleaveFamilies 把地址排成两个地址族交替出现，以第一个地址的地址族开头，
+// 一个地址族整体不通时很快就会尝试另一个
+func interleaveFamilies(ips []net.IP) []net.IP {
+	if len(ips) == 0 {
+		return ips
+	}
+	first := ips[0].To4() != nil
+	var primary, fallback []net.IP
+	for _, ip := range ips {
+		if (ip.To4() != nil) == first {
+			primary = append(primary, ip)
+		} else {
+			fallback = append(fallback, ip)
+		}
+	}
+	out := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(primary) || i < len(fallback); i++ {
+		if i < len(primary) {
+			out = append(out, primary[i])
+		}
+		if i < len(fallback) {
+			out = append(out, fallback[i])
+		}
+	}
+	return out
+}
+
+// dialResult 一次连接尝试的结果
+type dialResult struct {
+	conn net.Conn
+	err  error
+}
+
+// raceDial 依次开始连接各地址：上一个尝试失败或 dialAttemptDelay 内没有连通时开始下一个，
+// 已开始的尝试继续进行，先连通的胜出，其余的被取消，稍后连通的连接被关闭
+func raceDial(ctx context.Context, addrs []string, dial func(context.Context, string) (net.Conn, error)) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan dialResult, len(addrs))
+	next, pending := 0, 0
+	start := func() {
+		addr := addrs[next]
+		next++
+		pending++
+		go func() {
+			conn, err := dial(ctx, addr)
+			results <- dialResult{conn, err}
+		}()
+	}
+
+	start()
+	timer := time.NewTimer(dialAttemptDelay)
+	defer timer.Stop()
+	var lastErr error
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				go func(n int) {
+					for ; n > 0; n-- {
+						if late := <-results; late.conn != nil {
+							_ = late.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, nil
+			}
+			lastErr = r.err
+		case <-timer.C:
+		}
+		if next < len(addrs) {
+			start()
+			timer.Reset(dialAttemptDelay)
+		}
+	}
+	return nil, lastErr
+}
+
+// lookup 解析主机名，依次使用 IP 字面量、覆盖表、缓存和上游查询
+func (c *cachingResolver) lookup(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	name := normalizeDNSName(host)
+
+	c.mu.Lock()
+	if ips, ok := c.overrides[name]; ok {
+		c.mu.Unlock()
+		c.overrideHits.Add(1)
+		return ips, nil
+	}
+	if e, ok := c.cache[name]; ok && c.now().Before(e.expires) {
+		c.mu.Unlock()
+		if len(e.ips) == 0 {
+			c.negativeHits.Add(1)
+			return nil, notFoundError(host)
+		}
+		c.hits.Add(1)
+		return e.ips, nil
+	}
+	if call, ok := c.inflight[name]; ok {
+		c.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.ips, call.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	call := &dnsCall{done: make(chan struct{})}
+	c.inflight[name] = call
+	c.mu.Unlock()
+
+	c.misses.Add(1)
+	// 查询不随单个请求取消，结果由等待同一域名的所有请求共享
+	ips, ttl, err := c.resolve(context.WithoutCancel(ctx), name)
+	call.ips, call.err = ips, err
+	if err != nil && ttl < 0 {
+		c.failures.Add(1)
+	}
+
+	c.mu.Lock()
+	delete(c.inflight, name)
+	if ttl >= 0 {
+		c.store(name, ips, min(ttl, maxDNSTTL))
+	}
+	c.mu.Unlock()
+	close(call.done)
+	return ips, err
+}
+
+// store 写入缓存，超过上限时先清理过期的条目，调用方持有锁
+func (c *cachingResolver) store(name string, ips []net.IP, ttl time.Duration) {
+	now := c.now()
+	if len(c.cache) >= maxDNSCacheEntries {
+		for k, e := range c.cache {
+			if !now.Before(e.expires) {
+				delete(c.cache, k)
+			}
+		}
+		for k := range c.cache {
+			if len(c.cache) < maxDNSCacheEntries {
+				break
+			}
+			delete(c.cache, k)
+		}
+	}
+	c.cache[name] = &dnsCacheEntry{ips: ips, expires: now.Add(ttl)}
+}
+
+// resolve 向上游查询 A 和 AAAA 记录。返回的 ttl 为负数表示结果不可缓存（例如超时或 SERVFAIL）
+func (c *cachingResolver) resolve(ctx context.Context, name string) ([]net.IP, time.Duration, error) {
+	c.mu.Lock()
+	server, protocol := c.server, c.protocol
+	c.mu.Unlock()
+
+	if server == "" {
+		return resolveSystem(ctx, name)
+	}
+
+	type result struct {
+		ans *dnsAnswer
+		err error
+	}
+	results := make(chan result, 2)
+	for _, qtype := range []uint16{dnsTypeA, dnsTypeAAAA} {
+		go func() {
+			c.queries.Add(1)
+			ans, err := exchangeDNS(ctx, server, protocol, name, qtype)
+			results <- result{ans, err}
+		}()
+	}
+
+	var ips []net.IP
+	ttl, negTTL := time.Duration(-1), time.Duration(-1)
+	var firstErr error
+	negative := 0
+	for i := 0; i < 2; i++ {
+		r := <-results
+		switch {
+		case r.err != nil:
+			if firstErr == nil {
+				firstErr = r.err
+			}
+		case r.ans.rcode == dnsRcodeSuccess && len(r.ans.ips) > 0:
+			for _, ip := range r.ans.ips {
+				ips = append(ips, net.IP(ip))
+			}
+			ttl = minTTL(ttl, r.ans.ttl)
+		case r.ans.rcode == dnsRcodeSuccess || r.ans.rcode == dnsRcodeNXDomain:
+			negative++
+			if r.ans.negTTL >= 0 {
+				negTTL = minTTL(negTTL, r.ans.negTTL)
+			}
+		default:
+			if firstErr == nil {
+				firstErr = fmt.Errorf("DNS server returned rcode %d", r.ans.rcode)
+			}
+		}
+	}
+	if len(ips) > 0 {
+		// A 记录排在前面，优先使用 IPv4
+		sortIPv4First(ips)
+		return ips, ttl, nil
+	}
+	if negative == 2 {
+		if negTTL < 0 {
+			negTTL = defaultNegativeTTL
+		}
+		return nil, negTTL, notFoundError(name)
+	}
+	return nil, -1, &net.DNSError{Err: firstErr.Error(), Name: name, Server: server, IsTemporary: true}
+}
+
+// resolveSystem 使用系统解析器查询，结果按固定时间缓存
+func resolveSystem(ctx context.Context, name string) ([]net.IP, time.Duration, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, name)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return nil, defaultNegativeTTL, err
+		}
+		return nil, -1, err
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, a := range addrs {
+		ips = append(ips, a.IP)
+	}
+	sortIPv4First(ips)
+	return ips, systemResolverTTL, nil
+}
+
+func sortIPv4First(ips []net.IP) {
+	v4 := ips[:0:0]
+	var v6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	copy(ips, append(v4, v6...))
+}
+
+func notFoundError(name string) error {
+	return &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+// exchangeDNS 向服务器发送一次查询。使用 UDP 时应答被截断则改用 TCP 重试
+func exchangeDNS(ctx context.Context, server, protocol, name string, qtype uint16) (*dnsAnswer, error) {
+	id := uint16(rand.UintN(1 << 16))
+	query, err := buildDNSQuery(id, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, dnsQueryTimeout)
+	defer cancel()
+
+	if protocol != "tcp" {
+		ans, err := exchangeDNSUDP(ctx, server, query, id)
+		if err != nil || !ans.truncated {
+			return ans, err
+		}
+	}
+	return exchangeDNSTCP(ctx, server, query, id)
+}
+
+func exchangeDNSUDP(ctx context.Context, server string, query []byte, id uint16) (*dnsAnswer, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// 忽略 ID 不匹配的迟到应答
+		if ans, err := parseDNSResponse(buf[:n], id); err == nil {
+			return ans, nil
+		}
+	}
+}
+
+func exchangeDNSTCP(ctx context.Context, server string, query []byte, id uint16) (*dnsAnswer, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	// TCP 上的报文前有两字节长度
+	msg := binary.BigEndian.AppendUint16(nil, uint16(len(query)))
+	if _, err := conn.Write(append(msg, query...)); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, err
+	}
+	return parseDNSResponse(resp, id)
+}
+
+// stats 返回解析器的统计数据
+func (c *cachingResolver) stats() map[string]any {
+	c.mu.Lock()
+	server, cached, overrides := c.server, len(c.cache), len(c.overrides)
+	c.mu.Unlock()
+	if server == "" {
+		server = "system"
+	}
+	return map[string]any{
+		"server":          server,
+		"cachedNames":     cached,
+		"overrides":       overrides,
+		"hits":            c.hits.Load(),
+		"negativeHits":    c.negativeHits.Load(),
+		"misses":          c.misses.Load(),
+		"overrideHits":    c.overrideHits.Load(),
+		"upstreamQueries": c.queries.Load(),
+		"failures":        c.failures.Load(),
+	}
+}
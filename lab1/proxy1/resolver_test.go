@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeRecord 测试 DNS 服务器中一个域名的记录
+type fakeRecord struct {
+	v4, v6   []string
+	ttl      uint32
+	nxdomain bool
+	soaTTL   uint32
+	soaMin   uint32
+	servfail bool
+}
+
+// fakeDNSServer 同时在 UDP 和 TCP 上应答的测试 DNS 服务器
+type fakeDNSServer struct {
+	addr        string
+	records     map[string]fakeRecord
+	truncateUDP bool
+	udpQueries  atomic.Int64
+	tcpQueries  atomic.Int64
+}
+
+func startFakeDNS(t *testing.T, records map[string]fakeRecord, truncateUDP bool) *fakeDNSServer {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeDNSServer{addr: pc.LocalAddr().String(), records: records, truncateUDP: truncateUDP}
+	t.Cleanup(func() {
+		_ = pc.Close()
+		_ = ln.Close()
+	})
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			s.udpQueries.Add(1)
+			_, _ = pc.WriteTo(s.respond(buf[:n], s.truncateUDP), addr)
+		}
+	}()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var length [2]byte
+				if _, err := io.ReadFull(conn, length[:]); err != nil {
+					return
+				}
+				query := make([]byte, binary.BigEndian.Uint16(length[:]))
+				if _, err := io.ReadFull(conn, query); err != nil {
+					return
+				}
+				s.tcpQueries.Add(1)
+				resp := s.respond(query, false)
+				_, _ = conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(resp))), resp...))
+			}()
+		}
+	}()
+	return s
+}
+
+// respond 按记录表构造应答，应答记录的名字使用指向问题部分的压缩指针
+func (s *fakeDNSServer) respond(query []byte, truncate bool) []byte {
+	off := 12
+	var labels []string
+	for query[off] != 0 {
+		n := int(query[off])
+		labels = append(labels, string(query[off+1:off+1+n]))
+		off += 1 + n
+	}
+	qtype := binary.BigEndian.Uint16(query[off+1:])
+	question := query[12 : off+5]
+	rec := s.records[strings.Join(labels, ".")]
+
+	resp := append([]byte(nil), query[:12]...)
+	flags := uint16(dnsFlagResponse | dnsFlagRecursion | 0x0080)
+	switch {
+	case rec.servfail:
+		flags |= 2
+	case rec.nxdomain || (rec.v4 == nil && rec.v6 == nil):
+		flags |= dnsRcodeNXDomain
+	}
+	if truncate {
+		flags |= dnsFlagTruncated
+	}
+	binary.BigEndian.PutUint16(resp[2:], flags)
+	binary.BigEndian.PutUint16(resp[6:], 0)
+	binary.BigEndian.PutUint16(resp[8:], 0)
+	binary.BigEndian.PutUint16(resp[10:], 0)
+	resp = append(resp, question...)
+	if truncate || rec.servfail {
+		return resp
+	}
+
+	var addrs []string
+	if qtype == dnsTypeA {
+		addrs = rec.v4
+	} else if qtype == dnsTypeAAAA {
+		addrs = rec.v6
+	}
+	for _, a := range addrs {
+		ip := net.ParseIP(a)
+		rtype, data := uint16(dnsTypeA), []byte(ip.To4())
+		if qtype == dnsTypeAAAA {
+			rtype, data = dnsTypeAAAA, []byte(ip.To16())
+		}
+		resp = append(resp, 0xC0, 12)
+		resp = binary.BigEndian.AppendUint16(resp, rtype)
+		resp = binary.BigEndian.AppendUint16(resp, dnsClassIN)
+		resp = binary.BigEndian.AppendUint32(resp, rec.ttl)
+		resp = binary.BigEndian.AppendUint16(resp, uint16(len(data)))
+		resp = append(resp, data...)
+	}
+	binary.BigEndian.PutUint16(resp[6:], uint16(len(addrs)))
+
+	if len(addrs) == 0 && rec.soaTTL > 0 {
+		var rdata []byte
+		rdata, _ = appendDNSName(rdata, "ns.test")
+		rdata = append(rdata, 0xC0, byte(len(resp)+12)) // 指向上一个名字的压缩指针
+		for _, v := range []uint32{1, 3600, 600, 86400, rec.soaMin} {
+			rdata = binary.BigEndian.AppendUint32(rdata, v)
+		}
+		resp = append(resp, 0xC0, 12)
+		resp = binary.BigEndian.AppendUint16(resp, dnsTypeSOA)
+		resp = binary.BigEndian.AppendUint16(resp, dnsClassIN)
+		resp = binary.BigEndian.AppendUint32(resp, rec.soaTTL)
+		resp = binary.BigEndian.AppendUint16(resp, uint16(len(rdata)))
+		resp = append(resp, rdata...)
+		binary.BigEndian.PutUint16(resp[8:], 1)
+	}
+	return resp
+}
+
+// fakeClock 可手动推进的时钟
+type fakeClock struct{ t atomic.Int64 }
+
+func (c *fakeClock) now() time.Time          { return time.Unix(0, c.t.Load()) }
+func (c *fakeClock) advance(d time.Duration) { c.t.Add(int64(d)) }
+
+func newTestResolver(t *testing.T, cfg dnsConfig) (*cachingResolver, *fakeClock) {
+	t.Helper()
+	c := newCachingResolver()
+	clock := &fakeClock{}
+	clock.t.Store(time.Date(2024, 9, 2, 0, 0, 0, 0, time.UTC).UnixNano())
+	c.now = clock.now
+	c.configure(cfg)
+	return c, clock
+}
+
+func TestResolverPositiveAndNegativeCache(t *testing.T) {
+	dns := startFakeDNS(t, map[string]fakeRecord{
+		"lab.test":     {v4: []string{"192.0.2.1"}, v6: []string{"2001:db8::1"}, ttl: 30},
+		"missing.test": {nxdomain: true, soaTTL: 60, soaMin: 10},
+	}, false)
+	c, clock := newTestResolver(t, dnsConfig{Server: dns.addr})
+	ctx := context.Background()
+
+	ips, err := c.lookup(ctx, "LAB.test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 2 || !ips[0].Equal(net.ParseIP("192.0.2.1")) || !ips[1].Equal(net.ParseIP("2001:db8::1")) {
+		t.Fatalf("ips = %v", ips)
+	}
+	if _, err := c.lookup(ctx, "lab.test"); err != nil {
+		t.Fatal(err)
+	}
+	if q := dns.udpQueries.Load(); q != 2 {
+		t.Errorf("queries after cached lookup = %d, want 2 (A + AAAA)", q)
+	}
+	clock.advance(31 * time.Second)
+	if _, err := c.lookup(ctx, "lab.test"); err != nil {
+		t.Fatal(err)
+	}
+	if q := dns.udpQueries.Load(); q != 4 {
+		t.Errorf("queries after TTL expiry = %d, want 4", q)
+	}
+
+	// 否定缓存时间取 SOA 的 TTL 与 minimum 中较小者（RFC 2308）
+	var dnsErr *net.DNSError
+	if _, err := c.lookup(ctx, "missing.test"); !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Fatalf("missing.test: err = %v", err)
+	}
+	if _, err := c.lookup(ctx, "missing.test"); err == nil {
+		t.Fatal("negative result was not cached")
+	}
+	if q := dns.udpQueries.Load(); q != 6 {
+		t.Errorf("queries after negative hit = %d, want 6", q)
+	}
+	clock.advance(11 * time.Second)
+	_, _ = c.lookup(ctx, "missing.test")
+	if q := dns.udpQueries.Load(); q != 8 {
+		t.Errorf("queries after negative TTL expiry = %d, want 8", q)
+	}
+
+	s := c.stats()
+	if s["hits"] != int64(1) || s["negativeHits"] != int64(1) || s["misses"] != int64(4) {
+		t.Errorf("stats = %v", s)
+	}
+}
+
+func TestResolverServerFailureNotCached(t *testing.T) {
+	dns := startFakeDNS(t, map[string]fakeRecord{"flaky.test": {servfail: true}}, false)
+	c, _ := newTestResolver(t, dnsConfig{Server: dns.addr})
+	for i := 0; i < 2; i++ {
+		if _, err := c.lookup(context.Background(), "flaky.test"); err == nil {
+			t.Fatal("expected error")
+		}
+	}
+	if q := dns.udpQueries.Load(); q != 4 {
+		t.Errorf("queries = %d, SERVFAIL must not be cached", q)
+	}
+	if c.failures.Load() != 2 {
+		t.Errorf("failures = %d", c.failures.Load())
+	}
+}
+
+func TestResolverTCP(t *testing.T) {
+	records := map[string]fakeRecord{"big.test": {v4: []string{"192.0.2.7"}, ttl: 60}}
+
+	// 截断的 UDP 应答改用 TCP 重试
+	truncating := startFakeDNS(t, records, true)
+	c, _ := newTestResolver(t, dnsConfig{Server: truncating.addr})
+	if ips, err := c.lookup(context.Background(), "big.test"); err != nil || !ips[0].Equal(net.ParseIP("192.0.2.7")) {
+		t.Fatalf("lookup after truncation: %v %v", ips, err)
+	}
+	if truncating.tcpQueries.Load() != 2 {
+		t.Errorf("tcp queries = %d, want 2", truncating.tcpQueries.Load())
+	}
+
+	// 配置为 TCP 时不使用 UDP
+	tcpOnly := startFakeDNS(t, records, false)
+	c, _ = newTestResolver(t, dnsConfig{Server: tcpOnly.addr, Protocol: "tcp"})
+	if _, err := c.lookup(context.Background(), "big.test"); err != nil {
+		t.Fatal(err)
+	}
+	if tcpOnly.udpQueries.Load() != 0 || tcpOnly.tcpQueries.Load() != 2 {
+		t.Errorf("udp %d tcp %d", tcpOnly.udpQueries.Load(), tcpOnly.tcpQueries.Load())
+	}
+}
+
+func TestResolverOverridesThroughProxy(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "mock "+r.Host)
+	}))
+	defer origin.Close()
+	_, port, _ := net.SplitHostPort(origin.Listener.Addr().String())
+
+	hostsFile := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(hostsFile, []byte("# lab mocks\n127.0.0.1 mock.lab.test mock2.lab.test\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// 上游服务器不可达：所有域名都必须来自覆盖表
+	resolver.configure(dnsConfig{
+		Server:    "127.0.0.1:1",
+		Overrides: map[string][]string{"www.hit.edu.cn": {"127.0.0.1"}},
+		HostsFile: hostsFile,
+	})
+	t.Cleanup(func() { resolver.configure(dnsConfig{}) })
+
+	for _, host := range []string{"www.hit.edu.cn", "mock2.lab.test"} {
+		r := httptest.NewRequest("GET", "http://"+host+":"+port+"/news", nil)
+		w := httptest.NewRecorder()
+		handleRequest(w, r)
+		if w.Code != http.StatusOK || w.Body.String() != "mock "+host+":"+port {
+			t.Errorf("%s: status %d body %q", host, w.Code, w.Body.String())
+		}
+	}
+	if resolver.overrideHits.Load() < 2 {
+		t.Errorf("overrideHits = %d", resolver.overrideHits.Load())
+	}
+	if _, ok := statsSnapshot()["dns"]; !ok {
+		t.Error("stats missing dns section")
+	}
+}
+
+// TestResolverDialTrace 连接源站时把 DNS 解析报告给请求的 httptrace
+func TestResolverDialTrace(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	c, _ := newTestResolver(t, dnsConfig{Overrides: map[string][]string{"traced.test": {"127.0.0.1"}}})
+
+	var started string
+	var done httptrace.DNSDoneInfo
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		DNSStart: func(info httptrace.DNSStartInfo) { started = info.Host },
+		DNSDone:  func(info httptrace.DNSDoneInfo) { done = info },
+	})
+	conn, err := c.dialContext(ctx, "tcp", net.JoinHostPort("traced.test", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+	if started != "traced.test" || done.Err != nil || len(done.Addrs) != 1 || !done.Addrs[0].IP.Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("DNSStart %q, DNSDone %+v", started, done)
+	}
+}
+
+func TestInterleaveFamilies(t *testing.T) {
+	var ips []net.IP
+	for _, s := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "2001:db8::1", "2001:db8::2"} {
+		ips = append(ips, net.ParseIP(s))
+	}
+	var got []string
+	for _, ip := range interleaveFamilies(ips) {
+		got = append(got, ip.String())
+	}
+	if want := "192.0.2.1 2001:db8::1 192.0.2.2 2001:db8::2 192.0.2.3"; strings.Join(got, " ") != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+}
+
+// TestRaceDial 不响应的地址不会让后面的地址等待整个连接超时，所有尝试共用一个超时
+func TestRaceDial(t *testing.T) {
+	// hang 直到被取消；refused 立即失败；ok 连通
+	var cancelled atomic.Int64
+	dial := func(ctx context.Context, addr string) (net.Conn, error) {
+		switch addr {
+		case "hang":
+			<-ctx.Done()
+			cancelled.Add(1)
+			return nil, ctx.Err()
+		case "refused":
+			return nil, errors.New("connection refused")
+		}
+		client, server := net.Pipe()
+		_ = server.Close()
+		return client, nil
+	}
+
+	start := time.Now()
+	conn, err := raceDial(context.Background(), []string{"hang", "refused", "ok"}, dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+	if d := time.Since(start); d > 2*dialAttemptDelay {
+		t.Errorf("connected after %v", d)
+	}
+	deadline := time.Now().Add(time.Second)
+	for cancelled.Load() != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if cancelled.Load() != 1 {
+		t.Error("losing attempt was not cancelled")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*dialAttemptDelay)
+	defer cancel()
+	start = time.Now()
+	if _, err := raceDial(ctx, []string{"hang", "hang", "hang", "hang", "hang"}, dial); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v", err)
+	}
+	if d := time.Since(start); d > 5*dialAttemptDelay {
+		t.Errorf("unreachable addresses took %v with a %v timeout", d, 3*dialAttemptDelay)
+	}
+}
+
+func TestParseDNSResponseMalformed(t *testing.T) {
+	query, err := buildDNSQuery(7, "lab.test", dnsTypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeDNSServer{records: map[string]fakeRecord{"lab.test": {v4: []string{"192.0.2.1"}, ttl: 5}}}
+	resp := s.respond(query, false)
+	if ans, err := parseDNSResponse(resp, 7); err != nil || len(ans.ips) != 1 || ans.ttl != 5*time.Second {
+		t.Fatalf("valid response: %+v %v", ans, err)
+	}
+	if _, err := parseDNSResponse(resp, 8); err == nil {
+		t.Error("ID mismatch accepted")
+	}
+	for n := 0; n < len(resp); n++ {
+		if _, err := parseDNSResponse(resp[:n], 7); err == nil {
+			t.Errorf("truncated response of %d bytes accepted", n)
+		}
+	}
+	if _, err := buildDNSQuery(1, strings.Repeat("a", 64)+".test", dnsTypeA); err == nil {
+		t.Error("label longer than 63 bytes accepted")
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata" // Windows 等缺少时区数据库的系统也能解析 IANA 时区
+)
+
+// scheduleWindow 规则生效的时间段，例如工作日上课时间
+type scheduleWindow struct {
+	Days     []string `json:"days"`     // "mon" 到 "sun"，为空时每天生效
+	Start    string   `json:"start"`    // 开始时间 "08:00"
+	End      string   `json:"end"`      // 结束时间 "12:00"，不大于 Start 时表示跨越午夜
+	Timezone string   `json:"timezone"` // IANA 时区，例如 "Asia/Shanghai"，为空时使用本地时区
+}
+
+// scheduleNow 当前时间，测试时可以替换
+var scheduleNow = time.Now
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func init() {
+	onPolicyReload(checkSchedules)
+}
+
+// scheduleActive 判断时间是否落在任一时间段内，没有配置时间段时规则始终生效
+func scheduleActive(windows []scheduleWindow, t time.Time) bool {
+	if len(windows) == 0 {
+		return true
+	}
+	for _, w := range windows {
+		if ok, err := w.contains(t); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// contains 判断时间是否落在时间段内。跨越午夜的时间段以开始的那一天判断星期
+func (w scheduleWindow) contains(t time.Time) (bool, error) {
+	loc, err := w.location()
+	if err != nil {
+		return false, err
+	}
+	start, err := parseClock(w.Start)
+	if err != nil {
+		return false, err
+	}
+	end, err := parseClock(w.End)
+	if err != nil {
+		return false, err
+	}
+	days, err := w.weekdays()
+	if err != nil {
+		return false, err
+	}
+
+	t = t.In(loc)
+	minute := t.Hour()*60 + t.Minute()
+	onDay := func(d time.Weekday) bool { return days == nil || days[d] }
+	if start < end {
+		return onDay(t.Weekday()) && minute >= start && minute < end, nil
+	}
+	yesterday := (t.Weekday() + 6) % 7
+	return (onDay(t.Weekday()) && minute >= start) || (onDay(yesterday) && minute < end), nil
+}
+
+func (w scheduleWindow) location() (*time.Location, error) {
+	if w.Timezone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(w.Timezone)
+}
+
+// weekdays 返回生效的星期集合，没有配置时返回 nil
+func (w scheduleWindow) weekdays() (map[time.Weekday]bool, error) {
+	if len(w.Days) == 0 {
+		return nil, nil
+	}
+	days := make(map[time.Weekday]bool)
+	for _, name := range w.Days {
+		// 同时接受 "mon" 和 "Monday" 两种写法
+		key := strings.ToLower(strings.TrimSpace(name))
+		if len(key) > 3 {
+			key = key[:3]
+		}
+		d, ok := weekdayNames[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown weekday %q", name)
+		}
+		days[d] = true
+	}
+	return days, nil
+}
+
+// parseClock 将 "HH:MM" 转换为当天的分钟数，"24:00" 表示当天结束
+func parseClock(s string) (int, error) {
+	if s == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, want HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// checkSchedules 策略加载后检查所有时间段，配置错误的时间段不会生效
+func checkSchedules(p *proxyPolicy) {
+	check := func(owner string, windows []scheduleWindow) {
+		for _, w := range windows {
+			if _, err := w.contains(time.Now()); err != nil {
+				fmt.Printf("Error in schedule of %s: %v\n", owner, err)
+			}
+		}
+	}
+	check("restrictHosts", p.RestrictSchedule)
+	for i, rule := range p.SiteRules {
+		check(fmt.Sprintf("site rule %d", i+1), rule.Schedule)
+	}
+	for i, rule := range p.CategoryRules {
+		check(fmt.Sprintf("category rule %d", i+1), rule.Schedule)
+	}
+	for _, cfg := range p.Blocklists {
+		check("blocklist "+cfg.Name, cfg.Schedule)
+	}
+}
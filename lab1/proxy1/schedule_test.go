@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestScheduleWindowContains(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	classHours := scheduleWindow{Days: []string{"mon", "tue", "wed", "thu", "Friday"}, Start: "08:00", End: "12:00", Timezone: "Asia/Shanghai"}
+	overnight := scheduleWindow{Days: []string{"fri"}, Start: "22:00", End: "06:00", Timezone: "Asia/Shanghai"}
+
+	tests := []struct {
+		name   string
+		window scheduleWindow
+		at     time.Time
+		want   bool
+	}{
+		{"monday in class", classHours, time.Date(2024, 9, 2, 9, 30, 0, 0, shanghai), true},
+		{"monday at start", classHours, time.Date(2024, 9, 2, 8, 0, 0, 0, shanghai), true},
+		{"monday at end", classHours, time.Date(2024, 9, 2, 12, 0, 0, 0, shanghai), false},
+		{"saturday", classHours, time.Date(2024, 9, 7, 9, 30, 0, 0, shanghai), false},
+		{"friday spelled out", classHours, time.Date(2024, 9, 6, 11, 59, 0, 0, shanghai), true},
+		// 01:30 UTC 即上海时间 09:30
+		{"other timezone", classHours, time.Date(2024, 9, 2, 1, 30, 0, 0, time.UTC), true},
+		{"overnight start day", overnight, time.Date(2024, 9, 6, 23, 0, 0, 0, shanghai), true},
+		{"overnight next morning", overnight, time.Date(2024, 9, 7, 5, 59, 0, 0, shanghai), true},
+		{"overnight wrong day", overnight, time.Date(2024, 9, 5, 23, 0, 0, 0, shanghai), false},
+		{"overnight after end", overnight, time.Date(2024, 9, 7, 6, 0, 0, 0, shanghai), false},
+	}
+	for _, tt := range tests {
+		got, err := tt.window.contains(tt.at)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: contains = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	for _, bad := range []scheduleWindow{
+		{Start: "8am", End: "12:00"},
+		{Start: "08:00", End: "12:00", Timezone: "Mars/Olympus"},
+		{Days: []string{"someday"}, Start: "08:00", End: "12:00"},
+	} {
+		if _, err := bad.contains(time.Now()); err == nil {
+			t.Errorf("%+v: expected error", bad)
+		}
+		if scheduleActive([]scheduleWindow{bad}, time.Now()) {
+			t.Errorf("%+v: invalid window should never be active", bad)
+		}
+	}
+	if !scheduleActive(nil, time.Now()) {
+		t.Error("rules without a schedule should always be active")
+	}
+}
+
+func TestScheduledSiteRule(t *testing.T) {
+	p := defaultPolicy()
+	p.ForbidSites = true
+	p.SiteRules = []siteRule{{
+		ID:       "games-in-class",
+		Match:    "games.example",
+		Category: "entertainment",
+		Schedule: []scheduleWindow{{Days: []string{"mon"}, Start: "08:00", End: "12:00", Timezone: "UTC"}},
+	}}
+	withPolicy(t, p)
+
+	old := scheduleNow
+	t.Cleanup(func() { scheduleNow = old })
+
+	scheduleNow = func() time.Time { return time.Date(2024, 9, 2, 9, 0, 0, 0, time.UTC) }
+	if _, blocked := matchInvalidWebsite(p, "http://games.example/"); !blocked {
+		t.Error("rule should block during class hours")
+	}
+	scheduleNow = func() time.Time { return time.Date(2024, 9, 2, 13, 0, 0, 0, time.UTC) }
+	if _, blocked := matchInvalidWebsite(p, "http://games.example/"); blocked {
+		t.Error("rule should not block outside class hours")
+	}
+
+	// 用户过滤同样只在 restrictSchedule 内生效
+	p.ForbidHosts = true
+	p.RestrictHosts = []string{"192.0.2.50"}
+	p.RestrictSchedule = []scheduleWindow{{Start: "00:00", End: "01:00", Timezone: "UTC"}}
+	r := httptest.NewRequest("GET", "http://games.example/", nil)
+	r.RemoteAddr = "192.0.2.50:1000"
+	w := httptest.NewRecorder()
+	scheduleNow = func() time.Time { return time.Date(2024, 9, 2, 0, 30, 0, 0, time.UTC) }
+	handleRequest(w, r)
+	if w.Code != 403 || w.Header().Get("X-Proxy-Block-Rule") != "restrict-hosts" {
+		t.Errorf("restricted host inside schedule: status %d rule %q", w.Code, w.Header().Get("X-Proxy-Block-Rule"))
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// proxyStats 代理运行统计，所有字段使用原子操作更新
+type proxyStats struct {
+	Requests           atomic.Int64
+	CacheRevalidated   atomic.Int64
+	OriginBytes        atomic.Int64 // 从源站收到的响应体字节数（线上编码）
+	ClientBytes        atomic.Int64 // 发送给客户端的响应体字节数
+	IdentityBytes      atomic.Int64 // 发送给客户端的响应体解压后的字节数
+	CompressedServed   atomic.Int64 // 以压缩形式发送的响应数
+	DecompressedServed atomic.Int64 // 为不支持压缩的客户端解压的响应数
+	CacheStoredBytes   atomic.Int64 // 缓存条目压缩后的字节数（去重之前）
+	CacheIdentBytes    atomic.Int64 // 缓存内容解压后的字节数
+}
+
+var (
+	stats     proxyStats
+	startTime = time.Now()
+)
+
+func init() {
+	localMux.HandleFunc("/stats", serveStats)
+}
+
+// statsSnapshot 返回统计数据的快照，键名即 JSON 字段名
+func statsSnapshot() map[string]any {
+	return map[string]any{
+		"uptimeSeconds":       int64(time.Since(startTime).Seconds()),
+		"requests":            stats.Requests.Load(),
+		"cacheRevalidated":    stats.CacheRevalidated.Load(),
+		"originBytes":         stats.OriginBytes.Load(),
+		"clientBytes":         stats.ClientBytes.Load(),
+		"compressedServed":    stats.CompressedServed.Load(),
+		"decompressedServed":  stats.DecompressedServed.Load(),
+		"bandwidthSavedBytes": stats.IdentityBytes.Load() - stats.ClientBytes.Load(),
+		"cacheStoredBytes":    stats.CacheStoredBytes.Load(),
+		"cacheSavedBytes":     stats.CacheIdentBytes.Load() - stats.CacheStoredBytes.Load(),
+		"blocklists":          blocklistStats(),
+		"dedup":               blobs.stats(),
+		"dns":                 resolver.stats(),
+		"icap":                icapStatsSnapshot(),
+	}
+}
+
+// serveStats 以 JSON 格式返回统计数据
+func serveStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(statsSnapshot()); err != nil {
+		http.Error(w, "Error encoding stats", http.StatusInternalServerError)
+	}
+}
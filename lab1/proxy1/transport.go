@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// upstreamTransport 访问源站的 Transport：通过 TLS 访问的源站支持时使用 HTTP/2，否则使用 HTTP/1.1
+var upstreamTransport = newUpstreamTransport()
+
+func newUpstreamTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ForceAttemptHTTP2 = true
+	t.MaxIdleConnsPerHost = 32
+	t.DialContext = resolver.dialContext // 经带缓存和覆盖表的解析器连接源站
+	t.Protocols = new(http.Protocols)
+	t.Protocols.SetHTTP1(true)
+	t.Protocols.SetHTTP2(true)
+	return t
+}
+
+// hopByHopHeaders 只对单个连接有意义、代理不得转发的头部（RFC 9110 7.6.1）。
+// HTTP/2 禁止出现这些头部，经 h2c 转发时必须去除
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopByHopHeaders 删除逐跳头部以及 Connection 中列出的头部
+func removeHopByHopHeaders(header http.Header) {
+	for _, v := range header.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				header.Del(name)
+			}
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+}
+
+// resolveProxyTarget 补全转发目标。HTTP/1.1 代理请求使用绝对 URL；
+// HTTP/2（h2c）请求只有 :authority 和 :path，当 :authority 不是代理自身时将其作为源站，
+// 端口为 443 时使用 https，否则使用 http。返回 false 表示请求是发给代理本身的
+func resolveProxyTarget(r *http.Request) bool {
+	if r.URL.Host != "" || r.Method == http.MethodConnect {
+		return true
+	}
+	if r.ProtoMajor != 2 || r.Host == "" || isProxySelf(r) {
+		return false
+	}
+	r.URL.Host = r.Host
+	r.URL.Scheme = "http"
+	if _, port, err := net.SplitHostPort(r.Host); err == nil && port == "443" {
+		r.URL.Scheme = "https"
+	}
+	return true
+}
+
+// isProxySelf 判断 Host 是否指向代理自身监听的地址
+func isProxySelf(r *http.Request) bool {
+	local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if !ok {
+		return true
+	}
+	localHost, localPort, err := net.SplitHostPort(local.String())
+	if err != nil {
+		return true
+	}
+	host, port, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host, port = r.Host, "80"
+	}
+	if port != localPort {
+		return false
+	}
+	host = strings.Trim(host, "[]")
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.IsLoopback() || ip.IsUnspecified() || ip.Equal(net.ParseIP(localHost)) || isLocalInterfaceIP(ip)
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	name, err := os.Hostname()
+	return err == nil && strings.EqualFold(host, name)
+}
+
+// isLocalInterfaceIP 判断 IP 是否属于本机的某个网络接口
+func isLocalInterfaceIP(ip net.IP) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestResolveProxyTarget(t *testing.T) {
+	local := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8080}
+	tests := []struct {
+		name       string
+		target     string
+		host       string
+		protoMajor int
+		wantProxy  bool
+		wantURL    string
+	}{
+		{"http1 absolute form", "http://example.com/a", "example.com", 1, true, "http://example.com/a"},
+		{"http1 origin form", "/proxy.pac", "127.0.0.1:8080", 1, false, "/proxy.pac"},
+		{"h2c to origin", "/a?b=1", "example.com", 2, true, "http://example.com/a?b=1"},
+		{"h2c to origin on 443", "/a", "example.com:443", 2, true, "https://example.com:443/a"},
+		{"h2c to proxy itself", "/stats", "127.0.0.1:8080", 2, false, "/stats"},
+		{"h2c to localhost", "/stats", "localhost:8080", 2, false, "/stats"},
+		{"h2c to local ip on another port", "/x", "127.0.0.1:9000", 2, true, "http://127.0.0.1:9000/x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.target, nil)
+			r.Host = tt.host
+			r.ProtoMajor = tt.protoMajor
+			r = r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, net.Addr(local)))
+			if got := resolveProxyTarget(r); got != tt.wantProxy {
+				t.Fatalf("resolveProxyTarget = %v, want %v", got, tt.wantProxy)
+			}
+			if got := r.URL.String(); got != tt.wantURL {
+				t.Errorf("URL = %q, want %q", got, tt.wantURL)
+			}
+		})
+	}
+}
+
+func TestRemoveHopByHopHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("Connection", "keep-alive, X-Private")
+	h.Set("Keep-Alive", "timeout=5")
+	h.Set("X-Private", "1")
+	h.Set("Proxy-Connection", "keep-alive")
+	h.Set("Content-Type", "text/plain")
+	removeHopByHopHeaders(h)
+	if len(h) != 1 || h.Get("Content-Type") == "" {
+		t.Errorf("headers after removal: %v", h)
+	}
+}
+
+// startProxyServer 启动与 main 相同配置（HTTP/1.1 + h2c）的代理
+func startProxyServer(t testing.TB) *httptest.Server {
+	t.Helper()
+	proxy := httptest.NewUnstartedServer(http.HandlerFunc(handleRequest))
+	proxy.Config = newProxyServer("", http.HandlerFunc(handleRequest))
+	proxy.Start()
+	t.Cleanup(proxy.Close)
+	return proxy
+}
+
+// startSmallObjectOrigin 返回大量小对象的源站
+func startSmallObjectOrigin(t testing.TB) *httptest.Server {
+	t.Helper()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Connection", "keep-alive")
+		_, _ = io.WriteString(w, "object "+r.URL.Path)
+	}))
+	t.Cleanup(origin.Close)
+	return origin
+}
+
+// proxyClient 返回经代理访问源站的客户端：HTTP/1.1 使用绝对 URL，h2c 使用 prior knowledge 并在一条连接上复用
+func proxyClient(proxy *httptest.Server, h2c bool) *http.Client {
+	proxyURL, _ := url.Parse(proxy.URL)
+	transport := &http.Transport{MaxIdleConnsPerHost: 64}
+	if h2c {
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+		transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, proxyURL.Host)
+		}
+	} else {
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	return &http.Client{Transport: transport}
+}
+
+func fetchThroughProxy(client *http.Client, target string, wantProto int) error {
+	resp, err := client.Get(target)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: status %d", target, resp.StatusCode)
+	}
+	if resp.ProtoMajor != wantProto {
+		return fmt.Errorf("%s: proto %s", target, resp.Proto)
+	}
+	if want := "object " + strings.TrimPrefix(target, "http://"+hostOf(target)); string(body) != want {
+		return fmt.Errorf("%s: body %q, want %q", target, body, want)
+	}
+	return nil
+}
+
+func hostOf(target string) string {
+	u, _ := url.Parse(target)
+	return u.Host
+}
+
+func TestH2CMultiplexedStreams(t *testing.T) {
+	origin := startSmallObjectOrigin(t)
+	proxy := startProxyServer(t)
+	client := proxyClient(proxy, true)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// 一半的请求指向同一对象，同时经过缓存
+			errs <- fetchThroughProxy(client, fmt.Sprintf("%s/obj/%d", origin.URL, i%50), 2)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	// 代理自身的接口同样可以通过 h2c 访问
+	resp, err := client.Get(proxy.URL + "/stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ProtoMajor != 2 {
+		t.Errorf("/stats over h2c: %s %d", resp.Proto, resp.StatusCode)
+	}
+}
+
+// TestHTTP2ToTLSOrigin 支持 HTTP/2 的 TLS 源站经代理访问时使用 HTTP/2，客户端到代理仍是 HTTP/1.1
+func TestHTTP2ToTLSOrigin(t *testing.T) {
+	protos := make(chan string, 2)
+	origin := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protos <- r.Proto
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, "object "+r.URL.Path)
+	}))
+	origin.EnableHTTP2 = true
+	origin.StartTLS()
+	defer origin.Close()
+
+	// 代理信任测试源站的证书
+	saved := upstreamTransport
+	upstreamTransport = newUpstreamTransport()
+	upstreamTransport.TLSClientConfig = origin.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	defer func() {
+		upstreamTransport.CloseIdleConnections()
+		upstreamTransport = saved
+	}()
+
+	r := httptest.NewRequest("GET", origin.URL+"/h2", nil)
+	w := httptest.NewRecorder()
+	handleRequest(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "object /h2" {
+		t.Fatalf("status %d body %q", w.Code, w.Body.String())
+	}
+	if proto := <-protos; proto != "HTTP/2.0" {
+		t.Errorf("origin received %s, want HTTP/2.0", proto)
+	}
+}
+
+// silenceStdout 丢弃代理的调试输出，避免其影响基准测试结果。
+// 必须在启动服务器之前调用，恢复操作在服务器关闭之后执行
+func silenceStdout(tb testing.TB) {
+	tb.Helper()
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = devNull
+	tb.Cleanup(func() {
+		os.Stdout = stdout
+		_ = devNull.Close()
+	})
+}
+
+// BenchmarkSmallObjects 比较客户端经 HTTP/1.1 和 h2c 访问代理时获取大量小对象的延迟
+func BenchmarkSmallObjects(b *testing.B) {
+	silenceStdout(b)
+	origin := startSmallObjectOrigin(b)
+	proxy := startProxyServer(b)
+
+	for _, c := range []struct {
+		name  string
+		h2c   bool
+		proto int
+	}{
+		{"HTTP1", false, 1},
+		{"H2C", true, 2},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			client := proxyClient(proxy, c.h2c)
+			b.SetParallelism(8)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					i++
+					if err := fetchThroughProxy(client, fmt.Sprintf("%s/small/%d", origin.URL, i%64), c.proto); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package funClient
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"rdt"
+	"time"
+)
+
+const (
+	duplexPackets = 10              // 每个方向发送的消息数
+	duplexTimeout = 2 * time.Second // 重传超时
+)
+
+// lossyPacketConn 按 SimulatePacketLoss 丢弃收到的数据报，丢包对数据和确认同样生效
+type lossyPacketConn struct {
+	net.PacketConn
+}
+
+func (l lossyPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := l.PacketConn.ReadFrom(b)
+		if err != nil || !SimulatePacketLoss() {
+			return n, addr, err
+		}
+		fmt.Printf("Packet loss: %d bytes from %v\n", n, addr)
+	}
+}
+
+// RunDuplexClient 使用 rdt 库与服务器双向传输，useSR 选择 SR 或 GBN
+func RunDuplexClient(conn *net.UDPConn, serverAddr *net.UDPAddr, useSR bool) {
+	cfg := &rdt.Config{Protocol: rdt.GBN, Timeout: duplexTimeout}
+	if useSR {
+		cfg.Protocol = rdt.SR
+	}
+	c, err := rdt.DialPacket(lossyPacketConn{conn}, serverAddr, cfg)
+	if err != nil {
+		fmt.Printf("Error connecting to %v: %v\n", serverAddr, err)
+		return
+	}
+	fmt.Printf("Connected to %v using %v\n", serverAddr, c.Protocol())
+
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		for i := 0; i < duplexPackets; i++ {
+			msg := fmt.Sprintf("Client packet %d", i)
+			if _, err := fmt.Fprintln(c, msg); err != nil {
+				fmt.Printf("Error sending %q: %v\n", msg, err)
+				return
+			}
+			fmt.Printf("Sending: %q\n", msg)
+		}
+		if err := c.CloseWrite(); err != nil {
+			fmt.Printf("Error closing write side: %v\n", err)
+		}
+	}()
+
+	scanner := bufio.NewScanner(c)
+	for scanner.Scan() {
+		fmt.Printf("Received: %q\n", scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Printf("Error receiving: %v\n", err)
+	}
+	<-sent
+	if err := c.Close(); err != nil {
+		fmt.Printf("Error closing connection: %v\n", err)
+	}
+	<-c.Done() // 等待对方确认结束，以便重新确认对方重传的 FIN
+	s := c.Stats()
+	fmt.Printf("Done: %d packets sent (%d retransmitted), %d separate ACKs, %d ACKs piggybacked, %d corrupt packets dropped\n",
+		s.PacketsSent, s.Retransmissions, s.AcksSent, s.PiggybackedAcks, s.CorruptPackets)
+}
@@ -4,14 +4,23 @@ import (
 	"fmt"
 	"math/rand"
 	"net"
+	"rdt"
 	"time"
 )
 
 const (
 	packetLossProb = 0.2 // 丢包概率
-	bufferSize     = 1024
+	bufferSize     = rdt.HeaderSize + rdt.MaxPayloadSize
+	windowSize     = 4 // 在 ACK 中通告的接收窗口
+	seqBits        = 3 // 序列号位数，序列号在 0..7 之间循环使用，需要与服务器一致
 )
 
+// seqSpace 报文使用的序列号空间
+var seqSpace, _ = rdt.NewSeqSpace(seqBits) // seqBits 是合法的常量，不会出错
+
+// CorruptPackets 校验失败而被丢弃的报文数
+var CorruptPackets int
+
 // RandomGenerator 是一个自定义的随机数生成器
 var RandomGenerator *rand.Rand
 
@@ -40,27 +49,45 @@ func InitUDPConnection(port string) (*net.UDPConn, *net.UDPAddr, error) {
 	return conn, clientAddr, nil
 }
 
-// ReceivePacket 接收数据包
-func ReceivePacket(conn *net.UDPConn) (int, string, error) {
+// ReceivePacket 接收数据包，损坏的报文被丢弃并计入 CorruptPackets
+func ReceivePacket(conn *net.UDPConn) (int, []byte, error) {
 	buffer := make([]byte, bufferSize)
 	n, _, err := conn.ReadFromUDP(buffer)
 	if err != nil {
-		return -1, "", fmt.Errorf("failed to read from UDP: %v", err)
+		return -1, nil, fmt.Errorf("failed to read from UDP: %v", err)
+	}
+	return decodeData(buffer[:n])
+}
+
+// decodeData 解析 DATA 报文
+func decodeData(b []byte) (int, []byte, error) {
+	packet, err := rdt.Decode(b)
+	if rdt.IsCorrupt(err) {
+		CorruptPackets++
+		return -1, nil, fmt.Errorf("dropping corrupt packet (%d so far): %v", CorruptPackets, err)
+	}
+	if err != nil {
+		return -1, nil, fmt.Errorf("failed to parse packet: %v", err)
+	}
+	if packet.Type != rdt.TypeData {
+		return -1, nil, fmt.Errorf("unexpected %v packet", packet.Type)
 	}
-	var seqNum int
-	var data string
+	return int(packet.Seq), packet.Payload, nil
+}
 
-	if _, err := fmt.Sscanf(string(buffer[:n]), "%d:%s", &seqNum, &data); err != nil {
-		fmt.Printf("failed to parse packet: %v\n", err)
-		return -1, "", fmt.Errorf("failed to parse packet: %v", err)
+// sendAckPacket 发送确认 seqNum 的 ACK 报文
+func sendAckPacket(conn *net.UDPConn, serverAddr *net.UDPAddr, seqNum int) error {
+	msg, err := rdt.Encode(rdt.Packet{Type: rdt.TypeAck, Ack: uint32(seqNum), Window: windowSize})
+	if err != nil {
+		return err
 	}
-	return seqNum, data, nil
+	_, err = conn.WriteToUDP(msg, serverAddr)
+	return err
 }
 
 // SendAck 发送ACK
 func SendAck(conn *net.UDPConn, serverAddr *net.UDPAddr, seqNum int) error {
-	ackMsg := fmt.Sprintf("ACK:%d", seqNum)
-	_, err := conn.WriteToUDP([]byte(ackMsg), serverAddr)
+	err := sendAckPacket(conn, serverAddr, seqNum)
 	if err != nil {
 		return fmt.Errorf("failed to send ACK: %v", err)
 	}
@@ -71,6 +98,7 @@ func SendAck(conn *net.UDPConn, serverAddr *net.UDPAddr, seqNum int) error {
 // RunServer 主循环，处理数据包接收和ACK发送
 func RunServer(conn *net.UDPConn, serverAddr *net.UDPAddr) {
 	expectedSeqNum := 0
+	received := false // 是否已经按序收到过数据包
 	for {
 		seqNum, data, err := ReceivePacket(conn)
 		if err != nil {
@@ -84,17 +112,18 @@ func RunServer(conn *net.UDPConn, serverAddr *net.UDPAddr) {
 			continue
 		}
 
-		fmt.Printf("Received packet: SeqNum=%d, Data=%s\n", seqNum, data)
+		fmt.Printf("Received packet: SeqNum=%d, Data=%q\n", seqNum, data)
 
 		// 正常处理数据包并发送ACK
 		if seqNum == expectedSeqNum {
 			if err := SendAck(conn, serverAddr, seqNum); err != nil {
 				fmt.Println(err)
 			}
-			expectedSeqNum++
-		} else {
-			// 乱序数据包，重发最后一个ACK
-			if err := SendAck(conn, serverAddr, expectedSeqNum-1); err != nil {
+			expectedSeqNum = int(seqSpace.Next(uint32(expectedSeqNum)))
+			received = true
+		} else if received {
+			// 乱序数据包，重发最后一个ACK（还没有收到任何数据包时没有可以确认的序列号）
+			if err := SendAck(conn, serverAddr, int(seqSpace.Add(uint32(expectedSeqNum), -1))); err != nil {
 				fmt.Println(err)
 			}
 		}
@@ -9,9 +9,8 @@ import (
 
 const (
 	srPacketLossProb = 0.2 // SR协议的丢包概率
-	srBufferSize     = 1024
-	srWindowSize     = 4 // SR 接收窗口大小
-	srMaxSeqNum      = 8 // 最大序列号，假设为 0-7 的序列号循环使用
+	srBufferSize     = bufferSize
+	srWindowSize     = 4 // SR 接收窗口大小，不能超过序列号空间的一半
 )
 
 // SRRandomGenerator 是一个自定义的随机数生成器（针对SR协议）
@@ -42,27 +41,19 @@ func InitSRUDPConnection(port string) (*net.UDPConn, *net.UDPAddr, error) {
 	return conn, clientAddr, nil
 }
 
-// ReceiveSRPacket 接收 SR 协议的数据包
-func ReceiveSRPacket(conn *net.UDPConn) (int, string, error) {
+// ReceiveSRPacket 接收 SR 协议的数据包，损坏的报文被丢弃并计入 CorruptPackets
+func ReceiveSRPacket(conn *net.UDPConn) (int, []byte, error) {
 	buffer := make([]byte, srBufferSize)
 	n, _, err := conn.ReadFromUDP(buffer)
 	if err != nil {
-		return -1, "", fmt.Errorf("failed to read from SR UDP: %v", err)
+		return -1, nil, fmt.Errorf("failed to read from SR UDP: %v", err)
 	}
-	var seqNum int
-	var data string
-
-	if _, err := fmt.Sscanf(string(buffer[:n]), "%d:%s", &seqNum, &data); err != nil {
-		fmt.Printf("failed to parse SR packet: %v\n", err)
-		return -1, "", fmt.Errorf("failed to parse SR packet: %v", err)
-	}
-	return seqNum, data, nil
+	return decodeData(buffer[:n])
 }
 
 // SendSRAck 发送 SR 协议的 ACK
 func SendSRAck(conn *net.UDPConn, serverAddr *net.UDPAddr, seqNum int) error {
-	ackMsg := fmt.Sprintf("SR_ACK:%d", seqNum)
-	_, err := conn.WriteToUDP([]byte(ackMsg), serverAddr)
+	err := sendAckPacket(conn, serverAddr, seqNum)
 	if err != nil {
 		return fmt.Errorf("failed to send SR ACK: %v", err)
 	}
@@ -73,7 +64,7 @@ func SendSRAck(conn *net.UDPConn, serverAddr *net.UDPAddr, seqNum int) error {
 // RunSRClient 主循环，处理 SR 协议数据包接收和 ACK 发送
 func RunSRClient(conn *net.UDPConn, serverAddr *net.UDPAddr) {
 	expectedSeqNum := 0            // 期望的序列号
-	window := make(map[int]string) // 接收窗口，缓存乱序到达的数据包
+	window := make(map[int][]byte) // 接收窗口，缓存乱序到达的数据包
 	for {
 		seqNum, data, err := ReceiveSRPacket(conn)
 		if err != nil {
@@ -87,19 +78,19 @@ func RunSRClient(conn *net.UDPConn, serverAddr *net.UDPAddr) {
 			continue
 		}
 
-		fmt.Printf("Received SR packet: SeqNum=%d, Data=%s\n", seqNum, data)
+		fmt.Printf("Received SR packet: SeqNum=%d, Data=%q\n", seqNum, data)
 
 		// 正常处理数据包
-		if seqNum >= expectedSeqNum && seqNum < expectedSeqNum+srWindowSize {
+		if seqSpace.InWindow(uint32(seqNum), uint32(expectedSeqNum), srWindowSize) {
 			// 如果是期望的包，处理并发送 ACK
 			window[seqNum] = data
 			if seqNum == expectedSeqNum {
 				// 顺序交付数据包给应用层，并发送 ACK
 				for {
 					if packetData, ok := window[expectedSeqNum]; ok {
-						fmt.Printf("Delivering to application: SR SeqNum=%d, Data=%s\n", expectedSeqNum, packetData)
+						fmt.Printf("Delivering to application: SR SeqNum=%d, Data=%q\n", expectedSeqNum, packetData)
 						delete(window, expectedSeqNum)
-						expectedSeqNum = (expectedSeqNum + 1) % srMaxSeqNum
+						expectedSeqNum = int(seqSpace.Next(uint32(expectedSeqNum)))
 					} else {
 						break
 					}
@@ -108,8 +99,8 @@ func RunSRClient(conn *net.UDPConn, serverAddr *net.UDPAddr) {
 			if err := SendSRAck(conn, serverAddr, seqNum); err != nil {
 				fmt.Println(err)
 			}
-		} else if seqNum < expectedSeqNum {
-			// 如果收到的包已经被接收并确认过，发送 ACK 重复确认
+		} else if seqSpace.InWindow(uint32(seqNum), seqSpace.Add(uint32(expectedSeqNum), -srWindowSize), srWindowSize) {
+			// 如果收到的包属于上一个窗口，已经被接收并确认过，发送 ACK 重复确认
 			if err := SendSRAck(conn, serverAddr, seqNum); err != nil {
 				fmt.Println(err)
 			}
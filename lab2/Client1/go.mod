@@ -1,3 +1,7 @@
 module Client1
 
 go 1.23.2
+
+require rdt v0.0.0
+
+replace rdt => ../rdt
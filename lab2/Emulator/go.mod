@@ -0,0 +1,7 @@
+module Emulator
+
+go 1.23.2
+
+require rdt v0.0.0
+
+replace rdt => ../rdt
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"rdt/netem"
+	"time"
+)
+
+func main() {
+	listen := flag.String("listen", ":9000", "客户端发送到的本地地址")
+	target := flag.String("target", "127.0.0.1:8080", "服务器地址")
+	both := flag.String("impair", "", "两个方向的损伤，如 loss=0.1,delay=20ms,jitter=5ms,rate=1mbit,reorder=0.05,dup=0.01,corrupt=0.01,ge=0.05/0.3")
+	up := flag.String("up", "", "客户端到服务器方向的损伤，指定时代替 -impair")
+	down := flag.String("down", "", "服务器到客户端方向的损伤，指定时代替 -impair")
+	seed := flag.Int64("seed", 0, "随机数种子，0 表示使用当前时间")
+	verbose := flag.Bool("v", false, "输出每个被损伤的报文")
+	flag.Parse()
+	if flag.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected arguments: %q\n", flag.Args())
+		os.Exit(2)
+	}
+
+	var opts netem.Options
+	for _, dir := range []struct {
+		name string
+		spec string
+		imp  *netem.Impairment
+	}{{"up", *up, &opts.Up}, {"down", *down, &opts.Down}} {
+		spec := dir.spec
+		if spec == "" {
+			spec = *both
+		}
+		imp, err := netem.ParseImpairment(spec)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "-%s: %v\n", dir.name, err)
+			os.Exit(2)
+		}
+		*dir.imp = imp
+	}
+	opts.Seed = *seed
+	if opts.Seed == 0 {
+		opts.Seed = time.Now().UnixNano()
+	}
+	if *verbose {
+		opts.Logf = func(format string, args ...any) {
+			fmt.Fprintf(os.Stderr, format+"\n", args...)
+		}
+	}
+
+	p, err := netem.Listen(*listen, *target, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Forwarding %v -> %s (seed %d)\n  up:   %v\n  down: %v\n", p.Addr(), *target, opts.Seed, opts.Up, opts.Down)
+
+	served := make(chan error, 1)
+	go func() { served <- p.Serve() }()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	select {
+	case <-sig:
+	case err := <-served:
+		fmt.Fprintln(os.Stderr, err)
+	}
+	_ = p.Close()
+
+	upStats, downStats := p.Stats()
+	for _, s := range []struct {
+		name string
+		s    netem.Stats
+	}{{"up", upStats}, {"down", downStats}} {
+		fmt.Fprintf(os.Stderr, "%-4s %d received, %d delivered, %d lost, %d queue drops, %d corrupted, %d duplicated, %d reordered\n",
+			s.name+":", s.s.Received, s.s.Delivered, s.s.Lost, s.s.QueueDrops, s.s.Corrupted, s.s.Duplicated, s.s.Reordered)
+	}
+}
@@ -0,0 +1,68 @@
+package funServer
+
+import (
+	"bufio"
+	"fmt"
+	"rdt"
+)
+
+// StartDuplexServer 使用 rdt 库双向传输：服务器和客户端在同一个 UDP socket 上同时发送和接收，
+// 确认由数据报文捎带，没有反向数据时由延迟确认计时器单独发送。协议由客户端在握手时选择
+func StartDuplexServer() {
+	fmt.Println("Starting duplex server")
+
+	listener, err := rdt.Listen("udp", ":8080", &rdt.Config{Timeout: Timeout})
+	if err != nil {
+		fmt.Printf("Error listening: %v\n", err)
+		return
+	}
+	defer func() {
+		if err := listener.Close(); err != nil {
+			fmt.Printf("Error closing listener: %v\n", err)
+		}
+	}()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		fmt.Printf("Error accepting connection: %v\n", err)
+		return
+	}
+	c := conn.(*rdt.Conn)
+	fmt.Printf("Accepted %v connection from %v\n", c.Protocol(), c.RemoteAddr())
+	runDuplex(c, "Server")
+}
+
+// runDuplex 一个 goroutine 发送 TotalPackets 条消息后关闭发送方向，同时读取对方的消息直到对方关闭
+func runDuplex(c *rdt.Conn, name string) {
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		for i := 0; i < TotalPackets; i++ {
+			msg := fmt.Sprintf("%s packet %d", name, i)
+			if _, err := fmt.Fprintln(c, msg); err != nil {
+				fmt.Printf("Error sending %q: %v\n", msg, err)
+				return
+			}
+			fmt.Printf("Sending: %q\n", msg)
+		}
+		if err := c.CloseWrite(); err != nil {
+			fmt.Printf("Error closing write side: %v\n", err)
+		}
+	}()
+
+	scanner := bufio.NewScanner(c)
+	for scanner.Scan() {
+		fmt.Printf("Received: %q\n", scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Printf("Error receiving: %v\n", err)
+	}
+	<-sent
+	if err := c.Close(); err != nil {
+		fmt.Printf("Error closing connection: %v\n", err)
+	}
+	<-c.Done() // 等待对方确认结束，以便重新确认对方重传的 FIN
+	s := c.Stats()
+	fmt.Printf("Done: %d packets sent (%d retransmitted), %d separate ACKs, %d ACKs piggybacked, %d corrupt packets dropped\n",
+		s.PacketsSent, s.Retransmissions, s.AcksSent, s.PiggybackedAcks, s.CorruptPackets)
+}
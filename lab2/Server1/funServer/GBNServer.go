@@ -3,6 +3,7 @@ package funServer
 import (
 	"fmt"
 	"net"
+	"rdt"
 	"time"
 )
 
@@ -10,21 +11,50 @@ const (
 	WindowSize   = 4
 	TotalPackets = 10
 	Timeout      = 2 * time.Second
+	// SeqBits 序列号位数，序列号在 0..2^SeqBits-1 之间循环使用，需要与客户端一致。
+	// GBN 要求 WindowSize ≤ 2^SeqBits-1，SR 要求 SRWindowSize ≤ 2^(SeqBits-1)
+	SeqBits = 3
 )
 
+// seqSpace 报文使用的序列号空间
+var seqSpace, _ = rdt.NewSeqSpace(SeqBits) // SeqBits 是合法的常量，不会出错
+
 type Packet struct {
 	SeqNum int
-	Data   string
+	Data   []byte
 }
 
+// CorruptPackets 校验失败而被丢弃的报文数
+var CorruptPackets int
+
 func SendPacket(conn *net.UDPConn, addr *net.UDPAddr, packet Packet) {
-	fmt.Printf("Sending packet: SeqNum=%d, Data=%s\n", packet.SeqNum, packet.Data)
-	msg := fmt.Sprintf("%d:%s", packet.SeqNum, packet.Data)
-	if _, err := conn.WriteToUDP([]byte(msg), addr); err != nil {
+	fmt.Printf("Sending packet: SeqNum=%d, Data=%q\n", packet.SeqNum, packet.Data)
+	msg, err := rdt.Encode(rdt.Packet{Type: rdt.TypeData, Seq: uint32(packet.SeqNum), Window: WindowSize, Payload: packet.Data})
+	if err != nil {
+		fmt.Printf("Error encoding packet: SeqNum=%d, Error=%v\n", packet.SeqNum, err)
+		return
+	}
+	if _, err := conn.WriteToUDP(msg, addr); err != nil {
 		fmt.Printf("Error sending packet: SeqNum=%d, Error=%v\n", packet.SeqNum, err)
 	}
 }
 
+// decodeAck 解析 ACK 报文，损坏的报文计入 CorruptPackets
+func decodeAck(b []byte) (int, error) {
+	packet, err := rdt.Decode(b)
+	if rdt.IsCorrupt(err) {
+		CorruptPackets++
+		return -1, fmt.Errorf("dropping corrupt packet (%d so far): %v", CorruptPackets, err)
+	}
+	if err != nil {
+		return -1, err
+	}
+	if packet.Type != rdt.TypeAck {
+		return -1, fmt.Errorf("unexpected %v packet", packet.Type)
+	}
+	return int(packet.Ack), nil
+}
+
 func ReceiveAck(conn *net.UDPConn) int {
 	buffer := make([]byte, 1024)
 	if err := conn.SetReadDeadline(time.Now().Add(Timeout)); err != nil {
@@ -36,8 +66,8 @@ func ReceiveAck(conn *net.UDPConn) int {
 		fmt.Printf("Error receiving ack: Error=%v\n", err)
 		return -1
 	}
-	var ack int
-	if _, err := fmt.Sscanf(string(buffer[:n]), "ACK:%d", &ack); err != nil {
+	ack, err := decodeAck(buffer[:n])
+	if err != nil {
 		fmt.Printf("Error receiving ack: Error=%v\n", err)
 		return -1
 	}
@@ -46,6 +76,10 @@ func ReceiveAck(conn *net.UDPConn) int {
 
 func StartGBNServer() {
 	fmt.Println("Starting GBN Server")
+	if WindowSize > seqSpace.MaxWindow(rdt.GBN) {
+		fmt.Printf("Window size %d too large for %d-bit sequence numbers (max %d)\n", WindowSize, SeqBits, seqSpace.MaxWindow(rdt.GBN))
+		return
+	}
 
 	serverAddr, _ := net.ResolveUDPAddr("udp", ":8080")
 	conn, _ := net.ListenUDP("udp", serverAddr)
@@ -62,7 +96,7 @@ func StartGBNServer() {
 	packets := make([]Packet, TotalPackets)
 
 	for i := 0; i < TotalPackets; i++ {
-		packets[i] = Packet{SeqNum: i, Data: fmt.Sprintf("Packet-%d", i)}
+		packets[i] = Packet{SeqNum: int(seqSpace.Add(0, i)), Data: []byte(fmt.Sprintf("Packet %d", i))}
 	}
 
 	timer := time.Time{}
@@ -79,8 +113,12 @@ func StartGBNServer() {
 		ack := ReceiveAck(conn)
 		if ack != -1 {
 			fmt.Printf("Received ACK: %d\n", ack)
-			base = ack + 1
-			timer = time.Time{}
+			// 累积确认：ACK 确认了从 base 开始的 d+1 个报文，窗口外的 ACK 是过时的重复确认
+			d := int(seqSpace.Distance(uint32(packets[base].SeqNum), uint32(ack)))
+			if d < nextSeqNum-base {
+				base += d + 1
+				timer = time.Time{}
+			}
 		}
 
 		if !timer.IsZero() && time.Since(timer) > Timeout {
@@ -91,5 +129,5 @@ func StartGBNServer() {
 			}
 		}
 	}
-	fmt.Println("All packets sent and acknowledged!")
+	fmt.Printf("All packets sent and acknowledged! (%d corrupt packets dropped)\n", CorruptPackets)
 }
@@ -3,6 +3,7 @@ package funServer
 import (
 	"fmt"
 	"net"
+	"rdt"
 	"time"
 )
 
@@ -14,14 +15,18 @@ const (
 
 type SRPacket struct {
 	SeqNum int
-	Data   string
+	Data   []byte
 	Acked  bool
 }
 
 func SendSRPacket(conn *net.UDPConn, addr *net.UDPAddr, packet SRPacket) {
-	fmt.Printf("Sending packet: SeqNum=%d, Data=%s\n", packet.SeqNum, packet.Data)
-	msg := fmt.Sprintf("%d:%s", packet.SeqNum, packet.Data)
-	if _, err := conn.WriteToUDP([]byte(msg), addr); err != nil {
+	fmt.Printf("Sending packet: SeqNum=%d, Data=%q\n", packet.SeqNum, packet.Data)
+	msg, err := rdt.Encode(rdt.Packet{Type: rdt.TypeData, Seq: uint32(packet.SeqNum), Window: SRWindowSize, Payload: packet.Data})
+	if err != nil {
+		fmt.Printf("Error encoding packet: SeqNum=%d, Error=%v\n", packet.SeqNum, err)
+		return
+	}
+	if _, err := conn.WriteToUDP(msg, addr); err != nil {
 		fmt.Printf("Error sending packet: SeqNum=%d, Error=%v\n", packet.SeqNum, err)
 	}
 }
@@ -37,8 +42,8 @@ func ReceiveSRAck(conn *net.UDPConn) int {
 		fmt.Printf("Error receiving ack: %v\n", err)
 		return -1
 	}
-	var ack int
-	if _, err := fmt.Sscanf(string(buffer[:n]), "SR_ACK:%d", &ack); err != nil {
+	ack, err := decodeAck(buffer[:n])
+	if err != nil {
 		fmt.Printf("Error parsing ack: %v\n", err)
 		return -1
 	}
@@ -47,6 +52,10 @@ func ReceiveSRAck(conn *net.UDPConn) int {
 
 func StartSRServer() {
 	fmt.Printf("Starting SR Server\n")
+	if SRWindowSize > seqSpace.MaxWindow(rdt.SR) {
+		fmt.Printf("Window size %d too large for %d-bit sequence numbers (max %d)\n", SRWindowSize, SeqBits, seqSpace.MaxWindow(rdt.SR))
+		return
+	}
 
 	serverAddr, _ := net.ResolveUDPAddr("udp", ":8080")
 	conn, _ := net.ListenUDP("udp", serverAddr)
@@ -64,7 +73,7 @@ func StartSRServer() {
 	acked := make([]bool, SRTotalPackets)
 
 	for i := 0; i < SRTotalPackets; i++ {
-		packets[i] = SRPacket{SeqNum: i, Data: fmt.Sprintf("Packet-%d", i), Acked: false}
+		packets[i] = SRPacket{SeqNum: int(seqSpace.Add(0, i)), Data: []byte(fmt.Sprintf("Packet %d", i)), Acked: false}
 	}
 
 	timers := make([]time.Time, SRTotalPackets)
@@ -79,10 +88,17 @@ func StartSRServer() {
 		}
 
 		ack := ReceiveSRAck(conn)
-		if ack != -1 && ack >= base && ack < base+SRWindowSize {
+		// 把 ACK 中的序列号换算为报文下标，只接受窗口内已发送的报文的 ACK
+		idx := -1
+		if ack != -1 {
+			if d := int(seqSpace.Distance(uint32(packets[base].SeqNum), uint32(ack))); d < SRWindowSize && base+d < nextSeqNum {
+				idx = base + d
+			}
+		}
+		if idx != -1 {
 			fmt.Printf("Received ACK: %d\n", ack)
-			packets[ack].Acked = true
-			acked[ack] = true
+			packets[idx].Acked = true
+			acked[idx] = true
 
 			for base < SRTotalPackets && acked[base] {
 				base++
@@ -97,5 +113,5 @@ func StartSRServer() {
 			}
 		}
 	}
-	fmt.Println("All packets sent and acknowledged!")
+	fmt.Printf("All packets sent and acknowledged! (%d corrupt packets dropped)\n", CorruptPackets)
 }
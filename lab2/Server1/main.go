@@ -1,10 +1,24 @@
 package main
 
-import "Server1/funServer"
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"rdt/cli"
+)
 
 func main() {
-	// 启动GBN服务器
-	funServer.StartGBNServer()
-	// 启动SR服务器
-	// funServer.StartSRServer()
+	opts, err := cli.ParseFlags(cli.Server, "server", os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := cli.Run(opts, os.Stdin, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,150 @@
+// Package cli 实现 Server1 和 Client1 共用的命令行：解析参数，建立 rdt 连接，
+// 发送输入的数据，同时把收到的数据写到输出
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"rdt"
+	"strings"
+	"time"
+)
+
+// Role 程序的角色
+type Role int
+
+const (
+	Server Role = iota // 在本地地址上等待连接
+	Client             // 连接远程地址
+)
+
+// Options 命令行参数
+type Options struct {
+	Role     Role
+	Protocol string // gbn、sr 或 saw；服务器为空时使用客户端选择的协议
+	Window   int
+	SeqBits  int
+	Timeout  time.Duration // 初始重传超时
+	MinRTO   time.Duration // 0 表示使用 rdt 的默认值
+	MaxRTO   time.Duration
+	FixedRTO bool
+	MSS      int
+	// RecvBuffer 接收缓存（字节），0 表示使用 rdt 的默认值
+	RecvBuffer int
+	// Congestion 拥塞控制算法：none、reno、newreno 或 cubic
+	Congestion string
+	// CwndTrace 非空时把拥塞窗口轨迹以 CSV 写入这个文件
+	CwndTrace string
+	Input     string // 要发送的文件，"-" 表示标准输入，为空时发送 Count 条生成的消息
+	Count     int
+	Output    string // 收到的数据写入的文件，"-" 表示标准输出
+	Local     string
+	Remote    string
+	Loss      float64 // 收到的数据报被丢弃的概率
+	Corrupt   float64 // 收到的数据报被损坏的概率
+	Seed      int64   // 丢包和损坏的随机数种子，0 表示使用当前时间
+	Verbose   bool    // 输出每个报文的协议事件
+
+	protocol   rdt.Protocol
+	congestion rdt.Congestion
+}
+
+// ParseFlags 解析命令行参数，name 为程序名
+func ParseFlags(role Role, name string, args []string, stderr io.Writer) (*Options, error) {
+	o := &Options{Role: role}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	defaultProtocol, defaultCount, defaultLocal := "gbn", 0, ":8081"
+	if role == Server {
+		defaultProtocol, defaultCount, defaultLocal = "", 10, ":8080"
+	}
+	fs.StringVar(&o.Protocol, "protocol", defaultProtocol, "协议：gbn、sr 或 saw（停等）；服务器留空时使用客户端选择的协议")
+	fs.IntVar(&o.Window, "window", 4, "窗口大小（报文数）；服务器使用双方窗口的较小值")
+	fs.IntVar(&o.SeqBits, "seq-bits", 3, "序列号位数 k：GBN 要求窗口 ≤ 2^k-1，SR 要求窗口 ≤ 2^(k-1)")
+	fs.DurationVar(&o.Timeout, "timeout", time.Second, "初始重传超时，之后根据往返时间调整")
+	fs.DurationVar(&o.MinRTO, "min-rto", 0, "重传超时的下限，0 表示 200ms 和两倍延迟确认时间中的较大者（不超过 -timeout）")
+	fs.DurationVar(&o.MaxRTO, "max-rto", 0, "重传超时退避的上限，0 表示 -timeout 的 10 倍")
+	fs.BoolVar(&o.FixedRTO, "fixed-rto", false, "不估计往返时间，每次重传都等待 -timeout")
+	fs.IntVar(&o.MSS, "mss", 1024, "每个报文携带的最大数据长度")
+	fs.IntVar(&o.RecvBuffer, "recv-buffer", 0, "接收缓存（字节，最大 65535），通告给对方作为流量控制窗口；0 表示 65535")
+	fs.StringVar(&o.Congestion, "congestion", "none", "拥塞控制：none、reno、newreno 或 cubic；拥塞窗口不超过 -window")
+	fs.StringVar(&o.CwndTrace, "cwnd-trace", "", "把拥塞窗口和慢启动阈值随时间的变化以 CSV 写入这个文件")
+	fs.StringVar(&o.Input, "input", "", "要发送的文件，- 表示标准输入；为空时发送 -count 条生成的消息")
+	fs.IntVar(&o.Count, "count", defaultCount, "没有 -input 时生成并发送的消息数")
+	fs.StringVar(&o.Output, "output", "-", "收到的数据写入的文件，- 表示标准输出")
+	fs.StringVar(&o.Local, "local", defaultLocal, "本地 UDP 地址")
+	fs.StringVar(&o.Remote, "remote", "127.0.0.1:8080", "服务器的 UDP 地址（客户端）")
+	fs.Float64Var(&o.Loss, "loss", 0, "模拟丢包：收到的数据报被丢弃的概率")
+	fs.Float64Var(&o.Corrupt, "corrupt", 0, "模拟损坏：收到的数据报被翻转一个比特的概率")
+	fs.Int64Var(&o.Seed, "seed", 0, "丢包和损坏的随机数种子，0 表示使用当前时间；相同的种子重现同一次实验")
+	fs.BoolVar(&o.Verbose, "v", false, "输出每个报文的发送、重传和确认")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (o *Options) validate() error {
+	if o.Protocol != "" {
+		p, err := rdt.ParseProtocol(o.Protocol)
+		if err != nil {
+			return err
+		}
+		o.protocol = p
+	} else if o.Role == Client {
+		return errors.New("-protocol is required")
+	}
+	cc, err := rdt.ParseCongestion(o.Congestion)
+	if err != nil {
+		return err
+	}
+	o.congestion = cc
+	if o.CwndTrace != "" && cc == rdt.NoCongestion {
+		return errors.New("-cwnd-trace requires -congestion")
+	}
+	if o.Loss < 0 || o.Loss >= 1 || o.Corrupt < 0 || o.Corrupt >= 1 {
+		return errors.New("-loss and -corrupt must be in [0, 1)")
+	}
+	if o.Count < 0 {
+		return errors.New("-count must not be negative")
+	}
+	if o.Role == Client && o.Remote == "" {
+		return errors.New("-remote is required")
+	}
+	return o.config(nil).Validate()
+}
+
+// config 返回连接参数，-v 时协议事件写入 log
+func (o *Options) config(log io.Writer) *rdt.Config {
+	cfg := &rdt.Config{
+		Protocol:        o.protocol,
+		Window:          o.Window,
+		SeqBits:         o.SeqBits,
+		Timeout:         o.Timeout,
+		MinRTO:          o.MinRTO,
+		MaxRTO:          o.MaxRTO,
+		FixedRTO:        o.FixedRTO,
+		MSS:             o.MSS,
+		RecvBuffer:      o.RecvBuffer,
+		Congestion:      o.congestion,
+		TraceCongestion: o.CwndTrace != "",
+	}
+	if cfg.Protocol == 0 {
+		// 服务器接受任意协议，用最宽松的 GBN 检查窗口
+		cfg.Protocol = rdt.GBN
+	}
+	if o.Verbose && log != nil {
+		cfg.Logf = func(format string, args ...any) {
+			fmt.Fprintf(log, format+"\n", args...)
+		}
+	}
+	return cfg
+}
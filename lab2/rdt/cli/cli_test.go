@@ -0,0 +1,165 @@
+package cli
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"rdt"
+)
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		role    Role
+		args    string
+		wantErr string
+	}{
+		{Server, "", ""},
+		{Server, "-protocol sr -window 4 -seq-bits 3", ""},
+		{Client, "-protocol saw -seq-bits 1", ""},
+		{Client, "-protocol gbn -window 7 -seq-bits 3 -loss 0.2 -corrupt 0.1 -seed 7", ""},
+		{Client, "-protocol gbn -window 8 -seq-bits 3", "window 8 out of range"},
+		{Client, "-protocol sr -window 5 -seq-bits 3", "window 5 out of range"},
+		{Client, "-protocol tcp", "unknown protocol"},
+		{Client, "-protocol=", "-protocol is required"},
+		{Client, "-protocol gbn -loss 1", "-loss"},
+		{Client, "-protocol gbn -remote=", "-remote"},
+		{Client, "-protocol gbn extra", "unexpected arguments"},
+		{Server, "-seq-bits 40", "bits 40 out of range"},
+		{Client, "-protocol gbn -timeout 1s -min-rto 2s", "timeout 1s out of range"},
+		{Client, "-protocol gbn -timeout 100ms -max-rto 50ms", "out of range"},
+		{Client, "-protocol sr -timeout 500ms -min-rto 150ms -max-rto 5s", ""},
+		{Client, "-protocol sr -timeout 500ms -min-rto 100ms", "ack delay 125ms must be shorter"},
+		{Server, "-no-such-flag", "not defined"},
+		{Client, "-protocol sr -congestion cubic -cwnd-trace cwnd.csv", ""},
+		{Client, "-protocol sr -congestion vegas", "unknown congestion control"},
+		{Client, "-protocol sr -cwnd-trace cwnd.csv", "requires -congestion"},
+		{Client, "-protocol sr -recv-buffer 4096", ""},
+		{Server, "-recv-buffer 70000", "receive buffer 70000 out of range"},
+	}
+	for _, tt := range tests {
+		o, err := ParseFlags(tt.role, "test", strings.Fields(tt.args), io.Discard)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%q: %v", tt.args, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%q: err %v, want %q", tt.args, err, tt.wantErr)
+		case err == nil && o.Role != tt.role:
+			t.Errorf("%q: role %v", tt.args, o.Role)
+		}
+	}
+
+	o, err := ParseFlags(Client, "client", []string{"-protocol", "SR", "-timeout", "150ms", "-fixed-rto", "-v"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := o.config(io.Discard)
+	if cfg.Protocol != rdt.SR || cfg.Timeout != 150*time.Millisecond || cfg.Window != 4 || cfg.SeqBits != 3 || !cfg.FixedRTO || cfg.Logf == nil {
+		t.Errorf("config %+v", cfg)
+	}
+}
+
+// syncWriter 允许多个 goroutine 同时写日志
+type syncWriter struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (w *syncWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(b)
+}
+
+func (w *syncWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+// freeAddr 返回一个当前空闲的本地 UDP 地址
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	return pc.LocalAddr().String()
+}
+
+// TestRun 服务器发送文件、客户端从标准输入发送，双方都有模拟的丢包和损坏
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	file := make([]byte, 30000)
+	for i := range file {
+		file[i] = byte(i * 7)
+	}
+	in := filepath.Join(dir, "in.bin")
+	if err := os.WriteFile(in, file, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	serverAddr := freeAddr(t)
+	common := []string{"-timeout", "50ms", "-loss", "0.15", "-corrupt", "0.05", "-seq-bits", "3", "-mss", "512"}
+
+	trace := filepath.Join(dir, "cwnd.csv")
+	srv, err := ParseFlags(Server, "server", append([]string{
+		"-local", serverAddr, "-input", in, "-congestion", "newreno", "-cwnd-trace", trace,
+	}, common...), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Seed = 1
+	var srvOut bytes.Buffer
+	srvLog := &syncWriter{}
+	done := make(chan error, 1)
+	go func() { done <- Run(srv, nil, &srvOut, srvLog) }()
+
+	cli, err := ParseFlags(Client, "client", append([]string{
+		"-protocol", "sr", "-local", "127.0.0.1:0", "-remote", serverAddr, "-input", "-",
+		"-output", filepath.Join(dir, "out.bin"), "-seed", "2",
+	}, common...), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cliLog := &syncWriter{}
+	// 服务器可能还没有开始监听，客户端的 SYN 会被重传
+	if err := Run(cli, strings.NewReader("hello from stdin\n"), io.Discard, cliLog); err != nil {
+		t.Fatalf("client: %v\n%s", err, cliLog)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("server: %v\n%s", err, srvLog)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "out.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, file) {
+		t.Errorf("client received %d bytes, want %d", len(got), len(file))
+	}
+	if srvOut.String() != "hello from stdin\n" {
+		t.Errorf("server received %q", srvOut.String())
+	}
+	for _, want := range []string{"Accepted sr connection", "Simulated:", "seed 1", "RTT: srtt", "Flow control: peer window", "Congestion: newreno"} {
+		if !strings.Contains(srvLog.String(), want) {
+			t.Errorf("server log lacks %q:\n%s", want, srvLog)
+		}
+	}
+	if !strings.Contains(cliLog.String(), "received 30000 bytes") {
+		t.Errorf("client log:\n%s", cliLog)
+	}
+	csv, err := os.ReadFile(trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(csv)), "\n"); lines[0] != "time,cwnd,ssthresh,event" || len(lines) < 10 {
+		t.Errorf("congestion trace has %d lines:\n%.200s", len(lines), csv)
+	}
+}
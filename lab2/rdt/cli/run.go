@@ -0,0 +1,172 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"rdt"
+	"strings"
+	"time"
+)
+
+// Run 建立连接后同时发送输入和接收数据，双方都关闭后输出统计
+func Run(o *Options, stdin io.Reader, stdout, stderr io.Writer) error {
+	input, closeInput, err := o.openInput(stdin)
+	if err != nil {
+		return err
+	}
+	defer closeInput()
+	output, closeOutput, err := o.openOutput(stdout)
+	if err != nil {
+		return err
+	}
+	defer closeOutput()
+
+	pc, err := net.ListenPacket("udp", o.Local)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+	seed := o.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	lossy := rdt.NewLossyPacketConn(pc, o.Loss, o.Corrupt, seed)
+	if o.Verbose {
+		lossy.Logf = func(format string, args ...any) {
+			fmt.Fprintf(stderr, format+"\n", args...)
+		}
+	}
+	if o.Loss > 0 || o.Corrupt > 0 {
+		fmt.Fprintf(stderr, "Simulating %.0f%% loss and %.0f%% corruption (seed %d)\n", o.Loss*100, o.Corrupt*100, seed)
+	}
+
+	c, err := o.connect(lossy, stderr)
+	if err != nil {
+		return err
+	}
+
+	sent := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(c, input)
+		if err == nil {
+			err = c.CloseWrite()
+		}
+		sent <- err
+	}()
+	received, recvErr := io.Copy(output, c)
+	sendErr := <-sent
+	closeErr := c.Close()
+	// 等待对方确认结束，以便重新确认对方重传的 FIN
+	<-c.Done()
+
+	s := c.Stats()
+	dropped, corrupted := lossy.Counts()
+	fmt.Fprintf(stderr, "Done: sent %d bytes in %d packets (%d retransmitted, %d timeouts), received %d bytes\n",
+		s.BytesSent, s.PacketsSent, s.Retransmissions, s.Timeouts, received)
+	fmt.Fprintf(stderr, "ACKs: %d sent, %d piggybacked, %d received; %d out of order, %d duplicates, %d corrupt packets dropped\n",
+		s.AcksSent, s.PiggybackedAcks, s.AcksReceived, s.OutOfOrder, s.Duplicates, s.CorruptPackets)
+	fmt.Fprintf(stderr, "RTT: srtt %v, rttvar %v, rto %v (%d samples)\n",
+		s.SRTT.Round(time.Microsecond), s.RTTVar.Round(time.Microsecond), s.RTO.Round(time.Microsecond), s.RTTSamples)
+	fmt.Fprintf(stderr, "Flow control: peer window %d bytes, %d stalls, %d zero-window probes, %d window updates sent\n",
+		s.PeerWindow, s.WindowStalls, s.WindowProbes, s.WindowUpdates)
+	if o.congestion != rdt.NoCongestion {
+		fmt.Fprintf(stderr, "Congestion: %v, cwnd %.1f, ssthresh %.1f, %d fast retransmits\n",
+			o.congestion, s.Cwnd, s.Ssthresh, s.FastRetransmits)
+	}
+	if o.CwndTrace != "" {
+		if err := writeTrace(o.CwndTrace, c.CongestionTrace()); err != nil {
+			fmt.Fprintf(stderr, "Writing %s: %v\n", o.CwndTrace, err)
+		}
+	}
+	if o.Loss > 0 || o.Corrupt > 0 {
+		fmt.Fprintf(stderr, "Simulated: %d datagrams dropped, %d corrupted\n", dropped, corrupted)
+	}
+	for _, err := range []error{sendErr, recvErr, closeErr} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// connect 服务器等待一个连接，客户端连接服务器
+func (o *Options) connect(pc net.PacketConn, stderr io.Writer) (*rdt.Conn, error) {
+	cfg := o.config(stderr)
+	if o.Role == Client {
+		raddr, err := net.ResolveUDPAddr("udp", o.Remote)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(stderr, "Connecting to %v using %v (window %d, %d-bit sequence numbers, initial timeout %v)\n",
+			raddr, cfg.Protocol, cfg.Window, cfg.SeqBits, cfg.Timeout)
+		return rdt.DialPacket(pc, raddr, cfg)
+	}
+
+	l, err := rdt.NewListener(pc, cfg)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(stderr, "Listening on %v\n", pc.LocalAddr())
+	// 连接释放后 Listener 才关闭 socket，Run 返回时的 pc.Close 负责最终关闭
+	defer l.Close()
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			return nil, err
+		}
+		c := nc.(*rdt.Conn)
+		if o.protocol != 0 && c.Protocol() != o.protocol {
+			fmt.Fprintf(stderr, "Rejecting %v connection from %v, want %v\n", c.Protocol(), c.RemoteAddr(), o.protocol)
+			_ = c.Close()
+			continue
+		}
+		fmt.Fprintf(stderr, "Accepted %v connection from %v\n", c.Protocol(), c.RemoteAddr())
+		return c, nil
+	}
+}
+
+// openInput 返回要发送的数据：文件、标准输入或生成的消息
+func (o *Options) openInput(stdin io.Reader) (io.Reader, func(), error) {
+	switch o.Input {
+	case "":
+		var b strings.Builder
+		for i := 0; i < o.Count; i++ {
+			fmt.Fprintf(&b, "Packet %d\n", i)
+		}
+		return strings.NewReader(b.String()), func() {}, nil
+	case "-":
+		return stdin, func() {}, nil
+	}
+	f, err := os.Open(o.Input)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, func() { _ = f.Close() }, nil
+}
+
+// writeTrace 把拥塞窗口轨迹写入 CSV 文件
+func writeTrace(name string, trace []rdt.CongestionSample) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := rdt.WriteCongestionTrace(f, trace); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// openOutput 返回收到的数据写入的位置
+func (o *Options) openOutput(stdout io.Writer) (io.Writer, func(), error) {
+	if o.Output == "-" || o.Output == "" {
+		return stdout, func() {}, nil
+	}
+	f, err := os.Create(o.Output)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, func() { _ = f.Close() }, nil
+}
@@ -0,0 +1,268 @@
+package rdt
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Congestion 拥塞控制算法，拥塞窗口以报文数为单位
+type Congestion uint8
+
+const (
+	NoCongestion Congestion = iota // 不做拥塞控制，发送窗口固定为 Window
+	Reno                           // 慢启动、拥塞避免、快速重传和快速恢复
+	NewReno                        // Reno，快速恢复期间的部分确认继续重传下一个丢失的报文
+	Cubic                          // NewReno 的恢复过程，拥塞避免阶段按三次函数增长
+)
+
+func (a Congestion) String() string {
+	switch a {
+	case NoCongestion:
+		return "none"
+	case Reno:
+		return "reno"
+	case NewReno:
+		return "newreno"
+	case Cubic:
+		return "cubic"
+	}
+	return fmt.Sprintf("congestion(%d)", uint8(a))
+}
+
+// ParseCongestion 解析拥塞控制算法名 none、reno、newreno 或 cubic
+func ParseCongestion(s string) (Congestion, error) {
+	switch strings.ToLower(s) {
+	case "", "none":
+		return NoCongestion, nil
+	case "reno":
+		return Reno, nil
+	case "newreno":
+		return NewReno, nil
+	case "cubic":
+		return Cubic, nil
+	}
+	return 0, fmt.Errorf("rdt: unknown congestion control %q, want none, reno, newreno or cubic", s)
+}
+
+// CongestionSample 拥塞窗口轨迹中的一个点
+type CongestionSample struct {
+	At       time.Duration // 距连接建立的时间
+	Cwnd     float64       // 拥塞窗口（报文数）
+	Ssthresh float64       // 慢启动阈值（报文数）
+	Event    string        // ack、dupack、fast-retransmit、partial-ack、recovered 或 timeout
+}
+
+// WriteCongestionTrace 把拥塞窗口轨迹写成 CSV：time（秒）、cwnd、ssthresh、event
+func WriteCongestionTrace(w io.Writer, trace []CongestionSample) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"time", "cwnd", "ssthresh", "event"})
+	for _, s := range trace {
+		_ = cw.Write([]string{
+			strconv.FormatFloat(s.At.Seconds(), 'f', 6, 64),
+			strconv.FormatFloat(s.Cwnd, 'f', 3, 64),
+			strconv.FormatFloat(s.Ssthresh, 'f', 3, 64),
+			s.Event,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// dupAckThreshold 连续收到这么多个重复确认时快速重传
+const dupAckThreshold = 3
+
+// congestionAlgorithm 拥塞避免阶段窗口如何增长、检测到丢包时窗口减到多少
+type congestionAlgorithm interface {
+	// increase 拥塞避免阶段 acked 个报文被确认后的拥塞窗口
+	increase(cwnd float64, acked int, now time.Time, rtt time.Duration) float64
+	// decrease 检测到丢包时的慢启动阈值，flight 为在途的报文数
+	decrease(cwnd float64, flight int, now time.Time) float64
+}
+
+// congestion 发送方的拥塞控制状态：慢启动、拥塞避免和快速恢复，
+// 拥塞避免阶段的增长和丢包后的减小由 algorithm 决定。所有方法都在持有 Conn.mu 时调用
+type congestion struct {
+	algorithm congestionAlgorithm
+	newReno   bool // 部分确认不结束快速恢复
+	limit     float64
+
+	cwnd        float64
+	ssthresh    float64
+	dupAcks     int
+	recovering  bool
+	recoverLeft int       // 进入快速恢复时在途的报文中尚未被确认的数量
+	lastLoss    time.Time // 最近一次减小窗口的时刻，此前发出的报文超时不再减小
+
+	start           time.Time
+	tracing         bool // 记录 trace
+	trace           []CongestionSample
+	fastRetransmits int64
+}
+
+// newCongestion 返回拥塞控制状态，NoCongestion 时返回 nil。
+// 拥塞窗口从 1 个报文开始，不超过流量控制窗口 limit，初始的慢启动阈值为 limit；
+// tracing 时记录窗口的每次变化
+func newCongestion(a Congestion, limit int, tracing bool) *congestion {
+	cc := &congestion{limit: float64(limit), cwnd: 1, ssthresh: float64(limit), tracing: tracing}
+	switch a {
+	case Reno:
+		cc.algorithm = aimd{}
+	case NewReno:
+		cc.algorithm, cc.newReno = aimd{}, true
+	case Cubic:
+		cc.algorithm, cc.newReno = &cubic{}, true
+	default:
+		return nil
+	}
+	return cc
+}
+
+// window 返回拥塞窗口允许在途的报文数
+func (cc *congestion) window() int {
+	return max(int(cc.cwnd), 1)
+}
+
+func (cc *congestion) record(now time.Time, event string) {
+	if !cc.tracing {
+		return
+	}
+	if cc.start.IsZero() {
+		cc.start = now
+	}
+	cc.trace = append(cc.trace, CongestionSample{At: now.Sub(cc.start), Cwnd: cc.cwnd, Ssthresh: cc.ssthresh, Event: event})
+}
+
+// onAck 累积确认号推进，新确认了 acked 个报文
+func (cc *congestion) onAck(c *Conn, acked int, now time.Time) {
+	cc.dupAcks = 0
+	if cc.recovering {
+		cc.recoverLeft -= acked
+		if cc.newReno && cc.recoverLeft > 0 {
+			// 部分确认：下一个报文也丢失了，立即重传，窗口减去被确认的报文
+			cc.cwnd = max(cc.cwnd-float64(acked)+1, 1)
+			cc.record(now, "partial-ack")
+			c.proto.fastRetransmit(c, now)
+			return
+		}
+		cc.recovering = false
+		cc.cwnd = cc.ssthresh
+		cc.record(now, "recovered")
+		return
+	}
+	if cc.cwnd < cc.ssthresh {
+		// 慢启动：每个被确认的报文让窗口加 1，每个往返时间翻倍
+		cc.cwnd = min(cc.cwnd+float64(acked), cc.ssthresh)
+	} else {
+		cc.cwnd = cc.algorithm.increase(cc.cwnd, acked, now, c.rtt.srtt)
+	}
+	cc.cwnd = min(cc.cwnd, cc.limit)
+	cc.record(now, "ack")
+}
+
+// onDupAck 收到一个没有推进确认号的确认，说明有报文乱序或丢失
+func (cc *congestion) onDupAck(c *Conn, now time.Time) {
+	if cc.recovering {
+		// 每个重复确认表示一个报文离开了网络，膨胀窗口让新报文补上
+		cc.cwnd++
+		cc.record(now, "dupack")
+		return
+	}
+	cc.dupAcks++
+	if cc.dupAcks < dupAckThreshold {
+		return
+	}
+	flight := len(c.segs)
+	cc.ssthresh = cc.algorithm.decrease(cc.cwnd, flight, now)
+	cc.cwnd = cc.ssthresh + dupAckThreshold
+	cc.recovering = true
+	cc.recoverLeft = flight
+	cc.lastLoss = now
+	cc.fastRetransmits++
+	cc.record(now, "fast-retransmit")
+	c.logf("fast retransmit from seq %d, cwnd %.1f ssthresh %.1f", c.segs[0].seq, cc.cwnd, cc.ssthresh)
+	c.proto.fastRetransmit(c, now)
+}
+
+// onTimeout 报文 s 超时：窗口回到 1 重新慢启动。
+// 上次减小窗口之前发出的报文属于同一次拥塞，不再减小
+func (cc *congestion) onTimeout(c *Conn, s *segment, now time.Time) {
+	if s.sentAt.Before(cc.lastLoss) {
+		return
+	}
+	cc.ssthresh = cc.algorithm.decrease(cc.cwnd, len(c.segs), now)
+	cc.cwnd = 1
+	cc.dupAcks = 0
+	cc.recovering = false
+	cc.lastLoss = now
+	cc.record(now, "timeout")
+}
+
+// aimd Reno 和 NewReno 的加性增、乘性减：每个往返时间窗口加 1，丢包后减半
+type aimd struct{}
+
+func (aimd) increase(cwnd float64, acked int, now time.Time, rtt time.Duration) float64 {
+	return cwnd + float64(acked)/cwnd
+}
+
+func (aimd) decrease(cwnd float64, flight int, now time.Time) float64 {
+	return max(float64(flight)/2, 2)
+}
+
+// cubic 按 RFC 9438，拥塞避免阶段的窗口是距上次丢包时间 t 的三次函数
+// W(t) = C·(t−K)³ + Wmax，在上次丢包时的窗口 Wmax 附近增长变慢，之后加速探测；
+// 同时不低于同样条件下 Reno 能达到的窗口
+type cubic struct {
+	wMax     float64   // 上次丢包时的窗口
+	wLastMax float64   // 再上一次丢包时的窗口，用于快速收敛
+	k        float64   // 窗口回到 wMax 需要的时间（秒）
+	epoch    time.Time // 本次拥塞避免开始的时刻
+	wEst     float64   // 按 Reno 方式估计的窗口
+}
+
+const (
+	cubicC    = 0.4
+	cubicBeta = 0.7
+)
+
+func (cb *cubic) increase(cwnd float64, acked int, now time.Time, rtt time.Duration) float64 {
+	if cb.epoch.IsZero() {
+		cb.epoch = now
+		cb.wEst = cwnd
+		if cwnd < cb.wMax {
+			cb.k = math.Cbrt((cb.wMax - cwnd) / cubicC)
+		} else {
+			cb.k = 0
+			cb.wMax = cwnd
+		}
+	}
+	t := now.Sub(cb.epoch) + rtt
+	d := t.Seconds() - cb.k
+	target := cubicC*d*d*d + cb.wMax
+	// Reno 友好区域：同样时间内 AIMD 能达到的窗口
+	cb.wEst += 3 * (1 - cubicBeta) / (1 + cubicBeta) * float64(acked) / cwnd
+	if cb.wEst > target {
+		return cwnd + float64(acked)*(cb.wEst-cwnd)/cwnd
+	}
+	if target > cwnd {
+		return cwnd + float64(acked)*min(target-cwnd, cwnd/2)/cwnd
+	}
+	return cwnd + float64(acked)*0.01/cwnd
+}
+
+func (cb *cubic) decrease(cwnd float64, flight int, now time.Time) float64 {
+	cb.epoch = time.Time{}
+	if cwnd < cb.wLastMax {
+		// 快速收敛：窗口比上次丢包时还小，让出更多带宽给新的连接
+		cb.wLastMax = cwnd
+		cb.wMax = cwnd * (1 + cubicBeta) / 2
+	} else {
+		cb.wLastMax = cwnd
+		cb.wMax = cwnd
+	}
+	return max(cwnd*cubicBeta, 2)
+}
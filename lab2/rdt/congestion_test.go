@@ -0,0 +1,271 @@
+package rdt
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"math"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCongestion(t *testing.T) {
+	for _, a := range []Congestion{NoCongestion, Reno, NewReno, Cubic} {
+		got, err := ParseCongestion(a.String())
+		if err != nil || got != a {
+			t.Errorf("%v: got %v, %v", a, got, err)
+		}
+	}
+	if _, err := ParseCongestion("vegas"); err == nil {
+		t.Error("unknown algorithm accepted")
+	}
+	if err := (&Config{Congestion: Cubic + 1}).Validate(); err == nil {
+		t.Error("invalid congestion control accepted")
+	}
+}
+
+// newManualConn 返回一个已建立、没有计时器协程的连接，测试直接调用 processAck 驱动发送方
+func newManualConn(t *testing.T, cfg Config) *Conn {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	conf, err := cfg.withDefaults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newConn(pc, pc.LocalAddr(), conf)
+	c.state = stateEstablished
+	return c
+}
+
+// ackAll 累积确认所有在途的报文，接收窗口不变
+func ackAll(c *Conn, now time.Time) {
+	c.processAck(Packet{Type: TypeAck, Seq: c.seq.Add(c.sndNxt, -1), Ack: c.sndNxt, Window: maxRecvBuffer}, now)
+}
+
+// TestCongestionTraceOptIn 只有设置了 TraceCongestion 才记录轨迹，长时间的传输不会积累无用的数据
+func TestCongestionTraceOptIn(t *testing.T) {
+	for _, tracing := range []bool{false, true} {
+		c := newManualConn(t, Config{Protocol: SR, Window: 64, MSS: 10, Congestion: Reno, SendBuffer: 1 << 20, TraceCongestion: tracing})
+		now := time.Now()
+		c.mu.Lock()
+		c.sendQueue = make([]byte, 1000)
+		c.fillWindow(now)
+		for range 3 {
+			ackAll(c, now)
+		}
+		cwnd := c.cc.cwnd
+		c.mu.Unlock()
+		if trace := c.CongestionTrace(); cwnd != 8 || (len(trace) > 0) != tracing {
+			t.Errorf("tracing %v: cwnd %v, %d samples", tracing, cwnd, len(trace))
+		}
+	}
+}
+
+func TestFastRecovery(t *testing.T) {
+	for _, alg := range []Congestion{Reno, NewReno} {
+		t.Run(alg.String(), func(t *testing.T) {
+			c := newManualConn(t, Config{Protocol: SR, Window: 64, MSS: 10, Congestion: alg, SendBuffer: 1 << 20, TraceCongestion: true})
+			now := time.Now()
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.sendQueue = make([]byte, 10000)
+			c.fillWindow(now)
+
+			// 慢启动：每个往返时间窗口翻倍
+			for _, want := range []int{1, 2, 4, 8, 16} {
+				if len(c.segs) != want || c.cc.cwnd != float64(want) {
+					t.Fatalf("%d packets in flight, cwnd %v, want %d", len(c.segs), c.cc.cwnd, want)
+				}
+				if want < 16 {
+					ackAll(c, now)
+				}
+			}
+
+			// 第一个报文丢失，后面的报文各引起一个重复确认
+			base := c.segs[0].seq
+			for i := 1; i <= 3; i++ {
+				c.processAck(Packet{Type: TypeAck, Seq: c.seq.Add(base, i), Ack: base, Window: maxRecvBuffer}, now)
+			}
+			if c.cc.ssthresh != 8 || c.cc.cwnd != 11 || !c.cc.recovering || c.cc.fastRetransmits != 1 {
+				t.Fatalf("after 3 dupacks: cwnd %v ssthresh %v recovering %v", c.cc.cwnd, c.cc.ssthresh, c.cc.recovering)
+			}
+			if c.stats.Retransmissions != 1 || c.segs[0].retries != 1 {
+				t.Fatalf("lost packet not retransmitted: %d retransmissions", c.stats.Retransmissions)
+			}
+			// 快速恢复中每个重复确认让窗口加 1
+			c.processAck(Packet{Type: TypeAck, Seq: c.seq.Add(base, 4), Ack: base, Window: maxRecvBuffer}, now)
+			if c.cc.cwnd != 12 {
+				t.Fatalf("cwnd %v not inflated", c.cc.cwnd)
+			}
+
+			// 部分确认：第二个报文也丢失了
+			c.processAck(Packet{Type: TypeAck, Seq: base, Ack: c.seq.Add(base, 1), Window: maxRecvBuffer}, now)
+			if alg == Reno {
+				// Reno 认为恢复结束，第二个丢失的报文只能等超时
+				if c.cc.recovering || c.cc.cwnd != 8 || c.stats.Retransmissions != 1 {
+					t.Fatalf("reno after partial ack: cwnd %v recovering %v", c.cc.cwnd, c.cc.recovering)
+				}
+			} else {
+				// 累积确认了丢失的报文和之前单独确认的 4 个报文，窗口减去 5 再加 1
+				if !c.cc.recovering || c.stats.Retransmissions != 2 || c.cc.cwnd != 8 {
+					t.Fatalf("newreno after partial ack: cwnd %v recovering %v, %d retransmissions", c.cc.cwnd, c.cc.recovering, c.stats.Retransmissions)
+				}
+				// 确认进入恢复时在途的所有报文后退出恢复，窗口回到 ssthresh
+				ackAll(c, now)
+				if c.cc.recovering || c.cc.cwnd != 8 {
+					t.Fatalf("newreno after full ack: cwnd %v recovering %v", c.cc.cwnd, c.cc.recovering)
+				}
+			}
+
+			// 拥塞避免：每个往返时间加 1
+			ackAll(c, now)
+			before := c.cc.cwnd
+			ackAll(c, now)
+			if d := c.cc.cwnd - before; d < 0.9 || d > 1.1 {
+				t.Errorf("congestion avoidance grew by %v per round trip", d)
+			}
+
+			// 超时：窗口回到 1，同一轮的其他报文超时不再减小
+			later := now.Add(time.Second)
+			flight := len(c.segs)
+			c.cc.onTimeout(c, c.segs[0], later)
+			if c.cc.cwnd != 1 || c.cc.ssthresh != max(float64(flight)/2, 2) {
+				t.Fatalf("after timeout: cwnd %v ssthresh %v", c.cc.cwnd, c.cc.ssthresh)
+			}
+			ssthresh := c.cc.ssthresh
+			c.cc.cwnd = 5
+			c.cc.onTimeout(c, c.segs[1], later)
+			if c.cc.cwnd != 5 || c.cc.ssthresh != ssthresh {
+				t.Error("second timeout from the same window reduced the window again")
+			}
+
+			events := map[string]bool{}
+			for _, s := range c.cc.trace {
+				events[s.Event] = true
+			}
+			for _, e := range []string{"ack", "dupack", "fast-retransmit", "recovered", "timeout"} {
+				if !events[e] {
+					t.Errorf("trace lacks %q", e)
+				}
+			}
+		})
+	}
+}
+
+// TestCubicGrowth 丢包后窗口先快速接近 Wmax，在 Wmax 附近变平，之后加速增长
+func TestCubicGrowth(t *testing.T) {
+	cb := &cubic{}
+	const wMax = 100.0
+	cwnd := cb.decrease(wMax, 100, time.Time{})
+	if cwnd != wMax*cubicBeta {
+		t.Fatalf("ssthresh %v after loss, want %v", cwnd, wMax*cubicBeta)
+	}
+	k := math.Cbrt(wMax * (1 - cubicBeta) / cubicC)
+	// 往返时间较长时 CUBIC 的增长快于 Reno，不进入 Reno 友好区域
+	const rtt = 200 * time.Millisecond
+	const perSecond = int(time.Second / rtt)
+	start := time.Unix(0, 0)
+	at := map[int]float64{}
+	for i := 0; i <= int(2*k)*perSecond; i++ {
+		// 每个往返时间确认一个窗口的报文
+		cwnd = cb.increase(cwnd, int(cwnd), start.Add(time.Duration(i)*rtt), rtt)
+		at[i] = cwnd
+	}
+	kIndex := int(k * float64(perSecond))
+	if atK := at[kIndex]; math.Abs(atK-wMax) > 5 {
+		t.Errorf("cwnd %.1f at K=%.2fs, want about %v", atK, k, wMax)
+	}
+	early := at[perSecond] - at[0]
+	plateau := at[kIndex+perSecond/2] - at[kIndex-perSecond/2]
+	last := int(2*k) * perSecond
+	late := at[last] - at[last-perSecond]
+	if !(plateau < early && plateau < late) {
+		t.Errorf("growth per second: %.1f after the loss, %.1f around Wmax, %.1f later", early, plateau, late)
+	}
+
+	// 快速收敛：窗口没有回到上次的 Wmax 就再次丢包时，Wmax 取更小的值
+	cb.decrease(80, 80, time.Time{})
+	if cb.wMax >= 80 {
+		t.Errorf("wMax %v, want below 80", cb.wMax)
+	}
+}
+
+// TestCongestionTransfer 有丢包时拥塞窗口限制发送，数据仍然完整，轨迹可以导出为 CSV
+func TestCongestionTransfer(t *testing.T) {
+	for _, alg := range []Congestion{Reno, NewReno, Cubic} {
+		t.Run(alg.String(), func(t *testing.T) {
+			cfg := Config{Protocol: SR, Window: 64, Timeout: 100 * time.Millisecond, MSS: 500, Congestion: alg, TraceCongestion: true}
+			l, err := Listen("udp", "127.0.0.1:0", &cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			want := testPayload(300000)
+			got := make(chan []byte, 1)
+			go func() {
+				nc, err := l.Accept()
+				if err != nil {
+					got <- nil
+					return
+				}
+				b, _ := io.ReadAll(nc)
+				_ = nc.Close()
+				got <- b
+			}()
+
+			pc := newLossyConn(t, 5, 0.02, 0)
+			defer pc.Close()
+			c, err := DialPacket(pc, l.Addr(), &cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.Write(want); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if b := <-got; !bytes.Equal(b, want) {
+				t.Fatalf("received %d bytes, want %d", len(b), len(want))
+			}
+
+			s := c.Stats()
+			trace := c.CongestionTrace()
+			peak := 0.0
+			for _, p := range trace {
+				peak = max(peak, p.Cwnd)
+				if p.Cwnd > 2*float64(cfg.Window) {
+					t.Fatalf("cwnd %v far above the window", p.Cwnd)
+				}
+			}
+			t.Logf("cwnd %.1f ssthresh %.1f peak %.1f, %d fast retransmits, %d timeouts, %d samples",
+				s.Cwnd, s.Ssthresh, peak, s.FastRetransmits, s.Timeouts, len(trace))
+			if s.FastRetransmits == 0 || s.Ssthresh >= float64(cfg.Window) || peak < 8 {
+				t.Errorf("congestion control inactive: %+v", s)
+			}
+			for i := 1; i < len(trace); i++ {
+				if trace[i].At < trace[i-1].At {
+					t.Fatal("trace not in time order")
+				}
+			}
+
+			var buf bytes.Buffer
+			if err := WriteCongestionTrace(&buf, trace); err != nil {
+				t.Fatal(err)
+			}
+			rows, err := csv.NewReader(&buf).ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != len(trace)+1 || strings.Join(rows[0], ",") != "time,cwnd,ssthresh,event" {
+				t.Errorf("csv has %d rows, header %v", len(rows), rows[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,823 @@
+package rdt
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Config 连接参数，零值字段使用默认值
+type Config struct {
+	Protocol   Protocol      // 重传策略，默认 GBN；Listen 一方使用 Dial 一方在 SYN 中声明的协议
+	Window     int           // 发送和接收窗口（报文数），默认 8；停等协议固定为 1
+	SeqBits    int           // 序列号位数 k，默认 32；GBN 要求窗口 ≤ 2^k-1，SR 要求窗口 ≤ 2^(k-1)
+	Timeout    time.Duration // 初始重传超时，收到往返时间样本之前使用，默认 1s
+	MSS        int           // 每个报文携带的最大数据长度，默认 1024
+	SendBuffer int           // Write 可以缓存的尚未发送的字节数，默认 64 KiB
+	// RecvBuffer 接收缓存（字节），默认也是最大值 65535。接收方在每个报文中通告缓存的剩余空间，
+	// 发送方在途的数据不超过这个窗口，应用读取得慢时发送方随之停下
+	RecvBuffer   int
+	MaxRetries   int           // 同一报文的最多重传次数，超过后认为对方不可达，默认 20
+	CloseTimeout time.Duration // 本方 FIN 被确认后等待对方 FIN 的最长时间，默认 20 个超时
+	// AckDelay 按序到达的报文最多延迟多久确认，期间本方发送的数据报文捎带确认；
+	// 默认为超时的 1/4，但不超过 200ms，负数表示立即确认
+	AckDelay time.Duration
+	// MinRTO、MaxRTO 按往返时间估计的重传超时的范围。MinRTO 默认为 200ms 和
+	// 两倍 AckDelay 中的较大者（不超过 Timeout），必须长于 AckDelay，
+	// 否则对方延迟的确认会引起重传；MaxRTO 默认为 Timeout 的 10 倍
+	MinRTO time.Duration
+	MaxRTO time.Duration
+	// FixedRTO 不估计往返时间，也不退避，每次重传都等待 Timeout
+	FixedRTO bool
+	// Congestion 拥塞控制算法，限制实际的发送窗口不超过拥塞窗口；默认不做拥塞控制
+	Congestion Congestion
+	// TraceCongestion 记录拥塞窗口的每次变化，供 Conn.CongestionTrace 导出。
+	// 轨迹随传输的数据量增长，默认不记录
+	TraceCongestion bool
+
+	// Logf 非空时输出协议事件（发送、重传、确认、丢弃），用于实验演示
+	Logf func(format string, args ...any)
+}
+
+const (
+	defaultWindow     = 8
+	defaultTimeout    = time.Second
+	defaultMSS        = 1024
+	defaultSendBuffer = 64 << 10
+	maxRecvBuffer     = 1<<16 - 1 // 窗口字段为 16 位
+	defaultMaxRetries = 20
+	defaultSeqBits    = 32
+	defaultMinRTO     = 200 * time.Millisecond
+	maxAckDelay       = 200 * time.Millisecond
+	maxWindow         = 1<<16 - 1
+)
+
+var (
+	ErrHandshake   = errors.New("rdt: handshake timed out")
+	ErrPeerTimeout = errors.New("rdt: peer stopped acknowledging packets")
+)
+
+// Validate 检查配置是否合法，零值字段按默认值检查
+func (c *Config) Validate() error {
+	_, err := c.withDefaults()
+	return err
+}
+
+// withDefaults 返回填充了默认值并检查过的配置
+func (c *Config) withDefaults() (Config, error) {
+	var cfg Config
+	if c != nil {
+		cfg = *c
+	}
+	if cfg.Protocol == 0 {
+		cfg.Protocol = GBN
+	}
+	if cfg.Protocol != GBN && cfg.Protocol != SR && cfg.Protocol != StopAndWait {
+		return cfg, fmt.Errorf("rdt: unknown protocol %v", cfg.Protocol)
+	}
+	if cfg.Congestion > Cubic {
+		return cfg, fmt.Errorf("rdt: unknown congestion control %v", cfg.Congestion)
+	}
+	if cfg.Window == 0 {
+		cfg.Window = defaultWindow
+	}
+	if cfg.Protocol == StopAndWait {
+		cfg.Window = 1
+	}
+	if cfg.SeqBits == 0 {
+		cfg.SeqBits = defaultSeqBits
+	}
+	space, err := NewSeqSpace(cfg.SeqBits)
+	if err != nil {
+		return cfg, err
+	}
+	limit := min(space.MaxWindow(cfg.Protocol), maxWindow)
+	if cfg.Window < 1 || cfg.Window > limit {
+		return cfg, fmt.Errorf("rdt: %v window %d out of range 1..%d for %d-bit sequence numbers",
+			cfg.Protocol, cfg.Window, limit, cfg.SeqBits)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.MSS <= 0 {
+		cfg.MSS = defaultMSS
+	}
+	if cfg.MSS > MaxPayloadSize {
+		return cfg, fmt.Errorf("rdt: MSS %d larger than %d", cfg.MSS, MaxPayloadSize)
+	}
+	if cfg.SendBuffer <= 0 {
+		cfg.SendBuffer = defaultSendBuffer
+	}
+	if cfg.RecvBuffer == 0 {
+		cfg.RecvBuffer = maxRecvBuffer
+	}
+	if cfg.RecvBuffer < 1 || cfg.RecvBuffer > maxRecvBuffer {
+		return cfg, fmt.Errorf("rdt: receive buffer %d out of range 1..%d", cfg.RecvBuffer, maxRecvBuffer)
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = defaultMaxRetries
+	}
+	if cfg.AckDelay == 0 {
+		cfg.AckDelay = min(cfg.Timeout/4, maxAckDelay)
+	}
+	if cfg.MinRTO <= 0 {
+		cfg.MinRTO = min(max(defaultMinRTO, 2*cfg.AckDelay), cfg.Timeout)
+	}
+	if cfg.MaxRTO <= 0 {
+		cfg.MaxRTO = 10 * cfg.Timeout
+	}
+	if cfg.MinRTO > cfg.Timeout || cfg.Timeout > cfg.MaxRTO {
+		return cfg, fmt.Errorf("rdt: timeout %v out of range %v..%v", cfg.Timeout, cfg.MinRTO, cfg.MaxRTO)
+	}
+	if cfg.AckDelay >= cfg.MinRTO {
+		return cfg, fmt.Errorf("rdt: ack delay %v must be shorter than the minimum RTO %v", cfg.AckDelay, cfg.MinRTO)
+	}
+	if cfg.CloseTimeout <= 0 {
+		cfg.CloseTimeout = 20 * cfg.Timeout
+	}
+	return cfg, nil
+}
+
+// Stats 连接的收发统计
+type Stats struct {
+	PacketsSent     int64 // 发送的 DATA/FIN 报文数，包括重传
+	Retransmissions int64 // 重传次数
+	Timeouts        int64 // 重传计时器超时次数
+	AcksSent        int64 // 单独发送的 ACK 报文数
+	AcksReceived    int64
+	PiggybackedAcks int64 // 由本方数据报文捎带、省去的 ACK 报文数
+	PacketsReceived int64 // 收到的校验正确的报文数
+	CorruptPackets  int64 // 校验失败被丢弃的报文数
+	OutOfOrder      int64 // 乱序到达的数据报文数
+	Duplicates      int64 // 重复收到的数据报文数
+	BytesSent       int64 // 首次发送的应用数据字节数
+	BytesReceived   int64 // 交付给应用的字节数
+
+	// 往返时间估计（RFC 6298）
+	SRTT       time.Duration // 平滑往返时间
+	RTTVar     time.Duration // 往返时间偏差
+	RTO        time.Duration // 当前的重传超时，包括退避
+	RTTSamples int64         // 有效的往返时间样本数，不包括重传报文
+
+	// 拥塞控制，没有拥塞控制时为零
+	Cwnd            float64 // 拥塞窗口（报文数）
+	Ssthresh        float64 // 慢启动阈值（报文数）
+	FastRetransmits int64   // 收到三个重复确认后的快速重传次数
+
+	// 流量控制
+	PeerWindow    int   // 对方最近通告的接收窗口（字节）
+	WindowStalls  int64 // 对方的接收窗口放不下新报文而停止发送的次数
+	WindowProbes  int64 // 发送的零窗口探测数
+	WindowUpdates int64 // 应用读取数据后主动发送的窗口更新数
+	WindowDrops   int64 // 接收缓存放不下而丢弃的数据报文数
+}
+
+// 连接状态
+const (
+	stateSynSent     = iota // Dial 已发送 SYN，等待回应
+	stateEstablished        // 可以收发数据
+	stateClosed             // 连接已释放
+)
+
+// segment 已发送但尚未被确认的报文
+type segment struct {
+	seq     uint32
+	payload []byte
+	fin     bool
+	sentAt  time.Time // 最近一次发送的时间
+	retries int       // 重传次数
+	acked   bool      // SR 中已被单独确认
+	timer   *timer    // SR 中这个报文的重传计时器
+}
+
+// Conn 基于 UDP 的可靠连接，实现 net.Conn。
+// 所有协议状态由 mu 保护：收到报文、计时器和应用的读写都在持有 mu 时修改状态，
+// 状态变化后通过 cond 唤醒等待的 Read、Write、Close 和 Dial。
+// 计时器放在最小堆 timers 中，由 timerLoop 在各自的到期时刻触发
+type Conn struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	cfg    Config
+	proto  strategy
+	window int      // 协商后的窗口
+	seq    SeqSpace // 协商后的序列号空间
+
+	pc         net.PacketConn
+	raddr      net.Addr
+	client     bool   // Dial 一方
+	ownsSocket bool   // Dial 创建的连接独占 pc，释放时关闭
+	onRelease  func() // 释放时从 Listener 中注销
+
+	state     int
+	err       error
+	synSentAt time.Time
+	synTries  int
+	synTimer  *timer // SYN 重传
+	rtt       rtoEstimator
+	cc        *congestion // 拥塞控制，nil 表示不做拥塞控制
+
+	timers timerHeap
+	wake   chan struct{} // 最早的到期时间提前时唤醒 timerLoop
+
+	// 发送方向
+	sndNxt       uint32     // 下一个新报文的序列号
+	segs         []*segment // 已发送未确认的报文，按序列号排列
+	sendQueue    []byte     // Write 写入、尚未分段发送的数据
+	inFlight     int        // segs 中的数据字节数
+	peerWnd      int        // 对方通告的接收窗口（字节）
+	persistTimer *timer     // 对方窗口为零时定时探测
+	probes       int        // 连续的窗口探测次数，用于退避
+	stalled      bool       // 因对方窗口不足停止发送，尚未恢复
+	closing      bool       // 应用已调用 Close，数据发完后发送 FIN
+	finSent      bool
+	finAcked     bool
+
+	// 接收方向
+	rcvNxt   uint32            // 下一个期望按序收到的序列号
+	ooo      map[uint32]Packet // SR 缓存的乱序报文
+	readBuf  []byte            // 已按序到达、等待 Read 的数据
+	peerFin  bool              // 已按序收到对方的 FIN
+	ackTimer *timer            // 延迟确认，未启动表示没有待发送的确认
+	ackOwed  int               // 尚未确认的按序报文数
+	lastWnd  int               // 最近一次通告的接收窗口
+
+	appClosed    bool   // Close 已返回，连接只为确认对方的重传而保留
+	releaseTimer *timer // appClosed 之后释放连接
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	stats   Stats
+	done    chan struct{}
+	release sync.Once
+}
+
+func newConn(pc net.PacketConn, raddr net.Addr, cfg Config) *Conn {
+	space, _ := NewSeqSpace(cfg.SeqBits)
+	c := &Conn{
+		cfg:     cfg,
+		seq:     space,
+		proto:   newStrategy(cfg.Protocol),
+		window:  cfg.Window,
+		rtt:     newRTOEstimator(cfg),
+		cc:      newCongestion(cfg.Congestion, cfg.Window, cfg.TraceCongestion),
+		pc:      pc,
+		raddr:   raddr,
+		peerWnd: maxRecvBuffer,  // 握手时由 SYN 中对方的接收缓存取代
+		lastWnd: cfg.RecvBuffer, // SYN 通告了整个接收缓存
+		ooo:     make(map[uint32]Packet),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	c.synTimer = newTimer(c.synTimeout)
+	c.ackTimer = newTimer(func(now time.Time) { c.sendAck(c.seq.Add(c.rcvNxt, -1), now) })
+	c.releaseTimer = newTimer(func(time.Time) { c.shutdown(nil) })
+	c.persistTimer = newTimer(c.probeWindow)
+	return c
+}
+
+func (c *Conn) logf(format string, args ...any) {
+	if c.cfg.Logf != nil {
+		c.cfg.Logf(format, args...)
+	}
+}
+
+// synTimeout 握手超时：重传 SYN，重试次数耗尽后放弃
+func (c *Conn) synTimeout(now time.Time) {
+	if c.state != stateSynSent {
+		return
+	}
+	if c.synTries > c.cfg.MaxRetries {
+		c.fail(ErrHandshake)
+		return
+	}
+	c.rtt.timedOut(c.synSentAt, now)
+	c.sendSyn(now)
+}
+
+// handle 处理一个校验正确的报文
+func (c *Conn) handle(p Packet, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == stateClosed {
+		return
+	}
+	c.stats.PacketsReceived++
+	switch p.Type {
+	case TypeSyn:
+		c.handleSyn(p, now)
+	case TypeAck:
+		c.stats.AcksReceived++
+		if c.state == stateSynSent {
+			return
+		}
+		c.processAck(p, now)
+	case TypeNak:
+		// 接收方报告缺失的报文，立即重传
+		for _, s := range c.segs {
+			if s.seq == p.Ack && !s.acked {
+				c.retransmit(s, now)
+			}
+		}
+	case TypeData, TypeFin:
+		if c.state == stateSynSent {
+			// 对方已经在发送数据，说明它收到了 SYN，只是回应丢失了
+			c.established(now)
+		}
+		switch {
+		case p.Type == TypeData && len(p.Payload) == 0:
+			// 零窗口探测：立即回应当前的窗口
+			c.sendAck(c.seq.Add(c.rcvNxt, -1), now)
+		case c.overflows(p):
+			// 对方没有遵守通告的窗口，接收缓存放不下：丢弃，并立即告诉对方当前的窗口
+			c.stats.WindowDrops++
+			c.logf("receive buffer full, dropping seq %d", p.Seq)
+			c.sendAck(c.seq.Add(c.rcvNxt, -1), now)
+		default:
+			c.proto.onData(c, p, now)
+		}
+		// 数据报文的确认号捎带了对方的累积确认；窗口中的新报文可以捎带对这个报文的确认
+		c.processAck(p, now)
+		c.flushAck(p, now)
+	}
+	c.checkFinished(now)
+	c.cond.Broadcast()
+}
+
+// processAck 处理报文中的确认：记录对方的接收窗口，交给重传策略，更新拥塞窗口，
+// 窗口有空间时发送新的报文。没有推进确认号、也没有改变窗口的 ACK 报文是重复确认，
+// 表示对方收到了乱序的报文；只改变了窗口的是窗口更新
+func (c *Conn) processAck(p Packet, now time.Time) {
+	advances := c.ackAdvances(p.Ack)
+	dup := !advances && p.Type == TypeAck && len(c.segs) > 0 && p.Ack == c.segs[0].seq && int(p.Window) == c.peerWnd
+	oldWnd := c.peerWnd
+	c.updatePeerWindow(p)
+	inFlight := len(c.segs)
+	progress := c.proto.onAck(c, p, now)
+	if c.cc != nil {
+		if advances {
+			// SR 中之前被单独确认的报文也随累积确认一起离开窗口
+			c.cc.onAck(c, inFlight-len(c.segs), now)
+		} else if dup {
+			c.cc.onDupAck(c, now)
+		}
+	}
+	if progress || c.cc != nil || c.peerWnd > oldWnd {
+		// 快速恢复中重复确认膨胀的拥塞窗口也允许发送新的报文
+		c.fillWindow(now)
+	}
+}
+
+// sendWindow 返回允许在途的报文数：窗口和拥塞窗口的较小值。
+// 在途的字节数另外受对方的接收窗口限制，见 fillWindow
+func (c *Conn) sendWindow() int {
+	if c.cc != nil {
+		return min(c.window, c.cc.window())
+	}
+	return c.window
+}
+
+// handleSyn Dial 一方收到 SYN 回应后建立连接；Listen 一方收到重复的 SYN 时重新回应
+func (c *Conn) handleSyn(p Packet, now time.Time) {
+	if c.state == stateSynSent {
+		if c.synTries == 1 {
+			c.rtt.sample(now.Sub(c.synSentAt))
+		}
+		c.peerWnd = synRecvBuffer(p)
+		// 监听方回应的窗口是双方的较小值，两端使用同样的窗口
+		if p.Window > 0 {
+			c.window = max(min(c.window, int(p.Window)), 1)
+		}
+		c.established(now)
+		c.sendAck(c.seq.Add(c.rcvNxt, -1), now)
+		return
+	}
+	if c.client {
+		// 回应的 SYN 重复到达
+		c.sendAck(c.seq.Add(c.rcvNxt, -1), now)
+		return
+	}
+	c.sendSyn(now)
+}
+
+func (c *Conn) established(now time.Time) {
+	c.state = stateEstablished
+	c.cancel(c.synTimer)
+	c.logf("connection established with %v (%v, window %d)", c.raddr, c.cfg.Protocol, c.window)
+	c.fillWindow(now)
+}
+
+// sendSyn 发送 SYN，数据为协议、序列号位数和接收缓存大小（2 字节），窗口字段为窗口大小
+func (c *Conn) sendSyn(now time.Time) {
+	if c.state == stateSynSent {
+		c.synSentAt = now
+		c.schedule(c.synTimer, now.Add(c.rtt.rto()))
+	}
+	c.synTries++
+	c.writePacket(Packet{Type: TypeSyn, Window: uint16(c.window), Payload: []byte{
+		byte(c.cfg.Protocol), byte(c.cfg.SeqBits), byte(c.cfg.RecvBuffer >> 8), byte(c.cfg.RecvBuffer),
+	}})
+}
+
+// synRecvBuffer 返回 SYN 中对方的接收缓存大小，作为对方最初的接收窗口。
+// 没有这个字段时不限制
+func synRecvBuffer(syn Packet) int {
+	if len(syn.Payload) < 4 {
+		return maxRecvBuffer
+	}
+	return int(syn.Payload[2])<<8 | int(syn.Payload[3])
+}
+
+// fillWindow 在窗口允许时把待发送的数据分段发送，数据发完且应用已关闭时发送 FIN。
+// 报文的数据不能超出对方的接收窗口；没有在途的报文时发送窗口能放下的部分，
+// 否则没有确认能带来新的窗口
+func (c *Conn) fillWindow(now time.Time) {
+	if c.state != stateEstablished {
+		return
+	}
+	for len(c.segs) < c.sendWindow() {
+		var s *segment
+		switch {
+		case len(c.sendQueue) > 0:
+			n := min(len(c.sendQueue), c.cfg.MSS)
+			if room := c.peerWnd - c.inFlight; n > room {
+				if room <= 0 || len(c.segs) > 0 {
+					c.blocked(now)
+					return
+				}
+				n = room
+			}
+			c.windowOpened()
+			c.inFlight += n
+			s = &segment{seq: c.sndNxt, payload: c.sendQueue[:n:n]}
+			c.sendQueue = c.sendQueue[n:]
+			if len(c.sendQueue) == 0 {
+				c.sendQueue = nil
+			}
+			c.stats.BytesSent += int64(n)
+		case c.closing && !c.finSent:
+			s = &segment{seq: c.sndNxt, fin: true}
+			c.finSent = true
+		default:
+			return
+		}
+		c.sndNxt = c.seq.Next(c.sndNxt)
+		c.segs = append(c.segs, s)
+		c.transmit(s, now)
+	}
+}
+
+func (c *Conn) transmit(s *segment, now time.Time) {
+	s.sentAt = now
+	c.stats.PacketsSent++
+	p := Packet{Type: TypeData, Seq: s.seq, Ack: c.rcvNxt, Window: c.advertise(), Payload: s.payload}
+	if s.fin {
+		p.Type = TypeFin
+	}
+	if c.ackOwed > 0 {
+		// 确认号已经包含了所有按序到达的报文，不必再单独发送 ACK
+		c.stats.PiggybackedAcks++
+		c.ackOwed = 0
+		c.cancel(c.ackTimer)
+	}
+	c.logf("send %v", p)
+	c.writePacket(p)
+	c.proto.onSend(c, s, now)
+}
+
+// retransmit 重传一个报文，重传次数超过 MaxRetries 时认为对方不可达
+func (c *Conn) retransmit(s *segment, now time.Time) {
+	if c.state != stateEstablished {
+		return
+	}
+	if s.retries >= c.cfg.MaxRetries {
+		c.fail(ErrPeerTimeout)
+		return
+	}
+	s.retries++
+	c.stats.Retransmissions++
+	c.transmit(s, now)
+}
+
+// sampleRTT 报文被确认时测量往返时间，重传过的报文不作为样本（Karn 算法），只撤销一次退避
+func (c *Conn) sampleRTT(s *segment, now time.Time) {
+	if s.retries == 0 {
+		c.rtt.sample(now.Sub(s.sentAt))
+	} else {
+		c.rtt.retransmissionAcked()
+	}
+}
+
+// ackInOrder 记录一个按序到达、需要确认的报文，最多等待 AckDelay，让本方的数据报文捎带确认
+func (c *Conn) ackInOrder(p Packet, now time.Time) {
+	c.ackOwed++
+	if !c.ackTimer.active() {
+		c.schedule(c.ackTimer, now.Add(c.cfg.AckDelay))
+	}
+}
+
+// flushAck 处理完收到的报文后决定是否立即确认：等待确认的报文达到半个窗口时立即确认
+// （停等协议每个报文都立即确认），避免发送方的窗口用完而停顿；FIN 立即确认，让对方尽快结束
+func (c *Conn) flushAck(p Packet, now time.Time) {
+	if c.ackOwed == 0 {
+		return
+	}
+	if c.cfg.AckDelay < 0 || c.ackOwed >= (c.window+1)/2 || p.Type == TypeFin {
+		c.sendAck(c.seq.Add(c.rcvNxt, -1), now)
+	}
+}
+
+// sendAck 确认收到序列号为 seq 的报文，确认号为下一个期望的序列号
+func (c *Conn) sendAck(seq uint32, now time.Time) {
+	c.ackOwed = 0
+	c.cancel(c.ackTimer)
+	c.stats.AcksSent++
+	p := Packet{Type: TypeAck, Seq: seq, Ack: c.rcvNxt, Window: c.advertise()}
+	c.logf("send %v", p)
+	c.writePacket(p)
+}
+
+func (c *Conn) writePacket(p Packet) {
+	b, err := Encode(p)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	if _, err := c.pc.WriteTo(b, c.raddr); err != nil {
+		c.logf("write to %v: %v", c.raddr, err)
+	}
+}
+
+// ackedCount 返回累积确认号 ack 新确认的报文数。
+// 未确认的报文不超过窗口大小，所以只有 ack 落在 (segs[0].seq, sndNxt] 内时才确认了新的报文
+func (c *Conn) ackedCount(ack uint32) int {
+	if len(c.segs) == 0 {
+		return 0
+	}
+	d := c.seq.Distance(c.segs[0].seq, ack)
+	if d == 0 || d > uint32(len(c.segs)) {
+		return 0
+	}
+	return int(d)
+}
+
+// ackAdvances 判断累积确认号是否确认了新的报文
+func (c *Conn) ackAdvances(ack uint32) bool {
+	return c.ackedCount(ack) > 0
+}
+
+// removeAcked 移除序列号在 ack 之前的报文
+func (c *Conn) removeAcked(ack uint32, now time.Time) {
+	n := c.ackedCount(ack)
+	for _, s := range c.segs[:n] {
+		if s.fin {
+			c.finAcked = true
+		}
+		c.inFlight -= len(s.payload)
+		c.cancel(s.timer)
+	}
+	c.segs = c.segs[n:]
+}
+
+// deliver 按序交付一个报文：数据进入 readBuf，FIN 表示对方不再发送数据
+func (c *Conn) deliver(p Packet) {
+	c.rcvNxt = c.seq.Next(c.rcvNxt)
+	if c.peerFin {
+		return
+	}
+	if p.Type == TypeFin {
+		c.peerFin = true
+		c.logf("peer finished sending")
+		return
+	}
+	c.readBuf = append(c.readBuf, p.Payload...)
+	c.stats.BytesReceived += int64(len(p.Payload))
+}
+
+// checkFinished 应用关闭后，双方的 FIN 都被确认时只再保留两个超时用于重新确认对方重传的 FIN
+func (c *Conn) checkFinished(now time.Time) {
+	if c.appClosed && c.finAcked && c.peerFin {
+		if linger := now.Add(2 * c.cfg.Timeout); linger.Before(c.releaseTimer.at) {
+			c.schedule(c.releaseTimer, linger)
+		}
+	}
+}
+
+// fail 因错误终止连接
+func (c *Conn) fail(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+	c.logf("connection failed: %v", err)
+	c.shutdown(err)
+}
+
+// shutdown 释放连接：停止计时器，从 Listener 注销或关闭独占的 socket
+func (c *Conn) shutdown(err error) {
+	if c.state == stateClosed {
+		return
+	}
+	c.state = stateClosed
+	if c.err == nil {
+		c.err = err
+	}
+	close(c.done)
+	c.cond.Broadcast()
+	go c.release.Do(func() {
+		if c.onRelease != nil {
+			c.onRelease()
+		}
+		if c.ownsSocket {
+			_ = c.pc.Close()
+		}
+	})
+}
+
+// wait 等待状态变化，deadline 非零时最多等到该时刻
+func (c *Conn) wait(deadline time.Time) {
+	if !deadline.IsZero() {
+		t := time.AfterFunc(time.Until(deadline), func() {
+			c.mu.Lock()
+			c.cond.Broadcast()
+			c.mu.Unlock()
+		})
+		defer t.Stop()
+	}
+	c.cond.Wait()
+}
+
+// Read 读取按序到达的数据，对方关闭后返回 io.EOF
+func (c *Conn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.readBuf) == 0 {
+		switch {
+		case c.appClosed:
+			return 0, net.ErrClosed
+		case c.peerFin:
+			return 0, io.EOF
+		case c.err != nil:
+			return 0, c.err
+		case c.state == stateClosed:
+			return 0, net.ErrClosed
+		case !c.readDeadline.IsZero() && !time.Now().Before(c.readDeadline):
+			return 0, os.ErrDeadlineExceeded
+		}
+		c.wait(c.readDeadline)
+	}
+	n := copy(b, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	if len(c.readBuf) == 0 {
+		c.readBuf = nil
+	}
+	c.maybeUpdateWindow(time.Now())
+	return n, nil
+}
+
+// Write 把数据放入发送缓存后返回，缓存满时阻塞，数据由协议在窗口允许时发送
+func (c *Conn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for n < len(b) {
+		switch {
+		case c.appClosed || c.closing:
+			return n, net.ErrClosed
+		case c.err != nil:
+			return n, c.err
+		case c.state == stateClosed:
+			return n, net.ErrClosed
+		case !c.writeDeadline.IsZero() && !time.Now().Before(c.writeDeadline):
+			return n, os.ErrDeadlineExceeded
+		}
+		space := c.cfg.SendBuffer - len(c.sendQueue)
+		if space <= 0 {
+			c.wait(c.writeDeadline)
+			continue
+		}
+		k := min(space, len(b)-n)
+		c.sendQueue = append(c.sendQueue, b[n:n+k]...)
+		n += k
+		c.fillWindow(time.Now())
+	}
+	return n, nil
+}
+
+// Close 发送完缓存的数据后发送 FIN，等待 FIN 被确认后返回。
+// 连接随后再保留一段时间，用于确认对方的 FIN
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.appClosed {
+		return net.ErrClosed
+	}
+	err := c.closeWriteLocked()
+	c.appClosed = true
+	now := time.Now()
+	if c.state != stateClosed {
+		c.schedule(c.releaseTimer, now.Add(c.cfg.CloseTimeout))
+	}
+	c.checkFinished(now)
+	c.cond.Broadcast()
+	if errors.Is(err, net.ErrClosed) {
+		// 已经调用过 CloseWrite
+		err = nil
+	}
+	return err
+}
+
+// CloseWrite 关闭发送方向：发送完缓存的数据后发送 FIN，等待 FIN 被确认后返回，
+// 之后仍然可以读取对方的数据
+func (c *Conn) CloseWrite() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.appClosed {
+		return net.ErrClosed
+	}
+	return c.closeWriteLocked()
+}
+
+func (c *Conn) closeWriteLocked() error {
+	if c.closing {
+		return net.ErrClosed
+	}
+	c.closing = true
+	c.fillWindow(time.Now())
+	for c.state == stateEstablished && !c.finAcked {
+		c.wait(time.Time{})
+	}
+	return c.err
+}
+
+// LocalAddr 返回本地 UDP 地址
+func (c *Conn) LocalAddr() net.Addr { return c.pc.LocalAddr() }
+
+// RemoteAddr 返回对方的 UDP 地址
+func (c *Conn) RemoteAddr() net.Addr { return c.raddr }
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline, c.writeDeadline = t, t
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writeDeadline = t
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	return nil
+}
+
+// Protocol 返回连接使用的重传策略
+func (c *Conn) Protocol() Protocol { return c.cfg.Protocol }
+
+// Done 返回一个在连接释放后关闭的 channel。Close 返回后连接还会保留一段时间，
+// 用于确认对方重传的 FIN，程序退出前应等待它关闭，否则对方可能收不到最后的确认
+func (c *Conn) Done() <-chan struct{} { return c.done }
+
+// Stats 返回连接统计的快照
+func (c *Conn) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.SRTT, s.RTTVar, s.RTO, s.RTTSamples = c.rtt.srtt, c.rtt.rttvar, c.rtt.rto(), c.rtt.samples
+	if c.cc != nil {
+		s.Cwnd, s.Ssthresh, s.FastRetransmits = c.cc.cwnd, c.cc.ssthresh, c.cc.fastRetransmits
+	}
+	s.PeerWindow = c.peerWnd
+	return s
+}
+
+// CongestionTrace 返回拥塞窗口和慢启动阈值随时间变化的轨迹，
+// 没有拥塞控制或没有设置 Config.TraceCongestion 时为空
+func (c *Conn) CongestionTrace() []CongestionSample {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cc == nil {
+		return nil
+	}
+	return append([]CongestionSample(nil), c.cc.trace...)
+}
+
+func (c *Conn) countCorrupt() {
+	c.mu.Lock()
+	c.stats.CorruptPackets++
+	c.mu.Unlock()
+}
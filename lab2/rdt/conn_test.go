@@ -0,0 +1,445 @@
+package rdt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lossyConn 按固定种子随机丢弃或损坏发出的数据报，模拟不可靠信道
+type lossyConn struct {
+	net.PacketConn
+	mu      sync.Mutex
+	rng     *rand.Rand
+	loss    float64
+	corrupt float64
+	dropped int
+}
+
+func newLossyConn(t *testing.T, seed int64, loss, corrupt float64) *lossyConn {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &lossyConn{PacketConn: pc, rng: rand.New(rand.NewSource(seed)), loss: loss, corrupt: corrupt}
+}
+
+func (l *lossyConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	l.mu.Lock()
+	drop := l.rng.Float64() < l.loss
+	flip := !drop && l.rng.Float64() < l.corrupt
+	pos := l.rng.Intn(len(b))
+	if drop {
+		l.dropped++
+	}
+	l.mu.Unlock()
+	if drop {
+		return len(b), nil
+	}
+	if flip {
+		b = append([]byte(nil), b...)
+		b[pos] ^= 0x10
+	}
+	return l.PacketConn.WriteTo(b, addr)
+}
+
+// testPayload 生成包含任意字节的测试数据
+func testPayload(n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(b)
+	return b
+}
+
+// transfer 通过一对连接双向传输数据并检查收到的内容
+func transfer(t *testing.T, cfg Config, loss, corrupt float64, size int) (client, server Stats) {
+	t.Helper()
+	lpc := newLossyConn(t, 1, loss, corrupt)
+	l, err := NewListener(lpc, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	up, down := testPayload(size), testPayload(size/2+1)
+	done := make(chan Stats, 1)
+	go func() {
+		nc, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+			done <- Stats{}
+			return
+		}
+		c := nc.(*Conn)
+		got, err := io.ReadAll(c)
+		if err != nil {
+			t.Errorf("server read: %v", err)
+		}
+		if !bytes.Equal(got, up) {
+			t.Errorf("server received %d bytes, want %d", len(got), len(up))
+		}
+		if _, err := c.Write(down); err != nil {
+			t.Errorf("server write: %v", err)
+		}
+		if err := c.Close(); err != nil {
+			t.Errorf("server close: %v", err)
+		}
+		done <- c.Stats()
+	}()
+
+	dpc := newLossyConn(t, 2, loss, corrupt)
+	c, err := DialPacket(dpc, l.Addr(), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dpc.Close()
+	if _, err := c.Write(up); err != nil {
+		t.Fatal(err)
+	}
+	// 半关闭：发送 FIN 后仍然可以读取对方的数据
+	if err := c.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatalf("client read: %v", err)
+	}
+	if !bytes.Equal(got, down) {
+		t.Errorf("client received %d bytes, want %d", len(got), len(down))
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("client close: %v", err)
+	}
+	return c.Stats(), <-done
+}
+
+func TestTransfer(t *testing.T) {
+	fast := 30 * time.Millisecond
+	tests := []struct {
+		name          string
+		cfg           Config
+		loss, corrupt float64
+	}{
+		{"gbn", Config{Protocol: GBN, Window: 8, Timeout: fast, MSS: 512}, 0, 0},
+		{"sr", Config{Protocol: SR, Window: 8, Timeout: fast, MSS: 512}, 0, 0},
+		{"saw", Config{Protocol: StopAndWait, Timeout: fast, MSS: 512}, 0, 0},
+		{"gbn lossy", Config{Protocol: GBN, Window: 8, Timeout: fast, MSS: 512}, 0.1, 0.05},
+		{"sr lossy", Config{Protocol: SR, Window: 8, Timeout: fast, MSS: 512}, 0.1, 0.05},
+		{"saw lossy", Config{Protocol: StopAndWait, Timeout: fast, MSS: 512}, 0.1, 0.05},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := transfer(t, tt.cfg, tt.loss, tt.corrupt, 20000)
+			if tt.loss > 0 && client.Retransmissions == 0 && server.Retransmissions == 0 {
+				t.Errorf("no retransmissions on a lossy channel: %+v %+v", client, server)
+			}
+			if tt.corrupt > 0 && client.CorruptPackets+server.CorruptPackets == 0 {
+				t.Errorf("no corrupt packets counted: %+v %+v", client, server)
+			}
+			if tt.loss == 0 && client.Retransmissions != 0 {
+				t.Errorf("retransmissions without loss: %+v", client)
+			}
+		})
+	}
+}
+
+// TestListenerProtocol 接受方使用发起方在 SYN 中声明的协议和较小的窗口
+func TestListenerProtocol(t *testing.T) {
+	l, err := Listen("udp", "127.0.0.1:0", &Config{Protocol: GBN, Window: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c, err := Dial("udp", l.Addr().String(), &Config{Protocol: SR, Window: 4, Timeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	nc, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := nc.(*Conn)
+	if s.Protocol() != SR || s.window != 4 {
+		t.Errorf("accepted %v window %d, want sr window 4", s.Protocol(), s.window)
+	}
+	// Dial 绑定通配地址，只比较端口
+	if s.RemoteAddr().(*net.UDPAddr).Port != c.LocalAddr().(*net.UDPAddr).Port {
+		t.Errorf("remote %v, want %v", s.RemoteAddr(), c.LocalAddr())
+	}
+}
+
+// TestWindowNegotiation 发起方的窗口大于监听方时，两端都使用较小的窗口
+func TestWindowNegotiation(t *testing.T) {
+	for _, proto := range []Protocol{GBN, SR} {
+		t.Run(proto.String(), func(t *testing.T) {
+			l, err := Listen("udp", "127.0.0.1:0", &Config{Window: 4})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			want := testPayload(20000)
+			got := make(chan []byte, 1)
+			accepted := make(chan *Conn, 1)
+			go func() {
+				nc, err := l.Accept()
+				if err != nil {
+					got <- nil
+					accepted <- nil
+					return
+				}
+				accepted <- nc.(*Conn)
+				b, _ := io.ReadAll(nc)
+				got <- b
+			}()
+
+			c, err := Dial("udp", l.Addr().String(), &Config{Protocol: proto, Window: 16, Timeout: 50 * time.Millisecond})
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := <-accepted
+			if s == nil {
+				t.Fatal("accept failed")
+			}
+			defer s.Close()
+			c.mu.Lock()
+			cw := c.window
+			c.mu.Unlock()
+			if cw != 4 || s.window != 4 {
+				t.Errorf("dialer window %d, listener window %d, want 4", cw, s.window)
+			}
+			if _, err := c.Write(want); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if b := <-got; !bytes.Equal(b, want) {
+				t.Fatalf("received %d bytes, want %d", len(b), len(want))
+			}
+		})
+	}
+}
+
+func TestDeadlines(t *testing.T) {
+	l, err := Listen("udp", "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c, err := Dial("udp", l.Addr().String(), &Config{Timeout: 50 * time.Millisecond, SendBuffer: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	_ = c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := c.Read(make([]byte, 10)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("read: %v", err)
+	}
+	_ = c.SetWriteDeadline(time.Now().Add(-time.Second))
+	if _, err := c.Write([]byte("x")); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("write: %v", err)
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	// 没有人监听的地址：握手重试耗尽后返回错误
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := pc.LocalAddr().String()
+	pc.Close()
+	_, err = Dial("udp", addr, &Config{Timeout: 10 * time.Millisecond, MaxRetries: 3})
+	if !errors.Is(err, ErrHandshake) {
+		t.Errorf("dial: %v", err)
+	}
+}
+
+func TestPeerTimeout(t *testing.T) {
+	l, err := Listen("udp", "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := Dial("udp", l.Addr().String(), &Config{Timeout: 10 * time.Millisecond, MaxRetries: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 对方消失后，重传次数耗尽，Write 和 Read 返回错误
+	l.Close()
+	l.pc.Close()
+	_, _ = c.Write([]byte("hello"))
+	_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.Read(make([]byte, 5)); !errors.Is(err, ErrPeerTimeout) {
+		t.Errorf("read: %v", err)
+	}
+}
+
+// TestFullDuplex 双方同时发送和接收大量数据
+func TestFullDuplex(t *testing.T) {
+	for _, proto := range []Protocol{GBN, SR} {
+		t.Run(proto.String(), func(t *testing.T) {
+			cfg := Config{Protocol: proto, Window: 8, SeqBits: 4, Timeout: 40 * time.Millisecond, MSS: 256}
+			l, err := NewListener(newLossyConn(t, 3, 0.05, 0.02), &cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			dpc := newLossyConn(t, 4, 0.05, 0.02)
+			defer dpc.Close()
+			client, err := DialPacket(dpc, l.Addr(), &cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			nc, err := l.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			server := nc.(*Conn)
+
+			// 每一方在一个 goroutine 中发送，同时在另一个 goroutine 中接收
+			exchange := func(c *Conn, out []byte, in chan<- []byte) {
+				sent := make(chan struct{})
+				go func() {
+					defer close(sent)
+					if _, err := c.Write(out); err != nil {
+						t.Errorf("write: %v", err)
+					}
+					if err := c.CloseWrite(); err != nil {
+						t.Errorf("close write: %v", err)
+					}
+				}()
+				got, err := io.ReadAll(c)
+				if err != nil {
+					t.Errorf("read: %v", err)
+				}
+				<-sent
+				in <- got
+			}
+			up, down := testPayload(30000), testPayload(25000)
+			gotUp, gotDown := make(chan []byte, 1), make(chan []byte, 1)
+			go exchange(client, up, gotDown)
+			go exchange(server, down, gotUp)
+			if got := <-gotUp; !bytes.Equal(got, up) {
+				t.Errorf("server received %d bytes, want %d", len(got), len(up))
+			}
+			if got := <-gotDown; !bytes.Equal(got, down) {
+				t.Errorf("client received %d bytes, want %d", len(got), len(down))
+			}
+			for _, c := range []*Conn{client, server} {
+				if err := c.Close(); err != nil {
+					t.Errorf("close: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// TestPiggybackedAcks 一问一答时，确认由回应的数据报文捎带，不再单独发送 ACK
+func TestPiggybackedAcks(t *testing.T) {
+	for _, proto := range []Protocol{GBN, SR} {
+		t.Run(proto.String(), func(t *testing.T) {
+			cfg := Config{Protocol: proto, Timeout: 500 * time.Millisecond, AckDelay: 100 * time.Millisecond}
+			l, err := Listen("udp", "127.0.0.1:0", &cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			client, err := Dial("udp", l.Addr().String(), &cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			nc, err := l.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			server := nc.(*Conn)
+			go func() {
+				_, _ = io.Copy(server, server) // 回显
+				_ = server.Close()
+			}()
+
+			const rounds = 20
+			msg, reply := testPayload(100), make([]byte, 100)
+			for range rounds {
+				if _, err := client.Write(msg); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := io.ReadFull(client, reply); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(reply, msg) {
+					t.Fatal("echo mismatch")
+				}
+			}
+			s := server.Stats()
+			if s.PiggybackedAcks < rounds-1 || s.AcksSent > 2 {
+				t.Errorf("server: %d piggybacked, %d separate acks", s.PiggybackedAcks, s.AcksSent)
+			}
+			if err := client.Close(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestDelayedAck 没有反向数据时，接收方在 AckDelay 之后单独确认
+func TestDelayedAck(t *testing.T) {
+	cfg := Config{Timeout: time.Second, AckDelay: 150 * time.Millisecond}
+	l, err := Listen("udp", "127.0.0.1:0", &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c, err := Dial("udp", l.Addr().String(), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	nc, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := nc.(*Conn)
+
+	start := time.Now()
+	if _, err := c.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(server, buf); err != nil {
+		t.Fatal(err)
+	}
+	if n := server.Stats().AcksSent; n != 0 {
+		t.Fatalf("acknowledged immediately (%d acks)", n)
+	}
+	// 确认到达后发送方的窗口清空
+	for {
+		c.mu.Lock()
+		pending := len(c.segs)
+		c.mu.Unlock()
+		if pending == 0 {
+			break
+		}
+		if time.Since(start) > cfg.Timeout {
+			t.Fatal("data was not acknowledged before the retransmission timeout")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if d := time.Since(start); d < cfg.AckDelay {
+		t.Errorf("acknowledged after %v, want at least %v", d, cfg.AckDelay)
+	}
+	if s := server.Stats(); s.AcksSent != 1 {
+		t.Errorf("acks sent %d, want 1", s.AcksSent)
+	}
+	if s := c.Stats(); s.Retransmissions != 0 {
+		t.Errorf("retransmissions %d", s.Retransmissions)
+	}
+}
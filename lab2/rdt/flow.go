@@ -0,0 +1,113 @@
+package rdt
+
+import "time"
+
+// 流量控制：接收方在每个 ACK、DATA 和 FIN 报文的窗口字段中通告接收缓存的剩余空间（字节），
+// 即从确认号开始还能接收多少数据；发送方在途的数据不超过最近一次通告的窗口。
+// 窗口为零时对方不会再发送确认，发送方由持续计时器定时发送不带数据的窗口探测，
+// 接收方立即回应当前的窗口；应用读取数据使窗口重新打开时，接收方主动发送窗口更新
+
+// recvWindow 返回通告给对方的接收窗口：接收缓存中 Read 尚未取走的数据之外的空间。
+// 剩余空间不到 min(RecvBuffer/2, MSS) 时通告 0，避免对方发送很小的报文（糊涂窗口综合症）
+func (c *Conn) recvWindow() int {
+	free := c.cfg.RecvBuffer - len(c.readBuf)
+	if free < min(c.cfg.RecvBuffer/2, c.cfg.MSS) {
+		return 0
+	}
+	return free
+}
+
+// advertise 返回本方发出的报文中通告的窗口，并记录下来用于判断是否需要窗口更新
+func (c *Conn) advertise() uint16 {
+	c.lastWnd = c.recvWindow()
+	return uint16(c.lastWnd)
+}
+
+// overflows 判断接收缓存是否放不下数据报文 p。
+// 窗口之外的报文和 SR 已经缓存的报文不占用新的空间
+func (c *Conn) overflows(p Packet) bool {
+	if len(p.Payload) == 0 || !c.seq.InWindow(p.Seq, c.rcvNxt, c.window) {
+		return false
+	}
+	if _, ok := c.ooo[p.Seq]; ok {
+		return false
+	}
+	used := len(c.readBuf)
+	for _, q := range c.ooo {
+		used += len(q.Payload)
+	}
+	return used+len(p.Payload) > c.cfg.RecvBuffer
+}
+
+// updatePeerWindow 记录对方通告的接收窗口。确认号落在已确认报文之前的报文是乱序到达的旧报文，
+// 它的窗口已经过时，忽略
+func (c *Conn) updatePeerWindow(p Packet) {
+	base := c.sndNxt
+	if len(c.segs) > 0 {
+		base = c.segs[0].seq
+	}
+	if c.seq.Distance(base, p.Ack) > uint32(len(c.segs)) {
+		return
+	}
+	c.peerWnd = int(p.Window)
+}
+
+// blocked 对方的接收窗口放不下下一个报文。还有在途的报文时等待它们的确认带来新的窗口，
+// 否则启动持续计时器
+func (c *Conn) blocked(now time.Time) {
+	if !c.stalled {
+		c.stalled = true
+		c.stats.WindowStalls++
+	}
+	if len(c.segs) > 0 || c.persistTimer.active() {
+		return
+	}
+	c.logf("peer window %d full, probing in %v", c.peerWnd, c.persistInterval())
+	c.schedule(c.persistTimer, now.Add(c.persistInterval()))
+}
+
+// persistInterval 返回下一次窗口探测的等待时间：从 RTO 开始每次探测加倍，不超过 MaxRTO。
+// 对方的应用可能很久不读取数据，所以探测没有次数限制
+func (c *Conn) persistInterval() time.Duration {
+	d := c.rtt.rto()
+	for i := 0; i < c.probes && d < c.cfg.MaxRTO; i++ {
+		d *= 2
+	}
+	return min(d, c.cfg.MaxRTO)
+}
+
+// probeWindow 持续计时器到期：发送一个不带数据的 DATA 报文，序列号为已确认的最后一个报文，
+// 接收方不交付它，只回应一个携带当前窗口的 ACK
+func (c *Conn) probeWindow(now time.Time) {
+	if c.state != stateEstablished || len(c.segs) > 0 {
+		return
+	}
+	c.stats.WindowProbes++
+	c.probes++
+	p := Packet{Type: TypeData, Seq: c.seq.Add(c.sndNxt, -1), Ack: c.rcvNxt, Window: c.advertise()}
+	c.logf("send window probe %v", p)
+	c.writePacket(p)
+	c.schedule(c.persistTimer, now.Add(c.persistInterval()))
+}
+
+// windowOpened 发送方的窗口重新打开并发送了新的报文，停止探测
+func (c *Conn) windowOpened() {
+	c.cancel(c.persistTimer)
+	c.probes = 0
+	c.stalled = false
+}
+
+// maybeUpdateWindow Read 取走数据后，如果上次通告的窗口为零或者窗口增大了半个接收缓存，
+// 立即发送窗口更新，不等对方探测
+func (c *Conn) maybeUpdateWindow(now time.Time) {
+	if c.state != stateEstablished || c.peerFin {
+		return
+	}
+	w := c.recvWindow()
+	if w == 0 || (c.lastWnd > 0 && w-c.lastWnd < c.cfg.RecvBuffer/2) {
+		return
+	}
+	c.stats.WindowUpdates++
+	c.logf("window update: %d bytes free", w)
+	c.sendAck(c.seq.Add(c.rcvNxt, -1), now)
+}
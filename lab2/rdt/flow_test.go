@@ -0,0 +1,160 @@
+package rdt
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestRecvWindow(t *testing.T) {
+	cases := []struct {
+		buffer, mss, buffered int
+		want                  int
+	}{
+		{buffer: 4000, mss: 500, buffered: 0, want: 4000},
+		{buffer: 4000, mss: 500, buffered: 3500, want: 500},
+		// 剩余空间不到一个 MSS 时通告零窗口
+		{buffer: 4000, mss: 500, buffered: 3600, want: 0},
+		{buffer: 4000, mss: 500, buffered: 4000, want: 0},
+		// 缓存小于 MSS 时以半个缓存为界
+		{buffer: 300, mss: 500, buffered: 100, want: 200},
+		{buffer: 300, mss: 500, buffered: 200, want: 0},
+	}
+	for _, tc := range cases {
+		c := newConn(nil, nil, Config{RecvBuffer: tc.buffer, MSS: tc.mss})
+		c.readBuf = make([]byte, tc.buffered)
+		if got := c.recvWindow(); got != tc.want {
+			t.Errorf("buffer %d mss %d with %d bytes buffered: window %d, want %d", tc.buffer, tc.mss, tc.buffered, got, tc.want)
+		}
+	}
+	if err := (&Config{RecvBuffer: 1 << 16}).Validate(); err == nil {
+		t.Error("receive buffer larger than the window field accepted")
+	}
+}
+
+// slowRead 每次最多读取 chunk 字节，每次之间等待 pause；读取前检查接收缓存没有超过 RecvBuffer
+func slowRead(t *testing.T, c *Conn, chunk int, pause time.Duration) []byte {
+	var out []byte
+	buf := make([]byte, chunk)
+	for {
+		c.mu.Lock()
+		if n := len(c.readBuf); n > c.cfg.RecvBuffer {
+			t.Errorf("%d bytes buffered, receive buffer is %d", n, c.cfg.RecvBuffer)
+		}
+		c.mu.Unlock()
+		n, err := c.Read(buf)
+		out = append(out, buf[:n]...)
+		if err == io.EOF {
+			return out
+		}
+		if err != nil {
+			t.Error(err)
+			return out
+		}
+		time.Sleep(pause)
+	}
+}
+
+// TestSlowConsumer 接收方的应用读得很慢，发送方按通告的窗口停下，不会丢弃或重传数据
+func TestSlowConsumer(t *testing.T) {
+	for _, proto := range []Protocol{GBN, SR} {
+		t.Run(proto.String(), func(t *testing.T) {
+			// 立即确认，让确认中的窗口随接收缓存填满而减小到零，之后由窗口更新重新打开
+			cfg := Config{Protocol: proto, Window: 32, Timeout: 200 * time.Millisecond, AckDelay: -1, MSS: 500, RecvBuffer: 2000}
+			l, err := Listen("udp", "127.0.0.1:0", &cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			want := testPayload(40000)
+			got := make(chan []byte, 1)
+			server := make(chan Stats, 1)
+			go func() {
+				nc, err := l.Accept()
+				if err != nil {
+					got <- nil
+					server <- Stats{}
+					return
+				}
+				c := nc.(*Conn)
+				got <- slowRead(t, c, 700, 10*time.Millisecond)
+				_ = c.Close()
+				server <- c.Stats()
+			}()
+
+			c, err := Dial("udp", l.Addr().String(), &cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.Write(want); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if b := <-got; !bytes.Equal(b, want) {
+				t.Fatalf("received %d bytes, want %d", len(b), len(want))
+			}
+			cs, ss := c.Stats(), <-server
+			t.Logf("sender: %d stalls, %d probes, peer window %d; receiver: %d window updates, %d acks",
+				cs.WindowStalls, cs.WindowProbes, cs.PeerWindow, ss.WindowUpdates, ss.AcksSent)
+			if ss.WindowDrops != 0 {
+				t.Errorf("receiver dropped %d packets for lack of buffer", ss.WindowDrops)
+			}
+			if ss.WindowUpdates == 0 || cs.WindowStalls == 0 {
+				t.Errorf("sender never stalled on the receive window: %+v", cs)
+			}
+			if cs.Retransmissions != 0 {
+				t.Errorf("%d retransmissions", cs.Retransmissions)
+			}
+		})
+	}
+}
+
+// TestZeroWindowProbe 接收方长时间不读取，发送方定时探测窗口；
+// 探测不受重传次数的限制，应用恢复读取后传输继续
+func TestZeroWindowProbe(t *testing.T) {
+	cfg := Config{Protocol: SR, Window: 8, Timeout: 50 * time.Millisecond, MinRTO: 50 * time.Millisecond,
+		AckDelay: -1, MSS: 500, RecvBuffer: 1000, MaxRetries: 2}
+	l, err := Listen("udp", "127.0.0.1:0", &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	want := testPayload(5000)
+	got := make(chan []byte, 1)
+	go func() {
+		nc, err := l.Accept()
+		if err != nil {
+			got <- nil
+			return
+		}
+		time.Sleep(time.Second)
+		b, _ := io.ReadAll(nc)
+		_ = nc.Close()
+		got <- b
+	}()
+
+	c, err := Dial("udp", l.Addr().String(), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write(want); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if b := <-got; !bytes.Equal(b, want) {
+		t.Fatalf("received %d bytes, want %d", len(b), len(want))
+	}
+	s := c.Stats()
+	t.Logf("%d probes, %d stalls, rto %v", s.WindowProbes, s.WindowStalls, s.RTO)
+	if s.WindowProbes <= int64(cfg.MaxRetries) {
+		t.Errorf("%d window probes while the receiver was not reading", s.WindowProbes)
+	}
+	if s.Retransmissions != 0 {
+		t.Errorf("%d retransmissions", s.Retransmissions)
+	}
+}
@@ -0,0 +1,3 @@
+module rdt
+
+go 1.23.2
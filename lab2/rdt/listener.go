@@ -0,0 +1,291 @@
+package rdt
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// Listener 在一个 UDP socket 上接受多个可靠连接，按对方地址区分连接
+type Listener struct {
+	pc  net.PacketConn
+	cfg Config
+
+	mu     sync.Mutex
+	conns  map[string]*Conn
+	closed bool
+
+	accept chan *Conn
+	done   chan struct{}
+}
+
+// Listen 在 UDP 地址 addr 上监听，network 为 "udp"、"udp4" 或 "udp6"
+func Listen(network, addr string, cfg *Config) (*Listener, error) {
+	c, err := cfg.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	pc, err := net.ListenPacket(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return newListener(pc, c), nil
+}
+
+// NewListener 在已有的 socket 上接受连接，Listener 关闭后负责关闭 pc
+func NewListener(pc net.PacketConn, cfg *Config) (*Listener, error) {
+	c, err := cfg.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	return newListener(pc, c), nil
+}
+
+func newListener(pc net.PacketConn, cfg Config) *Listener {
+	l := &Listener{
+		pc:     pc,
+		cfg:    cfg,
+		conns:  make(map[string]*Conn),
+		accept: make(chan *Conn, 16),
+		done:   make(chan struct{}),
+	}
+	go l.readLoop()
+	return l
+}
+
+func (l *Listener) readLoop() {
+	buf := make([]byte, HeaderSize+MaxPayloadSize)
+	for {
+		n, addr, err := l.pc.ReadFrom(buf)
+		if err != nil {
+			l.mu.Lock()
+			closed := l.closed
+			l.mu.Unlock()
+			if closed || !isTemporary(err) {
+				l.abort(err)
+				return
+			}
+			continue
+		}
+		// Payload 引用读缓冲区，SR 可能缓存报文，所以每个报文使用独立的副本
+		b := append([]byte(nil), buf[:n]...)
+		p, err := Decode(b)
+		l.mu.Lock()
+		c := l.conns[addr.String()]
+		if err != nil {
+			l.mu.Unlock()
+			if c != nil {
+				c.countCorrupt()
+			}
+			continue
+		}
+		if c == nil && p.Type == TypeSyn && !l.closed {
+			c = l.newConn(p, addr)
+		}
+		l.mu.Unlock()
+		if c != nil {
+			c.handle(p, time.Now())
+		}
+	}
+}
+
+// newConn 为对方的 SYN 创建连接，使用对方声明的协议和序列号位数，窗口取双方的较小值，
+// 对方的接收缓存作为最初的接收窗口
+func (l *Listener) newConn(syn Packet, addr net.Addr) *Conn {
+	cfg := l.cfg
+	if len(syn.Payload) > 0 {
+		cfg.Protocol = Protocol(syn.Payload[0])
+	}
+	if len(syn.Payload) > 1 {
+		cfg.SeqBits = int(syn.Payload[1])
+	}
+	if syn.Window > 0 && int(syn.Window) < cfg.Window {
+		cfg.Window = int(syn.Window)
+	}
+	cfg, err := cfg.withDefaults()
+	if err != nil {
+		return nil
+	}
+	c := newConn(l.pc, addr, cfg)
+	c.state = stateEstablished
+	c.peerWnd = synRecvBuffer(syn)
+	key := addr.String()
+	c.onRelease = func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.conns[key] == c {
+			delete(l.conns, key)
+		}
+		l.maybeCloseSocketLocked()
+	}
+	select {
+	case l.accept <- c:
+	default:
+		// Accept 队列已满，忽略 SYN，等待对方重传
+		return nil
+	}
+	l.conns[key] = c
+	go c.timerLoop()
+	return c
+}
+
+// Accept 等待并返回下一个连接
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close 停止接受新连接。已建立的连接继续工作，全部释放后关闭 socket
+func (l *Listener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return net.ErrClosed
+	}
+	l.closed = true
+	close(l.done)
+	// 丢弃尚未被 Accept 的连接
+	for {
+		select {
+		case c := <-l.accept:
+			delete(l.conns, c.raddr.String())
+			go func() {
+				c.mu.Lock()
+				c.shutdown(nil)
+				c.mu.Unlock()
+			}()
+			continue
+		default:
+		}
+		break
+	}
+	l.maybeCloseSocketLocked()
+	return nil
+}
+
+func (l *Listener) maybeCloseSocketLocked() {
+	if l.closed && len(l.conns) == 0 {
+		_ = l.pc.Close()
+	}
+}
+
+// abort socket 出错时终止所有连接
+func (l *Listener) abort(err error) {
+	l.mu.Lock()
+	conns := make([]*Conn, 0, len(l.conns))
+	for _, c := range l.conns {
+		conns = append(conns, c)
+	}
+	if !l.closed {
+		l.closed = true
+		close(l.done)
+	}
+	l.mu.Unlock()
+	for _, c := range conns {
+		c.mu.Lock()
+		c.fail(err)
+		c.mu.Unlock()
+	}
+}
+
+// Addr 返回监听的 UDP 地址
+func (l *Listener) Addr() net.Addr { return l.pc.LocalAddr() }
+
+// Dial 连接到 UDP 地址 addr，握手完成后返回
+func Dial(network, addr string, cfg *Config) (*Conn, error) {
+	raddr, err := net.ResolveUDPAddr(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := net.ListenUDP(network, nil)
+	if err != nil {
+		return nil, err
+	}
+	c, err := dial(pc, raddr, cfg, true)
+	if err != nil {
+		_ = pc.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// DialPacket 在已有的 socket 上连接 raddr，连接释放后不关闭 pc。
+// 连接存在期间由它读取 pc
+func DialPacket(pc net.PacketConn, raddr net.Addr, cfg *Config) (*Conn, error) {
+	return dial(pc, raddr, cfg, false)
+}
+
+func dial(pc net.PacketConn, raddr net.Addr, cfg *Config, ownsSocket bool) (*Conn, error) {
+	conf, err := cfg.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	c := newConn(pc, raddr, conf)
+	c.client = true
+	c.ownsSocket = ownsSocket
+	go c.timerLoop()
+	go c.readLoop()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state = stateSynSent
+	c.sendSyn(time.Now())
+	for c.state == stateSynSent {
+		c.wait(time.Time{})
+	}
+	if c.state != stateEstablished {
+		return nil, c.err
+	}
+	return c, nil
+}
+
+// readLoop Dial 一方读取 socket，只处理来自 raddr 的报文
+func (c *Conn) readLoop() {
+	buf := make([]byte, HeaderSize+MaxPayloadSize)
+	want := c.raddr.String()
+	for {
+		if !c.ownsSocket {
+			// 共享的 socket 不能靠关闭来唤醒读取，定期检查连接是否已释放
+			_ = c.pc.SetReadDeadline(time.Now().Add(c.cfg.Timeout))
+		}
+		n, addr, err := c.pc.ReadFrom(buf)
+		select {
+		case <-c.done:
+			return
+		default:
+		}
+		if err != nil {
+			if isTemporary(err) {
+				continue
+			}
+			c.mu.Lock()
+			c.fail(err)
+			c.mu.Unlock()
+			return
+		}
+		if addr.String() != want {
+			continue
+		}
+		b := append([]byte(nil), buf[:n]...)
+		p, err := Decode(b)
+		if err != nil {
+			c.countCorrupt()
+			continue
+		}
+		c.handle(p, time.Now())
+	}
+}
+
+// isTemporary 判断读取错误是否可以忽略（超时或 ICMP 端口不可达等）
+func isTemporary(err error) bool {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return true
+	}
+	_, ok := err.(*net.OpError)
+	return ok && !errors.Is(err, net.ErrClosed)
+}
@@ -0,0 +1,76 @@
+package rdt
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+)
+
+// LossyPacketConn 在读取时按概率丢弃或损坏收到的数据报，在接收端模拟不可靠信道。
+// 丢包同样作用于数据和确认；使用相同的种子可以重现同一次实验
+type LossyPacketConn struct {
+	net.PacketConn
+	loss    float64
+	corrupt float64
+
+	mu        sync.Mutex
+	rng       *rand.Rand
+	dropped   int64
+	corrupted int64
+
+	// Logf 非空时输出每个丢弃和损坏的数据报
+	Logf func(format string, args ...any)
+}
+
+// NewLossyPacketConn 包装 pc：每个收到的数据报以 loss 的概率被丢弃，
+// 未被丢弃的以 corrupt 的概率翻转一个随机比特
+func NewLossyPacketConn(pc net.PacketConn, loss, corrupt float64, seed int64) *LossyPacketConn {
+	return &LossyPacketConn{
+		PacketConn: pc,
+		loss:       loss,
+		corrupt:    corrupt,
+		rng:        rand.New(rand.NewSource(seed)),
+	}
+}
+
+func (l *LossyPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := l.PacketConn.ReadFrom(b)
+		if err != nil || n == 0 {
+			return n, addr, err
+		}
+		l.mu.Lock()
+		drop := l.rng.Float64() < l.loss
+		flip := !drop && l.rng.Float64() < l.corrupt
+		bit := l.rng.Intn(n * 8)
+		if drop {
+			l.dropped++
+		}
+		if flip {
+			l.corrupted++
+		}
+		l.mu.Unlock()
+		if drop {
+			l.logf("simulated loss: %d bytes from %v", n, addr)
+			continue
+		}
+		if flip {
+			b[bit/8] ^= 1 << (bit % 8)
+			l.logf("simulated corruption: bit %d of %d bytes from %v", bit, n, addr)
+		}
+		return n, addr, nil
+	}
+}
+
+// Counts 返回已丢弃和已损坏的数据报数
+func (l *LossyPacketConn) Counts() (dropped, corrupted int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.dropped, l.corrupted
+}
+
+func (l *LossyPacketConn) logf(format string, args ...any) {
+	if l.Logf != nil {
+		l.Logf(format, args...)
+	}
+}
@@ -0,0 +1,379 @@
+// Package netem 模拟不可靠的网络链路：丢包（伯努利或 Gilbert-Elliott 突发丢包）、
+// 时延和抖动、带宽限制、乱序、重复和比特错误。所有随机决定都来自带种子的随机数生成器，
+// 相同的种子和相同的报文序列得到相同的结果
+package netem
+
+import (
+	"container/heap"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GilbertElliott 两状态突发丢包模型：每个报文先按当前状态的丢包率决定是否丢弃，
+// 再以 P（好→坏）或 R（坏→好）的概率转换状态。平均突发长度为 1/R，
+// LossGood 为 0、LossBad 为 1 时（Gilbert 模型）平均丢包率为 P/(P+R)
+type GilbertElliott struct {
+	P        float64 // 好状态转到坏状态的概率
+	R        float64 // 坏状态转到好状态的概率
+	LossGood float64 // 好状态的丢包率
+	LossBad  float64 // 坏状态的丢包率
+}
+
+// Impairment 一个方向上的链路损伤
+type Impairment struct {
+	Loss         float64         // 伯努利丢包率
+	Burst        *GilbertElliott // 非空时使用突发丢包模型代替 Loss
+	Delay        time.Duration   // 固定传播时延
+	Jitter       time.Duration   // 时延在 [Delay-Jitter, Delay+Jitter] 内均匀分布
+	Rate         int64           // 带宽（比特/秒），0 表示不限制
+	QueueLimit   int             // 带宽受限时发送队列的字节数上限，超出时丢弃（默认 64 KiB）
+	Reorder      float64         // 报文被额外延迟 ReorderDelay、被之后的报文超过的概率
+	ReorderDelay time.Duration   // 乱序报文的额外时延（默认 10ms）
+	Duplicate    float64         // 报文被复制一份的概率
+	Corrupt      float64         // 报文中一个随机比特被翻转的概率
+}
+
+const (
+	defaultQueueLimit   = 64 << 10
+	defaultReorderDelay = 10 * time.Millisecond
+)
+
+// ParseImpairment 解析逗号分隔的损伤描述，例如
+//
+//	loss=0.1,delay=20ms,jitter=5ms,rate=1mbit,reorder=0.05,dup=0.01,corrupt=0.01
+//	ge=0.05/0.3          Gilbert 模型：P=0.05，R=0.3，坏状态全部丢失
+//	ge=0.05/0.3/0.01/0.8 Gilbert-Elliott 模型：好状态丢包率 0.01，坏状态 0.8
+//
+// 空字符串表示没有损伤
+func ParseImpairment(s string) (Impairment, error) {
+	var imp Impairment
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return imp, fmt.Errorf("netem: %q: want key=value", field)
+		}
+		var err error
+		switch strings.ToLower(key) {
+		case "loss":
+			imp.Loss, err = parseProb(value)
+		case "ge", "burst":
+			imp.Burst, err = parseGilbertElliott(value)
+		case "delay":
+			imp.Delay, err = time.ParseDuration(value)
+		case "jitter":
+			imp.Jitter, err = time.ParseDuration(value)
+		case "rate":
+			imp.Rate, err = parseRate(value)
+		case "queue":
+			imp.QueueLimit, err = strconv.Atoi(value)
+		case "reorder":
+			imp.Reorder, err = parseProb(value)
+		case "reorder-delay":
+			imp.ReorderDelay, err = time.ParseDuration(value)
+		case "dup", "duplicate":
+			imp.Duplicate, err = parseProb(value)
+		case "corrupt":
+			imp.Corrupt, err = parseProb(value)
+		default:
+			return imp, fmt.Errorf("netem: unknown impairment %q", key)
+		}
+		if err != nil {
+			return imp, fmt.Errorf("netem: %s: %v", key, err)
+		}
+	}
+	if imp.Delay < 0 || imp.Jitter < 0 || imp.ReorderDelay < 0 || imp.Rate < 0 || imp.QueueLimit < 0 {
+		return imp, fmt.Errorf("netem: negative value in %q", s)
+	}
+	return imp, nil
+}
+
+func (imp Impairment) String() string {
+	var parts []string
+	if imp.Burst != nil {
+		b := imp.Burst
+		parts = append(parts, fmt.Sprintf("ge=%g/%g/%g/%g", b.P, b.R, b.LossGood, b.LossBad))
+	} else if imp.Loss > 0 {
+		parts = append(parts, fmt.Sprintf("loss=%g", imp.Loss))
+	}
+	if imp.Delay > 0 {
+		parts = append(parts, "delay="+imp.Delay.String())
+	}
+	if imp.Jitter > 0 {
+		parts = append(parts, "jitter="+imp.Jitter.String())
+	}
+	if imp.Rate > 0 {
+		parts = append(parts, fmt.Sprintf("rate=%dbit", imp.Rate))
+	}
+	if imp.Reorder > 0 {
+		parts = append(parts, fmt.Sprintf("reorder=%g", imp.Reorder))
+	}
+	if imp.Duplicate > 0 {
+		parts = append(parts, fmt.Sprintf("dup=%g", imp.Duplicate))
+	}
+	if imp.Corrupt > 0 {
+		parts = append(parts, fmt.Sprintf("corrupt=%g", imp.Corrupt))
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ",")
+}
+
+func parseProb(s string) (float64, error) {
+	s, percent := strings.CutSuffix(s, "%")
+	p, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if percent {
+		p /= 100
+	}
+	if p < 0 || p > 1 {
+		return 0, fmt.Errorf("probability %v out of range [0, 1]", p)
+	}
+	return p, nil
+}
+
+func parseGilbertElliott(s string) (*GilbertElliott, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 && len(parts) != 4 {
+		return nil, fmt.Errorf("want P/R or P/R/lossGood/lossBad, got %q", s)
+	}
+	probs := make([]float64, len(parts))
+	for i, part := range parts {
+		p, err := parseProb(part)
+		if err != nil {
+			return nil, err
+		}
+		probs[i] = p
+	}
+	ge := &GilbertElliott{P: probs[0], R: probs[1], LossBad: 1}
+	if len(probs) == 4 {
+		ge.LossGood, ge.LossBad = probs[2], probs[3]
+	}
+	return ge, nil
+}
+
+// parseRate 解析带宽，单位为 bit（默认）、kbit、mbit、gbit
+func parseRate(s string) (int64, error) {
+	lower := strings.ToLower(s)
+	mult := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		mult   int64
+	}{{"gbit", 1e9}, {"mbit", 1e6}, {"kbit", 1e3}, {"bit", 1}} {
+		if rest, ok := strings.CutSuffix(lower, unit.suffix); ok {
+			lower, mult = rest, unit.mult
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(lower, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(v * float64(mult)), nil
+}
+
+// Stats 一个方向的统计
+type Stats struct {
+	Received   int64 // 进入链路的报文数
+	Delivered  int64 // 送达的报文数，包括重复的副本
+	Lost       int64 // 按丢包模型丢弃的报文数
+	QueueDrops int64 // 发送队列已满而丢弃的报文数
+	Corrupted  int64
+	Duplicated int64
+	Reordered  int64
+}
+
+// delivery 一个计划在 at 时刻送达的报文
+type delivery struct {
+	at   time.Time
+	seq  uint64 // 同一时刻送达的报文按进入链路的顺序
+	key  string // 报文所属的会话
+	data []byte
+}
+
+// link 一个方向的链路：admit 按损伤决定每个报文的命运，run 按时间顺序送达
+type link struct {
+	imp Impairment
+
+	mu        sync.Mutex
+	rng       *rand.Rand
+	bad       bool      // Gilbert-Elliott 模型的当前状态
+	busyUntil time.Time // 带宽受限时发送队列清空的时刻
+	seq       uint64
+	queue     deliveryHeap
+	stats     Stats
+	wake      chan struct{}
+
+	logf func(format string, args ...any)
+}
+
+func newLink(imp Impairment, seed int64, logf func(format string, args ...any)) *link {
+	if imp.QueueLimit == 0 {
+		imp.QueueLimit = defaultQueueLimit
+	}
+	if imp.ReorderDelay == 0 {
+		imp.ReorderDelay = defaultReorderDelay
+	}
+	return &link{
+		imp:  imp,
+		rng:  rand.New(rand.NewSource(seed)),
+		wake: make(chan struct{}, 1),
+		logf: logf,
+	}
+}
+
+// admit 决定报文 b 在 now 时刻进入链路后的去向，返回计划送达的副本（丢弃时为空）。
+// 每个报文无论结果如何都消耗相同数量的随机数，使后续报文的决定只取决于种子和报文序号
+func (l *link) admit(b []byte, now time.Time) []delivery {
+	imp := l.imp
+	rLoss, rState, rCorrupt, rBit := l.rng.Float64(), l.rng.Float64(), l.rng.Float64(), l.rng.Intn(max(len(b), 1)*8)
+	rDup, rReorder, rJitter := l.rng.Float64(), l.rng.Float64(), l.rng.Float64()
+	l.stats.Received++
+
+	lost := rLoss < imp.Loss
+	if ge := imp.Burst; ge != nil {
+		if l.bad {
+			lost = rLoss < ge.LossBad
+			l.bad = rState >= ge.R
+		} else {
+			lost = rLoss < ge.LossGood
+			l.bad = rState < ge.P
+		}
+	}
+	if lost {
+		l.stats.Lost++
+		l.log("lost %d bytes", len(b))
+		return nil
+	}
+
+	// 带宽限制：报文排在发送队列之后，队列超过上限时丢弃
+	depart := now
+	if imp.Rate > 0 {
+		start := l.busyUntil
+		if start.Before(now) {
+			start = now
+		}
+		backlog := int64(start.Sub(now).Seconds() * float64(imp.Rate) / 8)
+		if backlog+int64(len(b)) > int64(imp.QueueLimit) {
+			l.stats.QueueDrops++
+			l.log("queue full, dropped %d bytes", len(b))
+			return nil
+		}
+		depart = start.Add(time.Duration(int64(len(b)) * 8 * int64(time.Second) / imp.Rate))
+		l.busyUntil = depart
+	}
+
+	at := depart.Add(imp.Delay)
+	if imp.Jitter > 0 {
+		at = at.Add(time.Duration((2*rJitter - 1) * float64(imp.Jitter)))
+		if at.Before(depart) {
+			at = depart
+		}
+	}
+	if rReorder < imp.Reorder {
+		l.stats.Reordered++
+		at = at.Add(imp.ReorderDelay)
+		l.log("reordering %d bytes", len(b))
+	}
+
+	data := append([]byte(nil), b...)
+	if rCorrupt < imp.Corrupt && len(data) > 0 {
+		l.stats.Corrupted++
+		data[rBit/8] ^= 1 << (rBit % 8)
+		l.log("flipped bit %d of %d bytes", rBit, len(b))
+	}
+	out := []delivery{{at: at, data: data}}
+	if rDup < imp.Duplicate {
+		l.stats.Duplicated++
+		out = append(out, delivery{at: at, data: append([]byte(nil), data...)})
+		l.log("duplicated %d bytes", len(b))
+	}
+	return out
+}
+
+// send 让会话 key 的报文进入链路，到时由 run 送达
+func (l *link) send(key string, b []byte, now time.Time) {
+	l.mu.Lock()
+	for _, d := range l.admit(b, now) {
+		l.seq++
+		d.seq = l.seq
+		d.key = key
+		heap.Push(&l.queue, d)
+	}
+	l.mu.Unlock()
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run 按计划时刻依次调用 deliver，直到 done 关闭
+func (l *link) run(deliver func(key string, b []byte), done <-chan struct{}) {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		l.mu.Lock()
+		var due []delivery
+		now := time.Now()
+		for len(l.queue) > 0 && !l.queue[0].at.After(now) {
+			due = append(due, heap.Pop(&l.queue).(delivery))
+		}
+		l.stats.Delivered += int64(len(due))
+		wait := time.Hour
+		if len(l.queue) > 0 {
+			wait = l.queue[0].at.Sub(now)
+		}
+		l.mu.Unlock()
+		for _, d := range due {
+			deliver(d.key, d.data)
+		}
+		timer.Reset(wait)
+		select {
+		case <-done:
+			return
+		case <-l.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+func (l *link) snapshot() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stats
+}
+
+func (l *link) log(format string, args ...any) {
+	if l.logf != nil {
+		l.logf(format, args...)
+	}
+}
+
+// deliveryHeap 按送达时刻排列的最小堆
+type deliveryHeap []delivery
+
+func (h deliveryHeap) Len() int { return len(h) }
+func (h deliveryHeap) Less(i, j int) bool {
+	if h[i].at.Equal(h[j].at) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].at.Before(h[j].at)
+}
+func (h deliveryHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *deliveryHeap) Push(x any)   { *h = append(*h, x.(delivery)) }
+func (h *deliveryHeap) Pop() any {
+	old := *h
+	d := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return d
+}
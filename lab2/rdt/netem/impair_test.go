@@ -0,0 +1,187 @@
+package netem
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseImpairment(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Impairment
+		wantErr bool
+	}{
+		{"", Impairment{}, false},
+		{"loss=0.1,delay=20ms,jitter=5ms", Impairment{Loss: 0.1, Delay: 20 * time.Millisecond, Jitter: 5 * time.Millisecond}, false},
+		{"loss=5%, dup=0.01 ,corrupt=1%", Impairment{Loss: 0.05, Duplicate: 0.01, Corrupt: 0.01}, false},
+		{"rate=1.5mbit,queue=3000", Impairment{Rate: 1500000, QueueLimit: 3000}, false},
+		{"rate=64kbit", Impairment{Rate: 64000}, false},
+		{"reorder=0.2,reorder-delay=30ms", Impairment{Reorder: 0.2, ReorderDelay: 30 * time.Millisecond}, false},
+		{"ge=0.05/0.3", Impairment{Burst: &GilbertElliott{P: 0.05, R: 0.3, LossBad: 1}}, false},
+		{"ge=0.05/0.3/0.01/0.8", Impairment{Burst: &GilbertElliott{P: 0.05, R: 0.3, LossGood: 0.01, LossBad: 0.8}}, false},
+		{"loss=1.5", Impairment{}, true},
+		{"ge=0.1", Impairment{}, true},
+		{"delay=fast", Impairment{}, true},
+		{"delay=-1ms", Impairment{}, true},
+		{"bogus=1", Impairment{}, true},
+		{"loss", Impairment{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseImpairment(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: err %v", tt.spec, err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+	imp, _ := ParseImpairment("ge=0.05/0.3,delay=20ms,dup=0.01")
+	if again, err := ParseImpairment(imp.String()); err != nil || !reflect.DeepEqual(again, imp) {
+		t.Errorf("round trip %q: %+v %v", imp.String(), again, err)
+	}
+}
+
+// admitAll 让 n 个报文依次进入链路，返回每个报文的送达副本
+func admitAll(l *link, n int, now time.Time) [][]delivery {
+	out := make([][]delivery, n)
+	for i := range out {
+		out[i] = l.admit([]byte{byte(i), 1, 2, 3, 4, 5, 6, 7}, now)
+	}
+	return out
+}
+
+func TestSeedReproducible(t *testing.T) {
+	imp := Impairment{Loss: 0.2, Corrupt: 0.1, Duplicate: 0.1, Reorder: 0.1, Jitter: 5 * time.Millisecond, Delay: 10 * time.Millisecond}
+	now := time.Unix(0, 0)
+	a := admitAll(newLink(imp, 42, nil), 500, now)
+	b := admitAll(newLink(imp, 42, nil), 500, now)
+	if !reflect.DeepEqual(a, b) {
+		t.Fatal("same seed produced different results")
+	}
+	c := admitAll(newLink(imp, 43, nil), 500, now)
+	if reflect.DeepEqual(a, c) {
+		t.Fatal("different seeds produced identical results")
+	}
+}
+
+func TestBernoulliAndEffects(t *testing.T) {
+	imp := Impairment{Loss: 0.2, Corrupt: 0.1, Duplicate: 0.1}
+	l := newLink(imp, 1, nil)
+	const n = 20000
+	admitAll(l, n, time.Unix(0, 0))
+	s := l.stats
+	check := func(name string, got int64, want float64) {
+		t.Helper()
+		if rate := float64(got) / n; math.Abs(rate-want) > 0.015 {
+			t.Errorf("%s rate %.3f, want %.3f", name, rate, want)
+		}
+	}
+	check("loss", s.Lost, 0.2)
+	check("corruption", s.Corrupted, 0.1*0.8)
+	check("duplication", s.Duplicated, 0.1*0.8)
+}
+
+func TestGilbertElliott(t *testing.T) {
+	ge := &GilbertElliott{P: 0.05, R: 0.25, LossBad: 1}
+	l := newLink(Impairment{Burst: ge}, 7, nil)
+	const n = 100000
+	out := admitAll(l, n, time.Unix(0, 0))
+
+	lost, bursts := 0, 0
+	for i, d := range out {
+		if len(d) == 0 {
+			lost++
+			if i == 0 || len(out[i-1]) != 0 {
+				bursts++
+			}
+		}
+	}
+	// 平均丢包率 P/(P+R)，平均突发长度 1/R
+	if rate, want := float64(lost)/n, ge.P/(ge.P+ge.R); math.Abs(rate-want) > 0.02 {
+		t.Errorf("loss rate %.3f, want %.3f", rate, want)
+	}
+	if mean, want := float64(lost)/float64(bursts), 1/ge.R; math.Abs(mean-want) > 0.3 {
+		t.Errorf("mean burst length %.2f, want %.2f", mean, want)
+	}
+	// 同样的平均丢包率下，伯努利丢包的突发长度接近 1
+	bl := newLink(Impairment{Loss: ge.P / (ge.P + ge.R)}, 7, nil)
+	out = admitAll(bl, n, time.Unix(0, 0))
+	lost, bursts = 0, 0
+	for i, d := range out {
+		if len(d) == 0 {
+			lost++
+			if i == 0 || len(out[i-1]) != 0 {
+				bursts++
+			}
+		}
+	}
+	if mean := float64(lost) / float64(bursts); mean > 1.5 {
+		t.Errorf("bernoulli mean burst length %.2f", mean)
+	}
+}
+
+func TestDelayRateAndReorder(t *testing.T) {
+	now := time.Unix(100, 0)
+	// 80 kbit/s：1000 字节的报文发送需要 100ms
+	l := newLink(Impairment{Rate: 80000, Delay: 20 * time.Millisecond, QueueLimit: 4000}, 1, nil)
+	pkt := make([]byte, 1000)
+	for i, want := range []time.Duration{120, 220, 320, 420} {
+		d := l.admit(pkt, now)
+		if len(d) != 1 || d[0].at.Sub(now) != want*time.Millisecond {
+			t.Errorf("packet %d: %v", i, d)
+		}
+	}
+	// 尚未发出的 4000 字节占满了队列
+	if d := l.admit(pkt, now); d != nil || l.stats.QueueDrops != 1 {
+		t.Errorf("queue overflow not dropped: %v", d)
+	}
+	// 队列排空后不再丢弃
+	if d := l.admit(pkt, now.Add(time.Second)); len(d) != 1 {
+		t.Error("dropped after the queue drained")
+	}
+
+	// 抖动不超过范围
+	l = newLink(Impairment{Delay: 50 * time.Millisecond, Jitter: 10 * time.Millisecond}, 1, nil)
+	for range 1000 {
+		d := l.admit(pkt, now)[0].at.Sub(now)
+		if d < 40*time.Millisecond || d > 60*time.Millisecond {
+			t.Fatalf("delay %v outside 50ms±10ms", d)
+		}
+	}
+
+	// 乱序报文被额外延迟，之后的报文先送达
+	l = newLink(Impairment{Reorder: 1, ReorderDelay: 30 * time.Millisecond}, 1, nil)
+	if d := l.admit(pkt, now)[0].at.Sub(now); d != 30*time.Millisecond {
+		t.Errorf("reordered delay %v", d)
+	}
+}
+
+// TestLinkOrder 没有抖动和乱序时，报文按进入链路的顺序送达
+func TestLinkOrder(t *testing.T) {
+	l := newLink(Impairment{Delay: 5 * time.Millisecond, Duplicate: 0.3}, 1, nil)
+	done := make(chan struct{})
+	got := make(chan []byte, 200)
+	go l.run(func(_ string, b []byte) { got <- b }, done)
+	defer close(done)
+	for i := range 100 {
+		l.send("k", []byte{byte(i)}, time.Now())
+	}
+	last := -1
+	for range 100 + l.snapshot().Duplicated {
+		select {
+		case b := <-got:
+			if int(b[0]) < last {
+				t.Fatalf("packet %d delivered after %d", b[0], last)
+			}
+			last = int(b[0])
+		case <-time.After(time.Second):
+			t.Fatal("timed out")
+		}
+	}
+	if last != 99 {
+		t.Errorf("last packet %d", last)
+	}
+}
@@ -0,0 +1,184 @@
+package netem
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// Proxy 位于发送方和接收方之间的 UDP 中间盒：把发往本地地址的数据报转发给目标，
+// 把目标的回应转发回去，两个方向分别按 Impairment 损伤。
+// 每个客户端地址使用一个独立的上游 socket，目标看到的是不同的对端
+type Proxy struct {
+	front  net.PacketConn
+	target net.Addr
+	up     *link // 客户端 → 目标
+	down   *link // 目标 → 客户端
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	closed   bool
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// session 一个客户端和它的上游 socket
+type session struct {
+	client   net.Addr
+	upstream net.PacketConn
+}
+
+// Options 代理参数
+type Options struct {
+	Up   Impairment // 客户端发往目标的方向
+	Down Impairment // 目标发回客户端的方向
+	// Seed 随机数种子，两个方向分别使用 Seed 和 Seed+1
+	Seed int64
+	// Logf 非空时输出每个被丢弃、损坏、复制和乱序的报文
+	Logf func(format string, args ...any)
+}
+
+// NewProxy 在 front 上接收客户端的数据报并转发到 target，调用 Serve 开始转发
+func NewProxy(front net.PacketConn, target net.Addr, opts Options) *Proxy {
+	prefixed := func(dir string) func(string, ...any) {
+		if opts.Logf == nil {
+			return nil
+		}
+		return func(format string, args ...any) {
+			opts.Logf(dir+": "+format, args...)
+		}
+	}
+	return &Proxy{
+		front:    front,
+		target:   target,
+		up:       newLink(opts.Up, opts.Seed, prefixed("up")),
+		down:     newLink(opts.Down, opts.Seed+1, prefixed("down")),
+		sessions: make(map[string]*session),
+		done:     make(chan struct{}),
+	}
+}
+
+// Listen 在 UDP 地址 addr 上创建代理，转发到 UDP 地址 target
+func Listen(addr, target string, opts Options) (*Proxy, error) {
+	raddr, err := net.ResolveUDPAddr("udp", target)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewProxy(pc, raddr, opts), nil
+}
+
+// Addr 返回客户端应当发送到的地址
+func (p *Proxy) Addr() net.Addr { return p.front.LocalAddr() }
+
+// Serve 转发数据报直到 Close
+func (p *Proxy) Serve() error {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.up.run(p.deliverUp, p.done)
+	}()
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.down.run(p.deliverDown, p.done)
+	}()
+
+	buf := make([]byte, 64<<10)
+	for {
+		n, addr, err := p.front.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-p.done:
+				return nil
+			default:
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		if _, err := p.session(addr); err != nil {
+			continue
+		}
+		p.up.send(addr.String(), buf[:n], time.Now())
+	}
+}
+
+// session 返回客户端的会话，第一次出现时创建上游 socket
+func (p *Proxy) session(client net.Addr) (*session, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := client.String()
+	if s := p.sessions[key]; s != nil {
+		return s, nil
+	}
+	if p.closed {
+		return nil, net.ErrClosed
+	}
+	upstream, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return nil, err
+	}
+	s := &session{client: client, upstream: upstream}
+	p.sessions[key] = s
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		buf := make([]byte, 64<<10)
+		for {
+			n, _, err := upstream.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			p.down.send(key, buf[:n], time.Now())
+		}
+	}()
+	return s, nil
+}
+
+func (p *Proxy) deliverUp(key string, data []byte) {
+	p.mu.Lock()
+	s := p.sessions[key]
+	p.mu.Unlock()
+	if s != nil {
+		_, _ = s.upstream.WriteTo(data, p.target)
+	}
+}
+
+func (p *Proxy) deliverDown(key string, data []byte) {
+	p.mu.Lock()
+	s := p.sessions[key]
+	p.mu.Unlock()
+	if s != nil {
+		_, _ = p.front.WriteTo(data, s.client)
+	}
+}
+
+// Stats 返回两个方向的统计
+func (p *Proxy) Stats() (up, down Stats) {
+	return p.up.snapshot(), p.down.snapshot()
+}
+
+// Close 停止转发并关闭所有 socket
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return net.ErrClosed
+	}
+	p.closed = true
+	close(p.done)
+	for _, s := range p.sessions {
+		_ = s.upstream.Close()
+	}
+	p.mu.Unlock()
+	err := p.front.Close()
+	p.wg.Wait()
+	return err
+}
@@ -0,0 +1,81 @@
+package netem
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"rdt"
+)
+
+// TestProxyTransfer 通过两个方向都有损伤的代理传输数据，rdt 连接仍然交付正确的数据
+func TestProxyTransfer(t *testing.T) {
+	for _, proto := range []rdt.Protocol{rdt.GBN, rdt.SR} {
+		t.Run(proto.String(), func(t *testing.T) {
+			cfg := &rdt.Config{Protocol: proto, Window: 8, SeqBits: 5, Timeout: 60 * time.Millisecond, MSS: 512}
+			l, err := rdt.Listen("udp", "127.0.0.1:0", cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+
+			imp := Impairment{
+				Burst:     &GilbertElliott{P: 0.03, R: 0.5, LossBad: 1},
+				Delay:     2 * time.Millisecond,
+				Jitter:    time.Millisecond,
+				Reorder:   0.05,
+				Duplicate: 0.05,
+				Corrupt:   0.03,
+			}
+			down := imp
+			down.Burst, down.Loss = nil, 0.05
+			proxy, err := Listen("127.0.0.1:0", l.Addr().String(), Options{Up: imp, Down: down, Seed: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			go func() { _ = proxy.Serve() }()
+			defer proxy.Close()
+
+			want := make([]byte, 40000)
+			rand.New(rand.NewSource(1)).Read(want)
+			got := make(chan []byte, 1)
+			go func() {
+				nc, err := l.Accept()
+				if err != nil {
+					got <- nil
+					return
+				}
+				b, _ := io.ReadAll(nc)
+				_ = nc.Close()
+				got <- b
+			}()
+
+			c, err := rdt.Dial("udp", proxy.Addr().String(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.Write(want); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if b := <-got; !bytes.Equal(b, want) {
+				t.Errorf("received %d bytes, want %d", len(b), len(want))
+			}
+
+			up, dn := proxy.Stats()
+			if up.Lost == 0 || up.Corrupted == 0 || up.Duplicated == 0 || up.Reordered == 0 || dn.Lost == 0 {
+				t.Errorf("impairments not applied: up %+v down %+v", up, dn)
+			}
+			if up.Delivered != up.Received-up.Lost-up.QueueDrops+up.Duplicated {
+				t.Errorf("up delivered %d of %+v", up.Delivered, up)
+			}
+			if s := c.Stats(); s.Retransmissions == 0 {
+				t.Errorf("no retransmissions: %+v", s)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+// Package rdt 实现 GBN/SR 协议使用的二进制报文格式
+package rdt
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// PacketType 报文类型
+type PacketType uint8
+
+const (
+	TypeData PacketType = iota + 1 // 数据
+	TypeAck                        // 确认
+	TypeNak                        // 否定确认
+	TypeSyn                        // 建立连接
+	TypeFin                        // 关闭连接
+)
+
+func (t PacketType) String() string {
+	switch t {
+	case TypeData:
+		return "DATA"
+	case TypeAck:
+		return "ACK"
+	case TypeNak:
+		return "NAK"
+	case TypeSyn:
+		return "SYN"
+	case TypeFin:
+		return "FIN"
+	}
+	return fmt.Sprintf("TYPE(%d)", uint8(t))
+}
+
+// 报文头部（大端序）：
+//
+//	0      1          5          9        11       13         17
+//	+------+----------+----------+--------+--------+----------+---------
+//	| 类型 |  序列号  |  确认号  |  窗口  |  长度  |  CRC32   | 数据 ...
+//	+------+----------+----------+--------+--------+----------+---------
+//
+// CRC32（IEEE）覆盖校验和字段置零后的头部和全部数据
+const (
+	HeaderSize     = 17
+	MaxPayloadSize = 65507 - HeaderSize // 一个 UDP 数据报能携带的最大数据长度
+	checksumOffset = 13
+)
+
+var (
+	ErrShortPacket = errors.New("rdt: packet shorter than header")
+	ErrLength      = errors.New("rdt: length field does not match packet size")
+	ErrChecksum    = errors.New("rdt: checksum mismatch")
+	ErrType        = errors.New("rdt: unknown packet type")
+	ErrTooLarge    = errors.New("rdt: payload too large")
+)
+
+// Packet 一个 GBN/SR 报文，Payload 可以是任意字节
+type Packet struct {
+	Type    PacketType
+	Seq     uint32 // 序列号
+	Ack     uint32 // 确认号
+	Window  uint16 // SYN 中为窗口大小（报文数），其他报文中为接收窗口（字节）
+	Payload []byte
+}
+
+func (p Packet) String() string {
+	return fmt.Sprintf("%v seq=%d ack=%d win=%d len=%d", p.Type, p.Seq, p.Ack, p.Window, len(p.Payload))
+}
+
+// Encode 把报文编码为数据报
+func Encode(p Packet) ([]byte, error) {
+	return AppendPacket(nil, p)
+}
+
+// AppendPacket 把编码后的报文追加到 buf 之后并返回结果
+func AppendPacket(buf []byte, p Packet) ([]byte, error) {
+	if !p.Type.valid() {
+		return nil, ErrType
+	}
+	if len(p.Payload) > MaxPayloadSize {
+		return nil, ErrTooLarge
+	}
+	start := len(buf)
+	buf = append(buf, byte(p.Type))
+	buf = binary.BigEndian.AppendUint32(buf, p.Seq)
+	buf = binary.BigEndian.AppendUint32(buf, p.Ack)
+	buf = binary.BigEndian.AppendUint16(buf, p.Window)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(p.Payload)))
+	buf = binary.BigEndian.AppendUint32(buf, 0)
+	buf = append(buf, p.Payload...)
+	binary.BigEndian.PutUint32(buf[start+checksumOffset:], checksum(buf[start:]))
+	return buf, nil
+}
+
+// Decode 解析数据报。长度、类型或校验和不正确的报文返回错误，调用方应丢弃该报文。
+// 返回的 Payload 引用 b 中的数据
+func Decode(b []byte) (Packet, error) {
+	if len(b) < HeaderSize {
+		return Packet{}, ErrShortPacket
+	}
+	if int(binary.BigEndian.Uint16(b[11:])) != len(b)-HeaderSize {
+		return Packet{}, ErrLength
+	}
+	if binary.BigEndian.Uint32(b[checksumOffset:]) != checksum(b) {
+		return Packet{}, ErrChecksum
+	}
+	p := Packet{
+		Type:    PacketType(b[0]),
+		Seq:     binary.BigEndian.Uint32(b[1:]),
+		Ack:     binary.BigEndian.Uint32(b[5:]),
+		Window:  binary.BigEndian.Uint16(b[9:]),
+		Payload: b[HeaderSize:],
+	}
+	if !p.Type.valid() {
+		return Packet{}, ErrType
+	}
+	return p, nil
+}
+
+// IsCorrupt 判断 Decode 的错误是否表示报文在传输中损坏
+func IsCorrupt(err error) bool {
+	return errors.Is(err, ErrShortPacket) || errors.Is(err, ErrLength) ||
+		errors.Is(err, ErrChecksum) || errors.Is(err, ErrType)
+}
+
+func (t PacketType) valid() bool {
+	return t >= TypeData && t <= TypeFin
+}
+
+// checksum 计算校验和字段视为零时整个报文的 CRC32
+func checksum(b []byte) uint32 {
+	var zero [4]byte
+	crc := crc32.ChecksumIEEE(b[:checksumOffset])
+	crc = crc32.Update(crc, crc32.IEEETable, zero[:])
+	return crc32.Update(crc, crc32.IEEETable, b[checksumOffset+4:])
+}
@@ -0,0 +1,133 @@
+package rdt
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestPacketRoundTrip(t *testing.T) {
+	tests := []Packet{
+		{Type: TypeData, Seq: 7, Window: 4, Payload: []byte("Packet 7 with spaces")},
+		{Type: TypeData, Seq: 0xffffffff, Ack: 3, Window: 0xffff, Payload: []byte{0, 1, 0xff, '\n', ':', ' '}},
+		{Type: TypeAck, Ack: 42, Window: 8},
+		{Type: TypeNak, Ack: 5},
+		{Type: TypeSyn, Seq: 1000},
+		{Type: TypeFin, Seq: 12},
+		{Type: TypeData, Payload: bytes.Repeat([]byte{0xaa}, MaxPayloadSize)},
+	}
+	for _, want := range tests {
+		b, err := Encode(want)
+		if err != nil {
+			t.Fatalf("%v: %v", want, err)
+		}
+		if len(b) != HeaderSize+len(want.Payload) {
+			t.Errorf("%v: encoded %d bytes", want, len(b))
+		}
+		got, err := Decode(b)
+		if err != nil {
+			t.Fatalf("%v: %v", want, err)
+		}
+		if !equalPacket(got, want) {
+			t.Errorf("decoded %v, want %v", got, want)
+		}
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	if _, err := Encode(Packet{Type: 0}); !errors.Is(err, ErrType) {
+		t.Errorf("type 0: %v", err)
+	}
+	if _, err := Encode(Packet{Type: TypeData, Payload: make([]byte, MaxPayloadSize+1)}); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("oversized payload: %v", err)
+	}
+}
+
+func TestDecodeCorrupt(t *testing.T) {
+	good, _ := Encode(Packet{Type: TypeData, Seq: 3, Ack: 1, Window: 4, Payload: []byte("hello world")})
+	mutate := func(f func([]byte) []byte) []byte {
+		return f(append([]byte(nil), good...))
+	}
+	tests := []struct {
+		name string
+		b    []byte
+		want error
+	}{
+		{"empty", nil, ErrShortPacket},
+		{"truncated header", good[:HeaderSize-1], ErrShortPacket},
+		{"truncated payload", good[:len(good)-3], ErrLength},
+		{"trailing bytes", append(append([]byte(nil), good...), 0), ErrLength},
+		{"flipped payload bit", mutate(func(b []byte) []byte { b[HeaderSize] ^= 1; return b }), ErrChecksum},
+		{"changed sequence number", mutate(func(b []byte) []byte { b[4]++; return b }), ErrChecksum},
+		{"changed checksum", mutate(func(b []byte) []byte { b[checksumOffset] ^= 0x80; return b }), ErrChecksum},
+	}
+	for _, tt := range tests {
+		if _, err := Decode(tt.b); !errors.Is(err, tt.want) || !IsCorrupt(err) {
+			t.Errorf("%s: err %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	// CRC32 能检测所有单比特错误
+	for i := range len(good) * 8 {
+		b := append([]byte(nil), good...)
+		b[i/8] ^= 1 << (i % 8)
+		if _, err := Decode(b); err == nil {
+			t.Fatalf("bit %d flip not detected", i)
+		}
+	}
+}
+
+func FuzzPacketRoundTrip(f *testing.F) {
+	f.Add(uint8(TypeData), uint32(0), uint32(0), uint16(4), []byte("Packet-0"))
+	f.Add(uint8(TypeAck), uint32(1), uint32(0xffffffff), uint16(0), []byte{})
+	f.Add(uint8(TypeFin), uint32(9), uint32(8), uint16(1), []byte{0, 0xff, ' ', '\n'})
+	f.Fuzz(func(t *testing.T, typ uint8, seq, ack uint32, window uint16, payload []byte) {
+		p := Packet{Type: PacketType(typ), Seq: seq, Ack: ack, Window: window, Payload: payload}
+		b, err := Encode(p)
+		if !p.Type.valid() {
+			if err == nil {
+				t.Fatalf("encoded invalid type %d", typ)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := Decode(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equalPacket(got, p) {
+			t.Fatalf("decoded %v, want %v", got, p)
+		}
+	})
+}
+
+// FuzzDecode 任意输入都不会导致崩溃，能解析的报文重新编码后与输入相同
+func FuzzDecode(f *testing.F) {
+	good, _ := Encode(Packet{Type: TypeData, Seq: 1, Payload: []byte("data")})
+	f.Add(good)
+	f.Add([]byte("0:Packet-0"))
+	f.Add(make([]byte, HeaderSize))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		p, err := Decode(b)
+		if err != nil {
+			if !IsCorrupt(err) {
+				t.Fatalf("unexpected error %v", err)
+			}
+			return
+		}
+		again, err := Encode(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(again, b) {
+			t.Fatalf("re-encoded %x, want %x", again, b)
+		}
+	})
+}
+
+func equalPacket(a, b Packet) bool {
+	return a.Type == b.Type && a.Seq == b.Seq && a.Ack == b.Ack && a.Window == b.Window &&
+		bytes.Equal(a.Payload, b.Payload)
+}
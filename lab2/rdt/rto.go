@@ -0,0 +1,78 @@
+package rdt
+
+import "time"
+
+// rtoEstimator 按 RFC 6298 根据往返时间样本计算重传超时（RTO）：
+//
+//	第一个样本 R：SRTT = R，RTTVAR = R/2
+//	之后的样本：  RTTVAR = 3/4·RTTVAR + 1/4·|SRTT−R|，SRTT = 7/8·SRTT + 1/8·R
+//	RTO = SRTT + max(G, 4·RTTVAR)，限制在 [min, max] 内
+//
+// 超时后 RTO 指数退避。按照 Karn 算法，重传过的报文的确认不知道对应哪一次发送，
+// 不作为样本，但说明连接有了进展，退避到此结束
+type rtoEstimator struct {
+	srtt        time.Duration
+	rttvar      time.Duration
+	base        time.Duration // 未退避的 RTO
+	backoff     int           // 连续超时的轮数，RTO 为 base·2^backoff
+	lastBackoff time.Time     // 最近一次退避的时刻，之前发送的报文超时属于同一轮
+	samples     int64
+	min         time.Duration
+	max         time.Duration
+	fixed       bool // 始终使用初始 RTO，不估计也不退避
+}
+
+// clockGranularity RFC 6298 中的时钟粒度 G
+const clockGranularity = time.Millisecond
+
+func newRTOEstimator(cfg Config) rtoEstimator {
+	return rtoEstimator{base: cfg.Timeout, min: cfg.MinRTO, max: cfg.MaxRTO, fixed: cfg.FixedRTO}
+}
+
+// sample 加入一个往返时间样本，并清除退避
+func (e *rtoEstimator) sample(r time.Duration) {
+	if e.fixed {
+		return
+	}
+	if r <= 0 {
+		r = clockGranularity
+	}
+	if e.samples == 0 {
+		e.srtt = r
+		e.rttvar = r / 2
+	} else {
+		diff := e.srtt - r
+		if diff < 0 {
+			diff = -diff
+		}
+		e.rttvar = (3*e.rttvar + diff) / 4
+		e.srtt = (7*e.srtt + r) / 8
+	}
+	e.samples++
+	e.base = min(max(e.srtt+max(clockGranularity, 4*e.rttvar), e.min), e.max)
+	e.backoff = 0
+}
+
+// timedOut 记录发送于 sentAt 的报文在 now 超时，RTO 加倍。一轮超时只退避一次：
+// 在最近一次退避之前发送的报文（SR 中同时超时的其他报文）超时不再加倍
+func (e *rtoEstimator) timedOut(sentAt, now time.Time) {
+	if e.fixed || sentAt.Before(e.lastBackoff) {
+		return
+	}
+	e.lastBackoff = now
+	if e.base<<e.backoff < e.max {
+		e.backoff++
+	}
+}
+
+// retransmissionAcked 重传过的报文被确认：不是有效样本，估计值不变，但按当前 RTO 重传的报文能够到达，
+// 清除退避。只靠新样本清除退避时，GBN 超时后整个窗口都被重传，丢包和乱序较多时
+// 长时间得不到样本，RTO 会一直停在 MaxRTO
+func (e *rtoEstimator) retransmissionAcked() {
+	e.backoff = 0
+}
+
+// rto 返回当前的重传超时
+func (e *rtoEstimator) rto() time.Duration {
+	return min(e.base<<e.backoff, e.max)
+}
@@ -0,0 +1,164 @@
+package rdt
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRTOEstimator(t *testing.T) {
+	ms := time.Millisecond
+	e := newRTOEstimator(Config{Timeout: time.Second, MinRTO: 10 * ms, MaxRTO: 2 * time.Second})
+	if e.rto() != time.Second {
+		t.Fatalf("initial rto %v", e.rto())
+	}
+	steps := []struct {
+		sample            time.Duration
+		srtt, rttvar, rto time.Duration
+	}{
+		// 第一个样本：SRTT = R，RTTVAR = R/2，RTO = R + 4·R/2
+		{100 * ms, 100 * ms, 50 * ms, 300 * ms},
+		// RTTVAR = 3/4·50 + 1/4·|100−60| = 47.5，SRTT = 7/8·100 + 1/8·60 = 95
+		{60 * ms, 95 * ms, 47500 * time.Microsecond, 285 * ms},
+		{95 * ms, 95 * ms, 35625 * time.Microsecond, 237500 * time.Microsecond},
+	}
+	for i, s := range steps {
+		e.sample(s.sample)
+		if e.srtt != s.srtt || e.rttvar != s.rttvar || e.rto() != s.rto {
+			t.Errorf("step %d: srtt %v rttvar %v rto %v, want %v %v %v", i, e.srtt, e.rttvar, e.rto(), s.srtt, s.rttvar, s.rto)
+		}
+	}
+
+	// 指数退避，不超过上限；新的样本清除退避
+	base := e.rto()
+	now := time.Now()
+	sent := now.Add(-base)
+	e.timedOut(sent, now)
+	e.timedOut(sent, now.Add(ms)) // 同一轮的其他报文超时不再退避
+	if e.rto() != 2*base {
+		t.Errorf("after one timeout rto %v, want %v", e.rto(), 2*base)
+	}
+	for i := 1; i <= 2; i++ {
+		e.timedOut(now, now.Add(time.Duration(i)*time.Second))
+		now = now.Add(time.Duration(i) * time.Second)
+	}
+	if e.rto() != 8*base {
+		t.Errorf("after three timeouts rto %v, want %v", e.rto(), 8*base)
+	}
+	for range 10 {
+		e.timedOut(now, now.Add(time.Second))
+		now = now.Add(time.Second)
+	}
+	if e.rto() != 2*time.Second {
+		t.Errorf("rto %v not clamped to the maximum", e.rto())
+	}
+	e.sample(95 * ms)
+	if e.backoff != 0 || e.rto() > base {
+		t.Errorf("backoff %d rto %v after a new sample", e.backoff, e.rto())
+	}
+
+	// 重传过的报文被确认：估计值不变，退避结束
+	e.timedOut(now, now.Add(time.Second))
+	e.timedOut(now.Add(time.Second), now.Add(2*time.Second))
+	e.retransmissionAcked()
+	if e.backoff != 0 || e.samples != 4 {
+		t.Errorf("backoff %d, %d samples after an acked retransmission", e.backoff, e.samples)
+	}
+
+	// 稳定的很短的往返时间：RTO 不低于下限
+	for range 100 {
+		e.sample(ms)
+	}
+	if e.rto() != 10*ms {
+		t.Errorf("rto %v, want the minimum 10ms", e.rto())
+	}
+
+	// FixedRTO 始终使用 Timeout
+	f := newRTOEstimator(Config{Timeout: 300 * ms, MinRTO: 10 * ms, MaxRTO: time.Second, FixedRTO: true})
+	f.sample(ms)
+	f.timedOut(now, now.Add(time.Second))
+	if f.rto() != 300*ms || f.samples != 0 {
+		t.Errorf("fixed rto %v, %d samples", f.rto(), f.samples)
+	}
+}
+
+// TestKarn 重传过的报文被确认时不产生样本，只清除退避
+func TestKarn(t *testing.T) {
+	c := newConn(nil, nil, Config{Timeout: time.Second, MinRTO: 10 * time.Millisecond, MaxRTO: 10 * time.Second})
+	now := time.Now()
+	c.rtt.timedOut(now.Add(-time.Second), now.Add(-100*time.Millisecond))
+	c.rtt.timedOut(now.Add(-100*time.Millisecond), now.Add(-50*time.Millisecond))
+	c.sampleRTT(&segment{sentAt: now.Add(-50 * time.Millisecond), retries: 1}, now)
+	if c.rtt.samples != 0 || c.rtt.rto() != time.Second {
+		t.Fatalf("retransmitted segment: %d samples, rto %v", c.rtt.samples, c.rtt.rto())
+	}
+	c.sampleRTT(&segment{sentAt: now.Add(-50 * time.Millisecond)}, now)
+	if c.rtt.samples != 1 || c.rtt.srtt != 50*time.Millisecond {
+		t.Errorf("%d samples, srtt %v", c.rtt.samples, c.rtt.srtt)
+	}
+}
+
+// delayConn 每个发出的数据报延迟 delay 后才真正发送
+type delayConn struct {
+	net.PacketConn
+	delay time.Duration
+}
+
+func (d *delayConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	b = append([]byte(nil), b...)
+	time.AfterFunc(d.delay, func() { _, _ = d.PacketConn.WriteTo(b, addr) })
+	return len(b), nil
+}
+
+// TestAdaptiveRTO 初始超时很长，收到样本后 RTO 收敛到往返时间附近
+func TestAdaptiveRTO(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	cfg := Config{Protocol: SR, Window: 4, Timeout: 2 * time.Second, MinRTO: 50 * time.Millisecond, AckDelay: -1, MSS: 256}
+	lpc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewListener(&delayConn{lpc, delay}, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		nc, err := l.Accept()
+		if err == nil {
+			_, _ = io.Copy(io.Discard, nc)
+			_ = nc.Close()
+		}
+	}()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	c, err := DialPacket(&delayConn{pc, delay}, l.Addr(), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write(testPayload(8192)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s := c.Stats()
+	t.Logf("srtt %v rttvar %v rto %v, %d samples", s.SRTT, s.RTTVar, s.RTO, s.RTTSamples)
+	if s.RTTSamples < 10 {
+		t.Errorf("only %d rtt samples", s.RTTSamples)
+	}
+	if rtt := 2 * delay; s.SRTT < rtt || s.SRTT > rtt+15*time.Millisecond {
+		t.Errorf("srtt %v, want about %v", s.SRTT, rtt)
+	}
+	if s.RTO >= cfg.Timeout/4 || s.RTO < cfg.MinRTO {
+		t.Errorf("rto %v did not adapt", s.RTO)
+	}
+	if s.Retransmissions != 0 {
+		t.Errorf("%d spurious retransmissions", s.Retransmissions)
+	}
+}
@@ -0,0 +1,64 @@
+package rdt
+
+import "fmt"
+
+// SeqSpace k 位序列号空间：序列号在 0..2^k-1 之间循环使用，所有比较都按模 2^k 计算。
+//
+// 接收方只能根据序列号区分新报文和重传的旧报文，所以窗口大小受序列号空间限制：
+// GBN 的接收窗口为 1，发送窗口 N ≤ 2^k-1；SR 的发送窗口和接收窗口都为 N，
+// 新旧两个窗口不能重叠，N ≤ 2^(k-1)
+type SeqSpace struct {
+	mask uint32
+}
+
+// NewSeqSpace 返回 k 位序列号空间，1 ≤ k ≤ 32
+func NewSeqSpace(bits int) (SeqSpace, error) {
+	if bits < 1 || bits > 32 {
+		return SeqSpace{}, fmt.Errorf("rdt: sequence number bits %d out of range 1..32", bits)
+	}
+	return SeqSpace{mask: uint32(1<<bits - 1)}, nil
+}
+
+// Bits 返回序列号的位数
+func (s SeqSpace) Bits() int {
+	bits := 0
+	for m := s.mask; m != 0; m >>= 1 {
+		bits++
+	}
+	return bits
+}
+
+// Max 返回最大的序列号 2^k-1
+func (s SeqSpace) Max() uint32 { return s.mask }
+
+// Add 返回 seq 之后第 n 个序列号，n 可以为负
+func (s SeqSpace) Add(seq uint32, n int) uint32 {
+	return (seq + uint32(n)) & s.mask
+}
+
+// Next 返回 seq 的下一个序列号
+func (s SeqSpace) Next(seq uint32) uint32 { return s.Add(seq, 1) }
+
+// Distance 返回从 from 向前数到 to 的距离
+func (s SeqSpace) Distance(from, to uint32) uint32 {
+	return (to - from) & s.mask
+}
+
+// InWindow 判断 seq 是否在从 base 开始、长度为 n 的窗口内
+func (s SeqSpace) InWindow(seq, base uint32, n int) bool {
+	return n > 0 && s.Distance(base, seq) < uint32(n)
+}
+
+// Less 判断 a 是否在 b 之前：从 a 到 b 的距离小于半个序列号空间
+func (s SeqSpace) Less(a, b uint32) bool {
+	d := s.Distance(a, b)
+	return d != 0 && d <= s.mask>>1
+}
+
+// MaxWindow 返回协议在该序列号空间中允许的最大窗口
+func (s SeqSpace) MaxWindow(p Protocol) int {
+	if p == SR {
+		return int(s.mask>>1) + 1
+	}
+	return int(s.mask)
+}
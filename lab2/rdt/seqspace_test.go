@@ -0,0 +1,110 @@
+package rdt
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSeqSpace(t *testing.T) {
+	s, err := NewSeqSpace(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Max() != 7 || s.Bits() != 3 {
+		t.Fatalf("max %d bits %d", s.Max(), s.Bits())
+	}
+	tests := []struct {
+		name string
+		got  any
+		want any
+	}{
+		{"next wraps", s.Next(7), uint32(0)},
+		{"add wraps", s.Add(6, 5), uint32(3)},
+		{"add negative", s.Add(1, -3), uint32(6)},
+		{"distance across wrap", s.Distance(6, 1), uint32(3)},
+		{"distance backwards", s.Distance(1, 6), uint32(5)},
+		{"window across wrap", s.InWindow(1, 6, 4), true},
+		{"window end exclusive", s.InWindow(2, 6, 4), false},
+		{"before window", s.InWindow(5, 6, 4), false},
+		{"empty window", s.InWindow(6, 6, 0), false},
+		{"less across wrap", s.Less(7, 1), true},
+		{"not less across wrap", s.Less(1, 7), false},
+		{"equal is not less", s.Less(4, 4), false},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+
+	full, _ := NewSeqSpace(32)
+	if full.Max() != 1<<32-1 || full.Next(full.Max()) != 0 || full.Bits() != 32 {
+		t.Errorf("32-bit space: max %d next %d", full.Max(), full.Next(full.Max()))
+	}
+	for _, bits := range []int{0, 33, -1} {
+		if _, err := NewSeqSpace(bits); err == nil {
+			t.Errorf("%d bits: expected error", bits)
+		}
+	}
+}
+
+// TestWindowConstraint GBN 窗口 ≤ 2^k-1，SR 窗口 ≤ 2^(k-1)
+func TestWindowConstraint(t *testing.T) {
+	tests := []struct {
+		proto   Protocol
+		bits    int
+		window  int
+		wantErr bool
+	}{
+		{GBN, 3, 7, false},
+		{GBN, 3, 8, true},
+		{SR, 3, 4, false},
+		{SR, 3, 5, true},
+		{SR, 1, 1, false},
+		{GBN, 1, 1, false},
+		{GBN, 1, 2, true},
+		{StopAndWait, 1, 0, false}, // 交替位协议
+		{GBN, 32, maxWindow, false},
+		{GBN, 32, maxWindow + 1, true},
+		{GBN, 0, 8, false}, // 默认 32 位
+		{SR, 33, 4, true},
+	}
+	for _, tt := range tests {
+		cfg := Config{Protocol: tt.proto, SeqBits: tt.bits, Window: tt.window}
+		_, err := cfg.withDefaults()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%v k=%d N=%d: err %v", tt.proto, tt.bits, tt.window, err)
+		}
+		if err != nil && tt.bits >= 1 && tt.bits <= 32 && !strings.Contains(err.Error(), "window") {
+			t.Errorf("%v k=%d N=%d: unexpected error %v", tt.proto, tt.bits, tt.window, err)
+		}
+	}
+}
+
+// TestSequenceWraparound 很小的序列号空间上传输几百个报文，序列号循环很多次
+func TestSequenceWraparound(t *testing.T) {
+	fast := 20 * time.Millisecond
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{"gbn k=3 N=7", Config{Protocol: GBN, SeqBits: 3, Window: 7, Timeout: fast, MSS: 32}},
+		{"sr k=3 N=4", Config{Protocol: SR, SeqBits: 3, Window: 4, Timeout: fast, MSS: 32}},
+		{"sr k=2 N=2", Config{Protocol: SR, SeqBits: 2, Window: 2, Timeout: fast, MSS: 32}},
+		{"saw k=1", Config{Protocol: StopAndWait, SeqBits: 1, Timeout: fast, MSS: 32}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const size = 8000 // 250 个报文
+			client, server := transfer(t, tt.cfg, 0.1, 0.05, size)
+			space, _ := NewSeqSpace(tt.cfg.SeqBits)
+			if wraps := client.PacketsSent / int64(space.Max()+1); wraps < 25 {
+				t.Errorf("sequence space wrapped only %d times", wraps)
+			}
+			if client.Retransmissions == 0 || server.Retransmissions == 0 {
+				t.Errorf("no retransmissions: %+v %+v", client, server)
+			}
+		})
+	}
+}
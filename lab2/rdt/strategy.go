@@ -0,0 +1,228 @@
+package rdt
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Protocol 可靠传输使用的重传策略
+type Protocol uint8
+
+const (
+	GBN         Protocol = iota + 1 // Go-Back-N：累积确认，超时后重传整个窗口
+	SR                              // Selective Repeat：逐个确认，只重传超时的报文
+	StopAndWait                     // 停等协议：窗口为 1 的 GBN
+)
+
+func (p Protocol) String() string {
+	switch p {
+	case GBN:
+		return "gbn"
+	case SR:
+		return "sr"
+	case StopAndWait:
+		return "saw"
+	}
+	return fmt.Sprintf("protocol(%d)", uint8(p))
+}
+
+// ParseProtocol 解析协议名 gbn、sr 或 saw（stop-and-wait）
+func ParseProtocol(s string) (Protocol, error) {
+	switch strings.ToLower(s) {
+	case "gbn", "go-back-n":
+		return GBN, nil
+	case "sr", "selective-repeat":
+		return SR, nil
+	case "saw", "stop-and-wait":
+		return StopAndWait, nil
+	}
+	return 0, fmt.Errorf("rdt: unknown protocol %q, want gbn, sr or saw", s)
+}
+
+// strategy 决定发送方如何处理确认和超时，以及接收方如何处理数据报文。
+// 所有方法都在持有 Conn.mu 时调用
+type strategy interface {
+	// onAck 处理对方的确认，返回是否有新的报文被确认
+	onAck(c *Conn, p Packet, now time.Time) bool
+	// onSend 报文发送或重传之后启动重传计时器
+	onSend(c *Conn, s *segment, now time.Time)
+	// fastRetransmit 收到重复确认或快速恢复中的部分确认后，不等超时重传丢失的报文
+	fastRetransmit(c *Conn, now time.Time)
+	// onData 处理 DATA 和 FIN 报文：交付、缓存或丢弃，并发送确认
+	onData(c *Conn, p Packet, now time.Time)
+}
+
+func newStrategy(p Protocol) strategy {
+	if p == SR {
+		return &selectiveRepeat{}
+	}
+	return &goBackN{}
+}
+
+// goBackN 发送方只有一个计时器，超时后重传所有未确认的报文；
+// 接收方只接受按序到达的报文，确认号为下一个期望的序列号
+type goBackN struct {
+	timer *timer // 最早的未确认报文的重传计时器
+}
+
+func (g *goBackN) onAck(c *Conn, p Packet, now time.Time) bool {
+	n := c.ackedCount(p.Ack)
+	if n == 0 {
+		return false
+	}
+	// 最后一个被确认的报文触发了这个确认，用它测量往返时间
+	c.sampleRTT(c.segs[n-1], now)
+	c.removeAcked(p.Ack, now)
+	if len(c.segs) == 0 {
+		c.cancel(g.timer)
+	} else {
+		c.schedule(g.timer, now.Add(c.rtt.rto()))
+	}
+	return true
+}
+
+func (g *goBackN) onSend(c *Conn, s *segment, now time.Time) {
+	if g.timer == nil {
+		g.timer = newTimer(func(now time.Time) { g.timeout(c, now) })
+	}
+	if !g.timer.active() {
+		c.schedule(g.timer, now.Add(c.rtt.rto()))
+	}
+}
+
+// timeout 重传所有未确认的报文，第一个报文的重传重新启动计时器
+func (g *goBackN) timeout(c *Conn, now time.Time) {
+	if len(c.segs) == 0 {
+		return
+	}
+	c.stats.Timeouts++
+	c.rtt.timedOut(c.segs[0].sentAt, now)
+	if c.cc != nil {
+		c.cc.onTimeout(c, c.segs[0], now)
+	}
+	c.logf("timeout, resending %d packets from seq %d (rto %v)", len(c.segs), c.segs[0].seq, c.rtt.rto())
+	for _, s := range c.segs {
+		c.retransmit(s, now)
+	}
+}
+
+// fastRetransmit 接收方丢弃了缺口之后的所有报文，从缺口开始全部重传
+func (g *goBackN) fastRetransmit(c *Conn, now time.Time) {
+	for _, s := range c.segs {
+		c.retransmit(s, now)
+	}
+}
+
+func (g *goBackN) onData(c *Conn, p Packet, now time.Time) {
+	switch {
+	case p.Seq == c.rcvNxt:
+		c.deliver(p)
+		c.ackInOrder(p, now)
+		return
+	case c.seq.InWindow(p.Seq, c.seq.Add(c.rcvNxt, -c.window), c.window):
+		// 发送方窗口内已经交付过的报文
+		c.stats.Duplicates++
+	default:
+		c.stats.OutOfOrder++
+	}
+	// 乱序报文被丢弃，立即重复确认最后一个按序收到的报文
+	c.sendAck(p.Seq, now)
+}
+
+// selectiveRepeat 每个报文有自己的计时器，只重传超时的报文；
+// 接收方缓存窗口内乱序到达的报文，对每个报文单独确认
+type selectiveRepeat struct{}
+
+func (selectiveRepeat) onAck(c *Conn, p Packet, now time.Time) bool {
+	progress := false
+	// 选择确认：ACK 报文的 Seq 是被确认的报文序列号
+	if p.Type == TypeAck {
+		for _, s := range c.segs {
+			if s.seq == p.Seq && !s.acked {
+				s.acked = true
+				c.cancel(s.timer)
+				c.sampleRTT(s, now)
+				progress = true
+			}
+		}
+	}
+	// 累积确认：确认号之前的报文都已收到
+	if n := c.ackedCount(p.Ack); n > 0 {
+		if last := c.segs[n-1]; !last.acked {
+			c.sampleRTT(last, now)
+		}
+		for _, s := range c.segs[:n] {
+			s.acked = true
+			c.cancel(s.timer)
+		}
+		progress = true
+	}
+	n := 0
+	for n < len(c.segs) && c.segs[n].acked {
+		n++
+	}
+	if n > 0 {
+		c.removeAcked(c.seq.Next(c.segs[n-1].seq), now)
+	}
+	return progress
+}
+
+func (selectiveRepeat) onSend(c *Conn, s *segment, now time.Time) {
+	if s.timer == nil {
+		s.timer = newTimer(func(now time.Time) {
+			c.stats.Timeouts++
+			c.rtt.timedOut(s.sentAt, now)
+			if c.cc != nil {
+				c.cc.onTimeout(c, s, now)
+			}
+			c.logf("timeout for seq %d, resending (rto %v)", s.seq, c.rtt.rto())
+			c.retransmit(s, now)
+		})
+	}
+	c.schedule(s.timer, now.Add(c.rtt.rto()))
+}
+
+// fastRetransmit 只重传第一个没有被确认的报文
+func (selectiveRepeat) fastRetransmit(c *Conn, now time.Time) {
+	for _, s := range c.segs {
+		if !s.acked {
+			c.retransmit(s, now)
+			return
+		}
+	}
+}
+
+func (selectiveRepeat) onData(c *Conn, p Packet, now time.Time) {
+	switch {
+	case c.seq.InWindow(p.Seq, c.rcvNxt, c.window):
+		inOrder := p.Seq == c.rcvNxt
+		if !inOrder {
+			c.stats.OutOfOrder++
+		}
+		if _, dup := c.ooo[p.Seq]; dup {
+			c.stats.Duplicates++
+		} else {
+			c.ooo[p.Seq] = p
+		}
+		for {
+			next, ok := c.ooo[c.rcvNxt]
+			if !ok {
+				break
+			}
+			delete(c.ooo, c.rcvNxt)
+			c.deliver(next)
+		}
+		if inOrder && len(c.ooo) == 0 {
+			// 没有缺口时累积确认号就能确认这个报文，可以延迟并由数据报文捎带
+			c.ackInOrder(p, now)
+		} else {
+			// 乱序报文和填补缺口的报文立即选择确认
+			c.sendAck(p.Seq, now)
+		}
+	case c.seq.InWindow(p.Seq, c.seq.Add(c.rcvNxt, -c.window), c.window):
+		// 已交付的报文：对方没有收到确认，重新确认
+		c.stats.Duplicates++
+		c.sendAck(p.Seq, now)
+	}
+}
@@ -0,0 +1,98 @@
+package rdt
+
+import (
+	"container/heap"
+	"time"
+)
+
+// timer 连接的一个计时器：SYN 重传、报文重传、延迟确认和连接释放。
+// 启动后放在 Conn.timers 中，到期时 timerLoop 在持有 Conn.mu 时调用 fire
+type timer struct {
+	at    time.Time
+	index int // 在 timerHeap 中的位置，-1 表示未启动
+	fire  func(now time.Time)
+}
+
+func newTimer(fire func(now time.Time)) *timer {
+	return &timer{index: -1, fire: fire}
+}
+
+// active 判断计时器是否已启动且尚未到期
+func (t *timer) active() bool { return t != nil && t.index >= 0 }
+
+// timerHeap 按到期时间排列的最小堆，堆顶是最早到期的计时器
+type timerHeap []*timer
+
+func (h timerHeap) Len() int           { return len(h) }
+func (h timerHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *timerHeap) Push(x any) {
+	t := x.(*timer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *timerHeap) Pop() any {
+	old := *h
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	t.index = -1
+	return t
+}
+
+// schedule 启动或重新设置计时器，在 at 时刻到期。调用方持有 mu
+func (c *Conn) schedule(t *timer, at time.Time) {
+	t.at = at
+	if t.active() {
+		heap.Fix(&c.timers, t.index)
+	} else {
+		heap.Push(&c.timers, t)
+	}
+	if t.index == 0 {
+		// 最早的到期时间提前了，唤醒 timerLoop 重新设置等待时间
+		select {
+		case c.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// cancel 停止计时器，未启动的计时器不受影响。调用方持有 mu
+func (c *Conn) cancel(t *timer) {
+	if t.active() {
+		heap.Remove(&c.timers, t.index)
+	}
+}
+
+// timerLoop 等待最早的计时器到期或被提前，依次触发所有到期的计时器。
+// 每个计时器在自己的到期时刻触发，不受收发报文的影响
+func (c *Conn) timerLoop() {
+	t := time.NewTimer(time.Hour)
+	defer t.Stop()
+	for {
+		c.mu.Lock()
+		now := time.Now()
+		for len(c.timers) > 0 && !now.Before(c.timers[0].at) && c.state != stateClosed {
+			heap.Pop(&c.timers).(*timer).fire(now)
+		}
+		if len(c.timers) > 0 {
+			t.Reset(c.timers[0].at.Sub(now))
+		} else {
+			t.Stop()
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-c.done:
+			return
+		case <-c.wake:
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package rdt
+
+import (
+	"container/heap"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTimerHeap(t *testing.T) {
+	c := newConn(nil, nil, Config{})
+	base := time.Unix(0, 0)
+	var fired []int
+	timers := make([]*timer, 6)
+	for i := range timers {
+		timers[i] = newTimer(func(time.Time) { fired = append(fired, i) })
+	}
+	for i, d := range []int{50, 10, 40, 30, 20, 60} {
+		c.schedule(timers[i], base.Add(time.Duration(d)))
+	}
+	// 重新设置和取消
+	c.schedule(timers[5], base.Add(5))
+	c.cancel(timers[2])
+	c.cancel(timers[2])
+	if timers[2].active() || !timers[0].active() {
+		t.Fatal("active state wrong after cancel")
+	}
+	for len(c.timers) > 0 {
+		heap.Pop(&c.timers).(*timer).fire(base)
+	}
+	want := []int{5, 1, 4, 3, 0}
+	if len(fired) != len(want) {
+		t.Fatalf("fired %v, want %v", fired, want)
+	}
+	for i := range want {
+		if fired[i] != want[i] {
+			t.Fatalf("fired %v, want %v", fired, want)
+		}
+	}
+}
+
+// dropFirstConn 丢弃指定序列号的数据报文的第一次发送，并记录每个数据报文的发送时间
+type dropFirstConn struct {
+	net.PacketConn
+	mu    sync.Mutex
+	drop  map[uint32]bool
+	sends map[uint32][]time.Time
+}
+
+func (d *dropFirstConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	now := time.Now()
+	if p, err := Decode(b); err == nil && p.Type == TypeData {
+		d.mu.Lock()
+		d.sends[p.Seq] = append(d.sends[p.Seq], now)
+		first := len(d.sends[p.Seq]) == 1
+		drop := first && d.drop[p.Seq]
+		d.mu.Unlock()
+		if drop {
+			return len(b), nil
+		}
+	}
+	return d.PacketConn.WriteTo(b, addr)
+}
+
+// TestRetransmitLatency SR 的每个报文在自己的超时时刻重传，
+// 不受其他报文的确认流量影响，也不会因为轮询而推迟
+func TestRetransmitLatency(t *testing.T) {
+	const timeout = 300 * time.Millisecond
+	cfg := Config{Protocol: SR, Window: 16, Timeout: timeout, MSS: 100, AckDelay: -1, FixedRTO: true}
+	l, err := Listen("udp", "127.0.0.1:0", &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := make(chan int, 1)
+	go func() {
+		nc, err := l.Accept()
+		if err != nil {
+			received <- 0
+			return
+		}
+		b, _ := io.ReadAll(nc)
+		_ = nc.Close()
+		received <- len(b)
+	}()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	dropped := []uint32{1, 4, 6, 11}
+	dc := &dropFirstConn{PacketConn: pc, drop: make(map[uint32]bool), sends: make(map[uint32][]time.Time)}
+	for _, seq := range dropped {
+		dc.drop[seq] = true
+	}
+	c, err := DialPacket(dc, l.Addr(), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 报文分几批写入，丢失的报文等待重传期间其他报文持续被发送和确认
+	payload := testPayload(1600)
+	for i := 0; i < len(payload); i += 400 {
+		if _, err := c.Write(payload[i : i+400]); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(timeout / 5)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := <-received; n != len(payload) {
+		t.Fatalf("received %d bytes, want %d", n, len(payload))
+	}
+
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	for _, seq := range dropped {
+		sends := dc.sends[seq]
+		if len(sends) != 2 {
+			t.Errorf("seq %d sent %d times, want 2", seq, len(sends))
+			continue
+		}
+		late := sends[1].Sub(sends[0]) - timeout
+		t.Logf("seq %d retransmitted %v after its deadline", seq, late)
+		if late < 0 || late > timeout/20 {
+			t.Errorf("seq %d retransmitted %v after its deadline, want within %v", seq, late, timeout/20)
+		}
+	}
+	if s := c.Stats(); s.Retransmissions != int64(len(dropped)) || s.Timeouts != int64(len(dropped)) {
+		t.Errorf("%d retransmissions and %d timeouts, want %d", s.Retransmissions, s.Timeouts, len(dropped))
+	}
+}